@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"mixloop/utils"
+)
+
+// FileAnalysis is the analysis of one uploaded file
+type FileAnalysis struct {
+	Index int    `json:"index"`
+	Name  string `json:"name"`
+	*utils.AudioAnalysis
+}
+
+// AnalyzeAudioHandler returns format, tempo and key information for uploaded files
+func AnalyzeAudioHandler(w http.ResponseWriter, r *http.Request) {
+	sessionDir := filepath.Join(utils.GlobalConfig.WorkDir, fmt.Sprintf("analyze_%d", time.Now().UnixNano()))
+	os.MkdirAll(sessionDir, 0755)
+	defer os.RemoveAll(sessionDir)
+
+	files, err := streamUpload(w, r, sessionDir, map[string]string{"audio_files": "input"}, utils.GlobalConfig.MaxUploadFiles)
+	if err != nil {
+		writeUploadError(w, err)
+		return
+	}
+	assetIDs := r.FormValue("asset_ids")
+	if len(files) == 0 && strings.TrimSpace(assetIDs) == "" {
+		http.Error(w, "No audio files provided", http.StatusBadRequest)
+		return
+	}
+
+	analyzer := utils.NewAudioAnalyzer()
+	results := make([]FileAnalysis, 0, len(files))
+	for i, file := range files {
+		filePath := file.Path
+		if err := analyzer.Validator.ValidateFile(filePath); err != nil {
+			http.Error(w, fmt.Sprintf("File %d (%s): %v", i+1, file.Name, err), http.StatusBadRequest)
+			return
+		}
+
+		analysis, err := analyzer.Analyze(filePath)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to analyze %s: %v", file.Name, err), http.StatusInternalServerError)
+			return
+		}
+
+		results = append(results, FileAnalysis{
+			Index:         i,
+			Name:          file.Name,
+			AudioAnalysis: analysis,
+		})
+	}
+
+	// Library assets were analyzed when they were imported
+	if strings.TrimSpace(assetIDs) != "" {
+		for _, id := range strings.Split(assetIDs, ",") {
+			asset, ok := utils.GlobalAssetStore.Get(strings.TrimSpace(id))
+			if !ok {
+				http.Error(w, fmt.Sprintf("Invalid asset_ids: asset %s not found", strings.TrimSpace(id)), http.StatusBadRequest)
+				return
+			}
+			results = append(results, FileAnalysis{
+				Index:         len(results),
+				Name:          asset.Name,
+				AudioAnalysis: asset.Analysis,
+			})
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(results)
+}
@@ -0,0 +1,105 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"mixloop/utils"
+)
+
+// AssetImport is the result of adding one uploaded file to the library
+type AssetImport struct {
+	*utils.Asset
+	Duplicate bool `json:"duplicate"` // the contents were already in the library
+}
+
+// AssetUploadHandler adds the uploaded audio_files to the asset library
+func AssetUploadHandler(w http.ResponseWriter, r *http.Request) {
+	uploadDir := filepath.Join(utils.GlobalConfig.WorkDir, fmt.Sprintf("assets_%d", time.Now().UnixNano()))
+	os.MkdirAll(uploadDir, 0755)
+	defer os.RemoveAll(uploadDir)
+
+	files, err := streamUpload(w, r, uploadDir, map[string]string{"audio_files": "asset"}, utils.GlobalConfig.MaxUploadFiles)
+	if err != nil {
+		writeUploadError(w, err)
+		return
+	}
+	if len(files) == 0 {
+		http.Error(w, "No audio files provided", http.StatusBadRequest)
+		return
+	}
+
+	status := http.StatusOK
+	imports := make([]AssetImport, 0, len(files))
+	for i, file := range files {
+		asset, created, err := utils.GlobalAssetStore.Import(file.Path, file.Name)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("File %d (%s): %v", i+1, file.Name, err), http.StatusBadRequest)
+			return
+		}
+		if created {
+			status = http.StatusCreated
+		}
+		imports = append(imports, AssetImport{Asset: asset, Duplicate: !created})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(imports)
+}
+
+// AssetListHandler lists the asset library
+func AssetListHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(utils.GlobalAssetStore.List())
+}
+
+// AssetGetHandler returns one asset with its cached analysis
+func AssetGetHandler(w http.ResponseWriter, r *http.Request) {
+	asset, ok := utils.GlobalAssetStore.Get(mux.Vars(r)["id"])
+	if !ok {
+		http.Error(w, "Asset not found", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(asset)
+}
+
+// AssetDeleteHandler removes an asset from the library
+func AssetDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	if err := utils.GlobalAssetStore.Delete(mux.Vars(r)["id"]); err != nil {
+		http.Error(w, "Asset not found", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// resolveAssets looks up a comma separated list of asset IDs, returning their files, names
+// and the formats probed at import
+func resolveAssets(ids string) ([]string, []string, utils.FormatCache, error) {
+	if strings.TrimSpace(ids) == "" {
+		return nil, nil, nil, nil
+	}
+
+	var files, names []string
+	formats := make(utils.FormatCache)
+	for _, id := range strings.Split(ids, ",") {
+		path, asset, err := utils.GlobalAssetStore.Resolve(strings.TrimSpace(id))
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("Invalid asset_ids: %v", err)
+		}
+		files = append(files, path)
+		names = append(names, asset.Name)
+		if asset.Format != nil {
+			formats[path] = asset.Format
+		}
+	}
+	return files, names, formats, nil
+}
@@ -1,150 +1,221 @@
 package handlers
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
-	"io"
 	"net/http"
 	"os"
 	"path/filepath"
-	"strconv"
 	"time"
 
 	"mixloop/utils"
 )
 
 func MixAudioHandler(w http.ResponseWriter, r *http.Request) {
-	// Parse multipart form
-	err := r.ParseMultipartForm(32 << 20) // 32MB max
+	// Stream the uploads straight to disk; form fields are available once they are read
+	sessionDir := filepath.Join(utils.GlobalConfig.WorkDir, fmt.Sprintf("upload_%d", time.Now().UnixNano()))
+	os.MkdirAll(sessionDir, 0755)
+	defer os.RemoveAll(sessionDir) // Clean up after processing
+
+	uploads, err := streamUpload(w, r, sessionDir, map[string]string{"audio_files": "input", "cover": "cover_upload"}, utils.GlobalConfig.MaxUploadFiles)
 	if err != nil {
-		http.Error(w, "Failed to parse form", http.StatusBadRequest)
+		writeUploadError(w, err)
 		return
 	}
 
 	// Parse form parameters
-	loopsStr := r.FormValue("loops")
-	crossfadeStr := r.FormValue("crossfade")
-	enhanceStr := r.FormValue("enhance")
-	dolbyStereoStr := r.FormValue("dolby_stereo")
-	formatStr := r.FormValue("format")
-
-	loops := 1
-	if loopsStr != "" {
-		if parsedLoops, err := strconv.Atoi(loopsStr); err == nil && parsedLoops > 0 {
-			loops = parsedLoops
-		}
-	}
-
-	crossfade := 2.0
-	if crossfadeStr != "" {
-		if parsedCrossfade, err := strconv.ParseFloat(crossfadeStr, 64); err == nil && parsedCrossfade >= 0 {
-			crossfade = parsedCrossfade
-		}
+	options, err := parseMixOptions(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
-
-	enhance := true // Default to true
-	if enhanceStr != "" {
-		enhance = enhanceStr == "true"
+	previews := r.FormValue("previews") == "true"
+	streamOptions, err := parseStreamOptions(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
 
-	dolbyStereo := false // Default to false
-	if dolbyStereoStr != "" {
-		dolbyStereo = dolbyStereoStr == "true"
+	// Get uploaded files
+	var savedFiles []string
+	var inputNames []string
+	var coverUpload *uploadedFile
+	for i, upload := range uploads {
+		if upload.Field == "cover" {
+			coverUpload = &uploads[i]
+			continue
+		}
+		savedFiles = append(savedFiles, upload.Path)
+		inputNames = append(inputNames, upload.Name)
 	}
-
-	format := "mp3" // Default format
-	if formatStr == "wav" {
-		format = "wav"
+	// Library assets are read in place and follow the uploaded files
+	assetFiles, assetNames, assetFormats, err := resolveAssets(r.FormValue("asset_ids"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
-
-	// Get uploaded files
-	files := r.MultipartForm.File["audio_files"]
-	if len(files) == 0 {
+	savedFiles = append(savedFiles, assetFiles...)
+	inputNames = append(inputNames, assetNames...)
+	if len(savedFiles) == 0 {
 		http.Error(w, "No audio files provided", http.StatusBadRequest)
 		return
 	}
-
-	// Create unique session directory
-	sessionID := fmt.Sprintf("%d", time.Now().UnixNano())
-	sessionDir := filepath.Join("uploads", sessionID)
-	os.MkdirAll(sessionDir, 0755)
-	defer os.RemoveAll(sessionDir) // Clean up after processing
-
-	// Save uploaded files
-	var savedFiles []string
-	for i, fileHeader := range files {
-		file, err := fileHeader.Open()
-		if err != nil {
-			http.Error(w, "Failed to open uploaded file", http.StatusInternalServerError)
+	for _, track := range options.Tracks {
+		if track.Index < 0 || track.Index >= len(savedFiles) {
+			http.Error(w, fmt.Sprintf("Invalid track_options index: %d", track.Index), http.StatusBadRequest)
 			return
 		}
-		defer file.Close()
-
-		// Save file to disk
-		filename := fmt.Sprintf("input_%d%s", i, filepath.Ext(fileHeader.Filename))
-		filePath := filepath.Join(sessionDir, filename)
-		
-		dst, err := os.Create(filePath)
-		if err != nil {
-			http.Error(w, "Failed to save file", http.StatusInternalServerError)
+	}
+	if options.Order == utils.OrderExplicit {
+		if err := utils.ValidateOrderIndices(options.OrderIndices, len(savedFiles)); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid order_indices: %v", err), http.StatusBadRequest)
 			return
 		}
-		defer dst.Close()
+	}
+
+	// The result is keyed by an unguessable ID of its own; the client's ID only names the
+	// progress channel it is already listening on
+	sessionID, err := newSessionID()
+	if err != nil {
+		http.Error(w, "Failed to create session", http.StatusInternalServerError)
+		return
+	}
+	progressID := progressIDFromRequest(r, sessionID)
 
-		_, err = io.Copy(dst, file)
+	// Optional cover art for the output
+	if coverUpload != nil {
+		art, err := prepareCoverUpload(*coverUpload, sessionDir)
 		if err != nil {
-			http.Error(w, "Failed to write file", http.StatusInternalServerError)
+			http.Error(w, fmt.Sprintf("Invalid cover %s: %v", coverUpload.Name, err), http.StatusBadRequest)
 			return
 		}
+		options.Cover = art
+	}
 
-		savedFiles = append(savedFiles, filePath)
+	options.InputNames = inputNames
+	options.KnownFormats = assetFormats
+	options.Tempos = utils.NewTempoCache()
+	report := utils.NewJobReport(sessionID)
+	options.Report = report
+	if options.Cover != nil {
+		report.SetCover(*options.Cover)
 	}
 
 	// Generate output filename with proper extension
-	var outputFile string
-	if format == "wav" {
-		outputFile = filepath.Join("output", fmt.Sprintf("mix_%s.wav", sessionID))
-	} else {
-		outputFile = filepath.Join("output", fmt.Sprintf("mix_%s.mp3", sessionID))
+	spec := utils.OutputFormatSpecFor(options.Format)
+	options.OutputName = "mixloop_output" + spec.Extension
+	resultDir, err := utils.GlobalResultStore.SessionDir(sessionID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	outputFile := filepath.Join(resultDir, options.OutputName)
+	if options.ExportStems {
+		options.StemsDir = filepath.Join(resultDir, "stems")
 	}
 
-	// Use existing session ID for progress tracking
-	
 	// Choose processing method based on file count
 	if len(savedFiles) > 20 {
 		// Use batch processor for large sets
 		batchProcessor := utils.NewBatchProcessor(sessionDir)
 		batchProcessor.OptimizeForLargeFiles(len(savedFiles))
-		err = batchProcessor.ProcessLargeAudioSetWithStereo(savedFiles, outputFile, loops, crossfade, enhance, dolbyStereo, format, sessionID)
+		err = batchProcessor.ProcessLargeAudioSetWithMixOptions(savedFiles, outputFile, options, progressID)
 	} else {
 		// Use regular processing for smaller sets
 		manager := utils.NewAudioManager(sessionDir)
-		err = manager.ProcessAudioSequenceWithProgressAndStereo(savedFiles, outputFile, loops, crossfade, enhance, dolbyStereo, format, sessionID)
+		err = manager.ProcessAudioSequenceWithMixOptions(savedFiles, outputFile, options, progressID)
 	}
 	
 	if err != nil {
 		fmt.Printf("Audio processing error: %v\n", err)
+		os.RemoveAll(resultDir)
 		http.Error(w, fmt.Sprintf("Failed to process audio: %v", err), http.StatusInternalServerError)
 		return
 	}
-	defer os.Remove(outputFile) // Clean up output file after sending
+	// The output stays in the result store for the zip download until it expires
 
-	// Send result file with proper headers
-	if format == "wav" {
-		w.Header().Set("Content-Type", "audio/wav")
-		w.Header().Set("Content-Disposition", "attachment; filename=mixloop_output.wav")
-	} else {
-		w.Header().Set("Content-Type", "audio/mpeg")
-		w.Header().Set("Content-Disposition", "attachment; filename=mixloop_output.mp3")
+	if previews {
+		mixPreviews, err := generateMixPreviews(savedFiles, inputNames, outputFile)
+		if err != nil {
+			fmt.Printf("Preview generation error: %v\n", err)
+		} else {
+			report.SetPreviews(mixPreviews)
+		}
 	}
-
-	outputData, err := os.ReadFile(outputFile)
+	if err := utils.GlobalResultStore.WriteSidecars(sessionID, options.OutputName, report, options.Metadata); err != nil {
+		fmt.Printf("Sidecar generation error: %v\n", err)
+	}
+	result, err := utils.GlobalResultStore.DescribeOutput(sessionID, options.OutputName)
 	if err != nil {
-		http.Error(w, "Failed to read output file", http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	report.SetOutput(*result)
+	if streamOptions != nil {
+		stream, err := utils.NewStreamSegmenter(*streamOptions).Segment(outputFile, resultDir)
+		if err != nil {
+			fmt.Printf("Stream segmentation error: %v\n", err)
+			http.Error(w, fmt.Sprintf("Failed to segment audio: %v", err), http.StatusInternalServerError)
+			return
+		}
+		stream.Playlist = streamURL(sessionID, stream.Playlist)
+		if stream.Manifest != "" {
+			stream.Manifest = streamURL(sessionID, stream.Manifest)
+		}
+		report.SetStream(*stream)
+	}
+	if err := utils.GlobalResultStore.Publish(sessionID); err != nil {
+		fmt.Printf("Result publish error: %v\n", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	utils.GlobalJobStore.Save(report)
+	if err := utils.GlobalResultStore.SaveReport(report); err != nil {
+		fmt.Printf("Report save error: %v\n", err)
+	}
+
+	w.Header().Set("X-Session-ID", sessionID)
+
+	// Streamed results are fetched by the player, so the response only points at them
+	if streamOptions != nil {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(report)
+		return
+	}
+
+	// Stream the result from the storage; GET /api/result?format=audio serves the same file for resuming
+	utils.GlobalResultStore.ServeOutput(w, r, sessionID, *result)
+}
 
-	w.Write(outputData)
+// prepareCoverUpload scales an uploaded cover image for embedding
+func prepareCoverUpload(upload uploadedFile, dir string) (*utils.CoverArt, error) {
+	file, err := os.Open(upload.Path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return utils.PrepareCoverArt(file, dir, utils.GlobalConfig.CoverMaxDimension)
+}
+
+// newSessionID generates the random ID a job's results are stored and fetched under
+func newSessionID() (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(id), nil
+}
+
+// progressIDFromRequest reuses the client's session ID for progress tracking when it is safe
+// to use, and falls back to the result's session ID
+func progressIDFromRequest(r *http.Request, sessionID string) string {
+	progressID := r.FormValue("session_id")
+	if utils.SessionIDPattern.MatchString(progressID) {
+		return progressID
+	}
+	return sessionID
 }
 
 func HealthCheckHandler(w http.ResponseWriter, r *http.Request) {
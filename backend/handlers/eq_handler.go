@@ -0,0 +1,55 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"mixloop/utils"
+)
+
+// EQResponseRequest is the body of an EQ response request
+type EQResponseRequest struct {
+	Bands      []utils.EQBand `json:"bands"`
+	SampleRate float64        `json:"sample_rate"`
+	Points     int            `json:"points"`
+}
+
+// EQResponseResponse is the combined magnitude response of a set of EQ bands
+type EQResponseResponse struct {
+	SampleRate float64         `json:"sample_rate"`
+	Bands      []utils.EQBand  `json:"bands"`
+	Response   []utils.EQPoint `json:"response"`
+}
+
+// EQResponseHandler returns the magnitude response curve of a list of EQ bands so the UI can draw it
+func EQResponseHandler(w http.ResponseWriter, r *http.Request) {
+	var request EQResponseRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	if err := utils.ValidateEQ(request.Bands); err != nil {
+		http.Error(w, "Invalid bands: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if request.SampleRate == 0 {
+		request.SampleRate = 48000
+	}
+	if request.SampleRate < 8000 || request.SampleRate > 192000 {
+		http.Error(w, "sample_rate must be between 8000 and 192000", http.StatusBadRequest)
+		return
+	}
+	if request.Points != 0 && (request.Points < 2 || request.Points > 2048) {
+		http.Error(w, "points must be between 2 and 2048", http.StatusBadRequest)
+		return
+	}
+
+	response := EQResponseResponse{
+		SampleRate: request.SampleRate,
+		Bands:      request.Bands,
+		Response:   utils.EQResponse(request.Bands, request.SampleRate, request.Points),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,251 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"mixloop/utils"
+)
+
+// parseMixOptions reads the mix settings from the request form
+func parseMixOptions(r *http.Request) (utils.MixOptions, error) {
+	options := utils.DefaultMixOptions()
+
+	if parsedLoops, err := strconv.Atoi(r.FormValue("loops")); err == nil && parsedLoops > 0 {
+		options.Loops = parsedLoops
+	}
+
+	if parsedCrossfade, err := strconv.ParseFloat(r.FormValue("crossfade"), 64); err == nil && parsedCrossfade >= 0 {
+		options.Crossfade = parsedCrossfade
+	}
+
+	switch unit := r.FormValue("crossfade_unit"); unit {
+	case utils.CrossfadeUnitBeats, utils.CrossfadeUnitBars, utils.CrossfadeUnitSeconds:
+		options.CrossfadeUnit = unit
+	case "":
+	default:
+		return options, fmt.Errorf("Invalid crossfade_unit: %s", unit)
+	}
+
+	if enhanceStr := r.FormValue("enhance"); enhanceStr != "" {
+		options.Enhance = enhanceStr == "true"
+	}
+
+	// Stereo processing; dolby_stereo=true is kept as an alias for widening
+	switch mode := r.FormValue("stereo_mode"); {
+	case mode != "":
+		if !utils.IsValidStereoMode(mode) {
+			return options, fmt.Errorf("Invalid stereo_mode: %s", mode)
+		}
+		options.StereoMode = mode
+	case r.FormValue("dolby_stereo") == "true":
+		options.StereoMode = utils.StereoModeWiden
+	}
+	if widthStr := r.FormValue("stereo_width"); widthStr != "" {
+		width, err := strconv.ParseFloat(widthStr, 64)
+		if err != nil || width < 0 || width > 4 {
+			return options, fmt.Errorf("Invalid stereo_width: %s", widthStr)
+		}
+		options.StereoWidth = width
+	}
+	if delayStr := r.FormValue("haas_delay"); delayStr != "" {
+		delay, err := strconv.ParseFloat(delayStr, 64)
+		if err != nil || delay < 1 || delay > 40 {
+			return options, fmt.Errorf("Invalid haas_delay: %s", delayStr)
+		}
+		options.HaasDelay = delay
+	}
+	if correlationStr := r.FormValue("stereo_min_correlation"); correlationStr != "" {
+		correlation, err := strconv.ParseFloat(correlationStr, 64)
+		if err != nil || correlation < -1 || correlation > 1 {
+			return options, fmt.Errorf("Invalid stereo_min_correlation: %s", correlationStr)
+		}
+		options.StereoMinCorrelation = correlation
+	}
+
+	if format := r.FormValue("format"); utils.IsValidOutputFormat(format) {
+		options.Format = format
+	}
+
+	// Output tags, empty fields fall back to the server defaults
+	metadata := utils.Metadata{
+		Title:   r.FormValue("title"),
+		Artist:  r.FormValue("artist"),
+		Album:   r.FormValue("album"),
+		Genre:   r.FormValue("genre"),
+		Year:    r.FormValue("year"),
+		Comment: r.FormValue("comment"),
+		ISRC:    r.FormValue("isrc"),
+	}
+	if custom := r.FormValue("metadata"); custom != "" {
+		if err := json.Unmarshal([]byte(custom), &metadata.Custom); err != nil {
+			return options, fmt.Errorf("Invalid metadata: %v", err)
+		}
+	}
+	if err := metadata.Validate(); err != nil {
+		return options, fmt.Errorf("Invalid metadata: %v", err)
+	}
+	options.Metadata = metadata.WithDefaults(utils.GlobalConfig.DefaultMetadata)
+	options.Chapters = r.FormValue("chapters") != "false"
+	options.ExportStems = r.FormValue("export_stems") == "true"
+
+	options.NormalizeFormat = r.FormValue("normalize_format") != "false"
+
+	// Output limiter
+	options.Limiter = r.FormValue("limiter") != "false"
+	if ceilingStr := r.FormValue("limiter_ceiling"); ceilingStr != "" {
+		ceiling, err := strconv.ParseFloat(ceilingStr, 64)
+		if err != nil || ceiling < -12 || ceiling > 0 {
+			return options, fmt.Errorf("Invalid limiter_ceiling: %s", ceilingStr)
+		}
+		options.LimiterCeiling = ceiling
+	}
+
+	// Noise and hum removal for the whole mix
+	options.Cleanup = utils.CleanupOptions{
+		Denoise: r.FormValue("denoise"),
+		Dehum:   r.FormValue("dehum"),
+	}
+	if strength := r.FormValue("denoise_strength"); strength != "" {
+		parsed, err := strconv.ParseFloat(strength, 64)
+		if err != nil {
+			return options, fmt.Errorf("Invalid denoise_strength: %s", strength)
+		}
+		options.Cleanup.DenoiseStrength = parsed
+	}
+	if harmonics := r.FormValue("hum_harmonics"); harmonics != "" {
+		parsed, err := strconv.Atoi(harmonics)
+		if err != nil {
+			return options, fmt.Errorf("Invalid hum_harmonics: %s", harmonics)
+		}
+		options.Cleanup.HumHarmonics = parsed
+	}
+	if err := options.Cleanup.Validate(); err != nil {
+		return options, fmt.Errorf("Invalid cleanup options: %v", err)
+	}
+
+	// Master EQ as a JSON array of bands
+	if eq := r.FormValue("eq"); eq != "" {
+		if err := json.Unmarshal([]byte(eq), &options.EQ); err != nil {
+			return options, fmt.Errorf("Invalid eq: %v", err)
+		}
+		if err := utils.ValidateEQ(options.EQ); err != nil {
+			return options, fmt.Errorf("Invalid eq: %v", err)
+		}
+	}
+
+	// Gate and multiband compression: a preset name, custom settings as JSON, or both
+	if settings := r.FormValue("dynamics_settings"); settings != "" {
+		if err := json.Unmarshal([]byte(settings), &options.Dynamics); err != nil {
+			return options, fmt.Errorf("Invalid dynamics_settings: %v", err)
+		}
+	}
+	if preset := r.FormValue("dynamics"); preset != "" {
+		options.Dynamics.Preset = preset
+	}
+	if err := options.Dynamics.Validate(); err != nil {
+		return options, fmt.Errorf("Invalid dynamics: %v", err)
+	}
+
+	// Per-track settings as a JSON array of {"index": n, ...}
+	if trackOptions := r.FormValue("track_options"); trackOptions != "" {
+		if err := json.Unmarshal([]byte(trackOptions), &options.Tracks); err != nil {
+			return options, fmt.Errorf("Invalid track_options: %v", err)
+		}
+		for i := range options.Tracks {
+			if err := options.Tracks[i].CleanupOptions.Validate(); err != nil {
+				return options, fmt.Errorf("Invalid track_options for track %d: %v", options.Tracks[i].Index, err)
+			}
+			if err := utils.ValidateEQ(options.Tracks[i].EQ); err != nil {
+				return options, fmt.Errorf("Invalid track_options for track %d: %v", options.Tracks[i].Index, err)
+			}
+		}
+	}
+
+	// Silence trimming
+	options.TrimSilence = r.FormValue("trim_silence") == "true"
+	if threshold, err := strconv.ParseFloat(r.FormValue("silence_threshold"), 64); err == nil && threshold < 0 {
+		options.SilenceThreshold = threshold
+	}
+	if minDuration, err := strconv.ParseFloat(r.FormValue("silence_min_duration"), 64); err == nil && minDuration > 0 {
+		options.SilenceMinDuration = minDuration
+	}
+
+	// Tempo matching
+	if targetBPM := r.FormValue("target_bpm"); targetBPM == "first" {
+		options.MatchFirstTempo = true
+	} else if targetBPM != "" {
+		bpm, err := strconv.ParseFloat(targetBPM, 64)
+		if err != nil || bpm < 40 || bpm > 250 {
+			return options, fmt.Errorf("Invalid target_bpm: %s", targetBPM)
+		}
+		options.TargetBPM = bpm
+	}
+	if maxStretch, err := strconv.ParseFloat(r.FormValue("max_stretch"), 64); err == nil && maxStretch > 0 {
+		options.MaxStretchPercent = maxStretch
+	}
+
+	// Sequence order
+	if order := r.FormValue("order"); order != "" {
+		if !utils.IsValidOrder(order) {
+			return options, fmt.Errorf("Invalid order: %s", order)
+		}
+		options.Order = order
+	}
+	if indices := r.FormValue("order_indices"); indices != "" {
+		for _, part := range strings.Split(indices, ",") {
+			index, err := strconv.Atoi(strings.TrimSpace(part))
+			if err != nil {
+				return options, fmt.Errorf("Invalid order_indices: %s", indices)
+			}
+			options.OrderIndices = append(options.OrderIndices, index)
+		}
+		options.Order = utils.OrderExplicit
+	}
+	if seed := r.FormValue("order_seed"); seed != "" {
+		parsedSeed, err := strconv.ParseInt(seed, 10, 64)
+		if err != nil {
+			return options, fmt.Errorf("Invalid order_seed: %s", seed)
+		}
+		options.OrderSeed = parsedSeed
+	} else {
+		options.OrderSeed = time.Now().UnixNano()
+	}
+	options.ReshuffleLoops = r.FormValue("reshuffle_loops") == "true"
+	options.SeamlessLoop = r.FormValue("seamless_loop") == "true"
+	options.Gapless = r.FormValue("gapless") == "true"
+
+	return options, nil
+}
+
+// parseStreamOptions reads the segmented delivery options; it returns nil unless delivery=hls
+func parseStreamOptions(r *http.Request) (*utils.StreamOptions, error) {
+	switch delivery := r.FormValue("delivery"); delivery {
+	case "", "file":
+		return nil, nil
+	case "hls":
+	default:
+		return nil, fmt.Errorf("Invalid delivery: %s", delivery)
+	}
+
+	options := utils.DefaultStreamOptions()
+	if codec := r.FormValue("stream_codec"); codec != "" {
+		options.Codec = codec
+	}
+	if duration := r.FormValue("segment_duration"); duration != "" {
+		parsed, err := strconv.ParseFloat(duration, 64)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid segment_duration: %s", duration)
+		}
+		options.SegmentDuration = parsed
+	}
+	options.DASH = r.FormValue("dash") == "true"
+	if err := options.Validate(); err != nil {
+		return nil, fmt.Errorf("Invalid stream options: %v", err)
+	}
+	return &options, nil
+}
@@ -0,0 +1,121 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"mixloop/utils"
+)
+
+// WaveformHandler returns min/max waveform peaks for an uploaded file
+func WaveformHandler(w http.ResponseWriter, r *http.Request) {
+	inputFile, cleanup, err := saveSingleUpload(w, r, "audio_file")
+	if err != nil {
+		writeUploadError(w, err)
+		return
+	}
+	defer cleanup()
+
+	samplesPerPixel, _ := strconv.Atoi(r.FormValue("samples_per_pixel"))
+	bits, _ := strconv.Atoi(r.FormValue("bits"))
+
+	peaks, id, err := utils.GlobalPreviewGenerator.WaveformPeaks(inputFile, samplesPerPixel, bits)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to compute waveform: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	// Binary output matches the audiowaveform .dat layout used by peaks.js
+	if r.FormValue("format") == "dat" {
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s", id))
+		peaks.WriteBinary(w)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(peaks)
+}
+
+// SpectrogramHandler returns a spectrogram PNG for an uploaded file
+func SpectrogramHandler(w http.ResponseWriter, r *http.Request) {
+	inputFile, cleanup, err := saveSingleUpload(w, r, "audio_file")
+	if err != nil {
+		writeUploadError(w, err)
+		return
+	}
+	defer cleanup()
+
+	width, _ := strconv.Atoi(r.FormValue("width"))
+	height, _ := strconv.Atoi(r.FormValue("height"))
+
+	id, err := utils.GlobalPreviewGenerator.Spectrogram(inputFile, width, height)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to render spectrogram: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	utils.GlobalPreviewGenerator.ServePreview(w, r, id)
+}
+
+// PreviewFileHandler serves a cached preview by ID
+func PreviewFileHandler(w http.ResponseWriter, r *http.Request) {
+	utils.GlobalPreviewGenerator.ServePreview(w, r, mux.Vars(r)["id"])
+}
+
+// generateMixPreviews renders spectrograms for every input and the final mix
+func generateMixPreviews(inputFiles, inputNames []string, outputFile string) (*utils.MixPreviews, error) {
+	previews := &utils.MixPreviews{}
+
+	for i, file := range inputFiles {
+		id, err := utils.GlobalPreviewGenerator.Spectrogram(file, 0, 0)
+		if err != nil {
+			return nil, fmt.Errorf("input %d: %v", i+1, err)
+		}
+		previews.Inputs = append(previews.Inputs, utils.PreviewRef{
+			Index:       i,
+			Name:        inputNames[i],
+			Spectrogram: "/api/preview/" + id,
+		})
+	}
+
+	id, err := utils.GlobalPreviewGenerator.Spectrogram(outputFile, 0, 0)
+	if err != nil {
+		return nil, fmt.Errorf("mix: %v", err)
+	}
+	previews.Mix = &utils.PreviewRef{
+		Index:       -1,
+		Name:        filepath.Base(outputFile),
+		Spectrogram: "/api/preview/" + id,
+	}
+
+	return previews, nil
+}
+
+// saveSingleUpload streams the one file of field into a temporary directory
+func saveSingleUpload(w http.ResponseWriter, r *http.Request, field string) (string, func(), error) {
+	dir := filepath.Join(utils.GlobalConfig.WorkDir, fmt.Sprintf("preview_%d", time.Now().UnixNano()))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", nil, &uploadError{http.StatusInternalServerError, "Failed to save file"}
+	}
+	cleanup := func() { os.RemoveAll(dir) }
+
+	files, err := streamUpload(w, r, dir, map[string]string{field: "input"}, 1)
+	if err != nil {
+		cleanup()
+		return "", nil, err
+	}
+	if len(files) == 0 {
+		cleanup()
+		return "", nil, fmt.Errorf("No audio file provided")
+	}
+
+	return files[0].Path, cleanup, nil
+}
@@ -0,0 +1,41 @@
+package handlers
+
+import (
+	"net/http"
+	"path/filepath"
+	"regexp"
+
+	"github.com/gorilla/mux"
+
+	"mixloop/utils"
+)
+
+// streamFilePattern limits stream file names to what the segmenter writes
+var streamFilePattern = regexp.MustCompile(`^[A-Za-z0-9_-]+\.[a-z0-9]+$`)
+
+// streamURL is the route a stream file of a session is served from
+func streamURL(sessionID, file string) string {
+	return "/api/stream/" + sessionID + "/" + filepath.ToSlash(file)
+}
+
+// StreamFileHandler serves HLS and DASH playlists and segments from the result store
+func StreamFileHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	sessionID, kind, file := vars["session"], vars["kind"], vars["file"]
+	contentType := utils.StreamContentType(file)
+	if !utils.SessionIDPattern.MatchString(sessionID) || (kind != "hls" && kind != "dash") ||
+		!streamFilePattern.MatchString(file) || contentType == "" {
+		http.Error(w, "Stream file not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	switch filepath.Ext(file) {
+	case ".m3u8", ".mpd":
+		// Playlists are revalidated, so players notice when the result has expired
+		w.Header().Set("Cache-Control", "no-cache")
+	default:
+		w.Header().Set("Cache-Control", "public, max-age=86400")
+	}
+	utils.GlobalResultStore.ServeFile(w, r, sessionID, kind+"/"+file)
+}
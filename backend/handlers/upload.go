@@ -0,0 +1,171 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+
+	"mixloop/utils"
+)
+
+// maxFormValueBytes bounds the non-file fields of an upload, like ParseMultipartForm does
+const maxFormValueBytes = 10 << 20
+
+// uploadedFile is a file part of a multipart request, saved to disk
+type uploadedFile struct {
+	Field string
+	Name  string // file name sent by the client
+	Path  string
+}
+
+// uploadError is a failed upload and the status to answer it with
+type uploadError struct {
+	Status  int
+	Message string
+}
+
+func (e *uploadError) Error() string {
+	return e.Message
+}
+
+// writeUploadError answers with the status of an uploadError, or 400 for other errors
+func writeUploadError(w http.ResponseWriter, err error) {
+	var uploadErr *uploadError
+	if errors.As(err, &uploadErr) {
+		http.Error(w, uploadErr.Message, uploadErr.Status)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusBadRequest)
+}
+
+// streamUpload reads a multipart request part by part. Parts of the fields in fileFields are
+// written to dir as <prefix>_<n><ext>, closing each file before the next part is read; the
+// other fields are collected into r.Form so FormValue keeps working. The request is limited
+// by the MaxUpload* sizes of the configuration and to maxFiles files.
+func streamUpload(w http.ResponseWriter, r *http.Request, dir string, fileFields map[string]string, maxFiles int) ([]uploadedFile, error) {
+	config := utils.GlobalConfig
+	r.Body = http.MaxBytesReader(w, r.Body, config.MaxUploadSize)
+
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse form")
+	}
+
+	form := make(url.Values)
+	valueBytes := int64(0)
+	var files []uploadedFile
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return files, uploadReadError(err, "", config.MaxUploadSize)
+		}
+
+		field := part.FormName()
+		prefix, isFile := fileFields[field]
+		if part.FileName() != "" && !isFile {
+			// Files in fields nobody reads still count towards the request limit
+			_, err := io.Copy(io.Discard, part)
+			part.Close()
+			if err != nil {
+				return files, uploadReadError(err, part.FileName(), config.MaxUploadSize)
+			}
+			continue
+		}
+		if part.FileName() == "" {
+			// Plain form field
+			value, err := io.ReadAll(io.LimitReader(part, maxFormValueBytes-valueBytes+1))
+			part.Close()
+			if err != nil {
+				return files, uploadReadError(err, "", config.MaxUploadSize)
+			}
+			valueBytes += int64(len(value))
+			if valueBytes > maxFormValueBytes {
+				return files, &uploadError{http.StatusRequestEntityTooLarge, fmt.Sprintf("Form field %s is too large", field)}
+			}
+			if field != "" {
+				form.Add(field, string(value))
+			}
+			continue
+		}
+
+		if len(files) >= maxFiles {
+			part.Close()
+			return files, &uploadError{http.StatusRequestEntityTooLarge,
+				fmt.Sprintf("Too many files: %s exceeds the limit of %d files per request", part.FileName(), maxFiles)}
+		}
+
+		file := uploadedFile{
+			Field: field,
+			Name:  part.FileName(),
+			Path:  filepath.Join(dir, fmt.Sprintf("%s_%d%s", prefix, len(files), filepath.Ext(part.FileName()))),
+		}
+		err = saveUploadPart(part, file, config.MaxUploadFileSize)
+		part.Close()
+		if err != nil {
+			os.Remove(file.Path)
+			return files, uploadReadError(err, file.Name, config.MaxUploadSize)
+		}
+		files = append(files, file)
+	}
+
+	// Query parameters stay available next to the form fields
+	r.PostForm = form
+	r.Form = make(url.Values)
+	for key, values := range r.URL.Query() {
+		r.Form[key] = append(r.Form[key], values...)
+	}
+	for key, values := range form {
+		r.Form[key] = append(r.Form[key], values...)
+	}
+	return files, nil
+}
+
+// saveUploadPart copies one file part to disk, failing once it passes maxSize bytes
+func saveUploadPart(part io.Reader, file uploadedFile, maxSize int64) error {
+	dst, err := os.Create(file.Path)
+	if err != nil {
+		return &uploadError{http.StatusInternalServerError, "Failed to save file"}
+	}
+
+	written, err := io.Copy(dst, io.LimitReader(part, maxSize+1))
+	if err != nil {
+		dst.Close()
+		return err
+	}
+	// Close reports write errors the copy didn't see, such as a full disk
+	if err := dst.Close(); err != nil {
+		return &uploadError{http.StatusInternalServerError, "Failed to write file"}
+	}
+	if written > maxSize {
+		return &uploadError{http.StatusRequestEntityTooLarge,
+			fmt.Sprintf("File %s exceeds the per-file limit of %d MB", file.Name, maxSize>>20)}
+	}
+	return nil
+}
+
+// uploadReadError turns a failed read into a 413 when the request passed the total limit
+func uploadReadError(err error, fileName string, maxSize int64) error {
+	var uploadErr *uploadError
+	if errors.As(err, &uploadErr) {
+		return err
+	}
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		if fileName != "" {
+			return &uploadError{http.StatusRequestEntityTooLarge,
+				fmt.Sprintf("Upload exceeds the request limit of %d MB at file %s", maxSize>>20, fileName)}
+		}
+		return &uploadError{http.StatusRequestEntityTooLarge, fmt.Sprintf("Upload exceeds the request limit of %d MB", maxSize>>20)}
+	}
+	if fileName != "" {
+		return fmt.Errorf("Failed to read file %s: %v", fileName, err)
+	}
+	return fmt.Errorf("Failed to parse form: %v", err)
+}
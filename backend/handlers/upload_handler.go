@@ -0,0 +1,241 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+
+	"mixloop/utils"
+)
+
+// tusVersion is the version of the tus resumable upload protocol served under /api/uploads
+const tusVersion = "1.0.0"
+
+// tusExtensions are the supported tus protocol extensions
+const tusExtensions = "creation,creation-with-upload,termination,expiration"
+
+// checkTusVersion rejects requests without a supported Tus-Resumable header
+func checkTusVersion(w http.ResponseWriter, r *http.Request) bool {
+	w.Header().Set("Tus-Resumable", tusVersion)
+	if r.Header.Get("Tus-Resumable") != tusVersion {
+		w.Header().Set("Tus-Version", tusVersion)
+		http.Error(w, "Unsupported tus version", http.StatusPreconditionFailed)
+		return false
+	}
+	return true
+}
+
+// UploadOptionsHandler describes the tus server
+func UploadOptionsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Tus-Resumable", tusVersion)
+	w.Header().Set("Tus-Version", tusVersion)
+	w.Header().Set("Tus-Extension", tusExtensions)
+	w.Header().Set("Tus-Max-Size", strconv.FormatInt(utils.GlobalConfig.MaxUploadFileSize, 10))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// UploadCreateHandler starts a resumable upload and, with creation-with-upload, takes its first chunk
+func UploadCreateHandler(w http.ResponseWriter, r *http.Request) {
+	if !checkTusVersion(w, r) {
+		return
+	}
+	if r.Header.Get("Upload-Defer-Length") != "" {
+		http.Error(w, "Upload-Defer-Length is not supported", http.StatusBadRequest)
+		return
+	}
+	length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || length < 0 {
+		http.Error(w, "Invalid Upload-Length", http.StatusBadRequest)
+		return
+	}
+	if length > utils.GlobalConfig.MaxUploadFileSize {
+		http.Error(w, fmt.Sprintf("Upload exceeds the per-file limit of %d MB", utils.GlobalConfig.MaxUploadFileSize>>20), http.StatusRequestEntityTooLarge)
+		return
+	}
+	metadata, err := parseUploadMetadata(r.Header.Get("Upload-Metadata"))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid Upload-Metadata: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	upload, err := utils.GlobalUploadStore.Create(length, metadata)
+	if err != nil {
+		fmt.Printf("Upload creation error: %v\n", err)
+		http.Error(w, "Failed to create upload", http.StatusInternalServerError)
+		return
+	}
+	if r.Header.Get("Content-Type") == "application/offset+octet-stream" {
+		appended, err := utils.GlobalUploadStore.Append(upload.ID, 0, r.Body)
+		if err != nil {
+			fmt.Printf("Upload %s write error: %v\n", upload.ID, err)
+		}
+		if appended != nil {
+			upload = appended
+		}
+	}
+	if upload.Complete() && !importUpload(w, upload) {
+		return
+	}
+
+	w.Header().Set("Location", "/api/uploads/"+upload.ID)
+	w.Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	w.Header().Set("Upload-Expires", utils.GlobalUploadStore.Expires(upload.ID).UTC().Format(http.TimeFormat))
+	w.WriteHeader(http.StatusCreated)
+}
+
+// UploadHeadHandler reports the offset a client resumes from
+func UploadHeadHandler(w http.ResponseWriter, r *http.Request) {
+	if !checkTusVersion(w, r) {
+		return
+	}
+	id := mux.Vars(r)["id"]
+	upload, err := utils.GlobalUploadStore.Get(id)
+	if err != nil {
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	w.Header().Set("Upload-Length", strconv.FormatInt(upload.Length, 10))
+	if len(upload.Metadata) > 0 {
+		w.Header().Set("Upload-Metadata", formatUploadMetadata(upload.Metadata))
+	}
+	if upload.AssetID != "" {
+		w.Header().Set("Mixloop-Asset-Id", upload.AssetID)
+	}
+	w.Header().Set("Upload-Expires", utils.GlobalUploadStore.Expires(id).UTC().Format(http.TimeFormat))
+	w.WriteHeader(http.StatusOK)
+}
+
+// UploadPatchHandler appends a chunk at the offset the client states
+func UploadPatchHandler(w http.ResponseWriter, r *http.Request) {
+	if !checkTusVersion(w, r) {
+		return
+	}
+	if r.Header.Get("Content-Type") != "application/offset+octet-stream" {
+		http.Error(w, "Content-Type must be application/offset+octet-stream", http.StatusUnsupportedMediaType)
+		return
+	}
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		http.Error(w, "Invalid Upload-Offset", http.StatusBadRequest)
+		return
+	}
+
+	id := mux.Vars(r)["id"]
+	upload, err := utils.GlobalUploadStore.Get(id)
+	if err != nil {
+		http.Error(w, "Upload not found", http.StatusNotFound)
+		return
+	}
+	if r.ContentLength > upload.Length-offset {
+		http.Error(w, "Chunk exceeds Upload-Length", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	upload, err = utils.GlobalUploadStore.Append(id, offset, r.Body)
+	switch {
+	case errors.Is(err, utils.ErrUploadOffset):
+		http.Error(w, fmt.Sprintf("Upload-Offset %d does not match the current offset %d", offset, upload.Offset), http.StatusConflict)
+		return
+	case errors.Is(err, utils.ErrObjectNotFound), errors.Is(err, os.ErrNotExist):
+		http.Error(w, "Upload not found", http.StatusNotFound)
+		return
+	case err != nil && upload == nil:
+		fmt.Printf("Upload %s write error: %v\n", id, err)
+		http.Error(w, "Failed to write upload", http.StatusInternalServerError)
+		return
+	case err != nil:
+		// The received part is kept; the client resumes from the offset it gets with HEAD
+		fmt.Printf("Upload %s interrupted at %d: %v\n", id, upload.Offset, err)
+	}
+	if upload.Complete() && !importUpload(w, upload) {
+		return
+	}
+
+	w.Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	w.Header().Set("Upload-Expires", utils.GlobalUploadStore.Expires(id).UTC().Format(http.TimeFormat))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// importUpload moves a completed upload into the asset library and announces the asset ID
+// in the Mixloop-Asset-Id header. The upload is kept as a record of the asset until it
+// expires, so repeating the last chunk or HEAD answer with the same ID. Uploads that
+// aren't valid audio are discarded with a 400.
+func importUpload(w http.ResponseWriter, upload *utils.Upload) bool {
+	if upload.AssetID == "" {
+		file, err := utils.GlobalUploadStore.Assemble(upload)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return false
+		}
+		defer os.Remove(file)
+		asset, _, err := utils.GlobalAssetStore.Import(file, upload.FileName())
+		if err != nil {
+			utils.GlobalUploadStore.Delete(upload.ID)
+			http.Error(w, fmt.Sprintf("Invalid audio file %s: %v", upload.FileName(), err), http.StatusBadRequest)
+			return false
+		}
+		if err := utils.GlobalUploadStore.Finish(upload, asset.ID); err != nil {
+			fmt.Printf("Upload %s finish error: %v\n", upload.ID, err)
+		}
+	}
+	w.Header().Set("Mixloop-Asset-Id", upload.AssetID)
+	return true
+}
+
+// UploadDeleteHandler terminates an upload and removes its data
+func UploadDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	if !checkTusVersion(w, r) {
+		return
+	}
+	if err := utils.GlobalUploadStore.Delete(mux.Vars(r)["id"]); err != nil {
+		http.Error(w, "Upload not found", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// parseUploadMetadata decodes a tus Upload-Metadata header: comma separated keys, each
+// followed by a space and its base64 encoded value unless the value is empty
+func parseUploadMetadata(header string) (map[string]string, error) {
+	metadata := make(map[string]string)
+	if strings.TrimSpace(header) == "" {
+		return metadata, nil
+	}
+	for _, pair := range strings.Split(header, ",") {
+		key, encoded, _ := strings.Cut(strings.TrimSpace(pair), " ")
+		if key == "" {
+			return nil, fmt.Errorf("empty key")
+		}
+		value, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("value of %s is not base64", key)
+		}
+		metadata[key] = string(value)
+	}
+	return metadata, nil
+}
+
+// formatUploadMetadata encodes metadata for the Upload-Metadata header
+func formatUploadMetadata(metadata map[string]string) string {
+	pairs := make([]string, 0, len(metadata))
+	for key, value := range metadata {
+		if value == "" {
+			pairs = append(pairs, key)
+			continue
+		}
+		pairs = append(pairs, key+" "+base64.StdEncoding.EncodeToString([]byte(value)))
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
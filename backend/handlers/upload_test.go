@@ -0,0 +1,191 @@
+package handlers
+
+import (
+	"bytes"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"mixloop/utils"
+)
+
+// uploadPart is one part of a test multipart body; parts without a file name are form fields
+type uploadPart struct {
+	field    string
+	fileName string
+	size     int
+	value    string
+}
+
+func multipartBody(t *testing.T, parts []uploadPart) (*bytes.Buffer, string) {
+	t.Helper()
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	for _, part := range parts {
+		if part.fileName == "" {
+			if err := writer.WriteField(part.field, part.value); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		dst, err := writer.CreateFormFile(part.field, part.fileName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := dst.Write(bytes.Repeat([]byte{'x'}, part.size)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return &body, writer.FormDataContentType()
+}
+
+// useUploadLimits sets small upload limits for one test
+func useUploadLimits(t *testing.T, fileSize, requestSize int64) {
+	t.Helper()
+	config := utils.GlobalConfig
+	previousFile, previousRequest := config.MaxUploadFileSize, config.MaxUploadSize
+	config.MaxUploadFileSize, config.MaxUploadSize = fileSize, requestSize
+	t.Cleanup(func() {
+		config.MaxUploadFileSize, config.MaxUploadSize = previousFile, previousRequest
+	})
+}
+
+func TestStreamUploadLimits(t *testing.T) {
+	useUploadLimits(t, 1<<20, 2<<20)
+	const kb = 1 << 10
+
+	tests := []struct {
+		name        string
+		parts       []uploadPart
+		maxFiles    int
+		wantStatus  int
+		wantMessage string
+	}{
+		{
+			name:       "within limits",
+			parts:      []uploadPart{{field: "audio_files", fileName: "a.wav", size: 900 * kb}, {field: "audio_files", fileName: "b.wav", size: 900 * kb}},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:        "file over the per-file limit",
+			parts:       []uploadPart{{field: "audio_files", fileName: "small.wav", size: kb}, {field: "audio_files", fileName: "huge.wav", size: 1<<20 + 1}},
+			wantStatus:  http.StatusRequestEntityTooLarge,
+			wantMessage: "File huge.wav exceeds the per-file limit of 1 MB",
+		},
+		{
+			name: "request over the total limit",
+			parts: []uploadPart{
+				{field: "audio_files", fileName: "a.wav", size: 900 * kb},
+				{field: "audio_files", fileName: "b.wav", size: 900 * kb},
+				{field: "audio_files", fileName: "c.wav", size: 900 * kb},
+			},
+			wantStatus:  http.StatusRequestEntityTooLarge,
+			wantMessage: "Upload exceeds the request limit of 2 MB at file c.wav",
+		},
+		{
+			name: "unread file field counts towards the total",
+			parts: []uploadPart{
+				{field: "audio_files", fileName: "a.wav", size: 900 * kb},
+				{field: "attachment", fileName: "extra.bin", size: 1500 * kb},
+			},
+			wantStatus:  http.StatusRequestEntityTooLarge,
+			wantMessage: "at file extra.bin",
+		},
+		{
+			name: "too many files",
+			parts: []uploadPart{
+				{field: "audio_files", fileName: "a.wav", size: kb},
+				{field: "audio_files", fileName: "b.wav", size: kb},
+				{field: "audio_files", fileName: "c.wav", size: kb},
+			},
+			maxFiles:    2,
+			wantStatus:  http.StatusRequestEntityTooLarge,
+			wantMessage: "Too many files: c.wav exceeds the limit of 2 files per request",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			body, contentType := multipartBody(t, test.parts)
+			r := httptest.NewRequest(http.MethodPost, "/api/process", body)
+			r.Header.Set("Content-Type", contentType)
+			w := httptest.NewRecorder()
+
+			maxFiles := test.maxFiles
+			if maxFiles == 0 {
+				maxFiles = 10
+			}
+			files, err := streamUpload(w, r, t.TempDir(), map[string]string{"audio_files": "input"}, maxFiles)
+			if err != nil {
+				writeUploadError(w, err)
+			} else {
+				w.WriteHeader(http.StatusOK)
+			}
+
+			if w.Code != test.wantStatus {
+				t.Fatalf("status %d (%s), want %d", w.Code, strings.TrimSpace(w.Body.String()), test.wantStatus)
+			}
+			if test.wantMessage != "" && !strings.Contains(w.Body.String(), test.wantMessage) {
+				t.Errorf("message %q, want one containing %q", strings.TrimSpace(w.Body.String()), test.wantMessage)
+			}
+			if test.wantStatus == http.StatusOK && len(files) != 2 {
+				t.Errorf("saved %d files, want 2", len(files))
+			}
+		})
+	}
+}
+
+func TestStreamUploadLimitsFormFields(t *testing.T) {
+	// The request limit is above the form field limit, so the field limit is what trips
+	useUploadLimits(t, 1<<20, 32<<20)
+	body, contentType := multipartBody(t, []uploadPart{{field: "track_options", value: strings.Repeat("x", maxFormValueBytes+1)}})
+	r := httptest.NewRequest(http.MethodPost, "/api/process", body)
+	r.Header.Set("Content-Type", contentType)
+	w := httptest.NewRecorder()
+
+	_, err := streamUpload(w, r, t.TempDir(), map[string]string{"audio_files": "input"}, 10)
+	if err == nil {
+		t.Fatal("oversized form field accepted")
+	}
+	writeUploadError(w, err)
+	if w.Code != http.StatusRequestEntityTooLarge || !strings.Contains(w.Body.String(), "Form field track_options is too large") {
+		t.Errorf("status %d with %q, want 413 naming track_options", w.Code, strings.TrimSpace(w.Body.String()))
+	}
+}
+
+func TestStreamUploadMergesQueryValues(t *testing.T) {
+	useUploadLimits(t, 1<<20, 2<<20)
+	body, contentType := multipartBody(t, []uploadPart{
+		{field: "crossfade", value: "4"},
+		{field: "loops", value: "2"},
+		{field: "audio_files", fileName: "loop.wav", size: 100},
+	})
+	r := httptest.NewRequest(http.MethodPost, "/api/process?format=wav&loops=3", body)
+	r.Header.Set("Content-Type", contentType)
+
+	files, err := streamUpload(httptest.NewRecorder(), r, t.TempDir(), map[string]string{"audio_files": "input"}, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || files[0].Name != "loop.wav" {
+		t.Fatalf("files = %+v, want loop.wav", files)
+	}
+
+	for field, want := range map[string]string{"crossfade": "4", "format": "wav"} {
+		if got := r.FormValue(field); got != want {
+			t.Errorf("FormValue(%s) = %q, want %q", field, got, want)
+		}
+	}
+	if got := fmt.Sprint(r.Form["loops"]); got != "[3 2]" {
+		t.Errorf("loops = %s, want the query value and the form value", got)
+	}
+	if got := r.PostFormValue("format"); got != "" {
+		t.Errorf("PostFormValue(format) = %q, want only form fields", got)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/rs/cors"
@@ -13,16 +14,36 @@ import (
 )
 
 func main() {
-	// Create uploads and output directories
-	os.MkdirAll("uploads", 0755)
-	os.MkdirAll("output", 0755)
+	// Create the scratch directory; results, assets and resumable uploads live in the storage
+	os.MkdirAll(utils.GlobalConfig.WorkDir, 0755)
+
+	// Expired results are swept hourly
+	utils.GlobalResultStore.StartCleanup(time.Hour)
+	utils.GlobalUploadStore.StartCleanup(time.Hour)
 
 	r := mux.NewRouter()
 
 	// Routes
 	r.HandleFunc("/api/mix", handlers.MixAudioHandler).Methods("POST")
+	r.HandleFunc("/api/analyze", handlers.AnalyzeAudioHandler).Methods("POST")
+	r.HandleFunc("/api/eq/response", handlers.EQResponseHandler).Methods("POST")
 	r.HandleFunc("/api/progress", utils.ProgressHandler).Methods("GET")
 	r.HandleFunc("/ws/progress", utils.WebSocketHandler)
+	r.HandleFunc("/api/result", utils.ResultHandler).Methods("GET")
+	r.HandleFunc("/api/preview/waveform", handlers.WaveformHandler).Methods("POST")
+	r.HandleFunc("/api/preview/spectrogram", handlers.SpectrogramHandler).Methods("POST")
+	r.HandleFunc("/api/preview/{id}", handlers.PreviewFileHandler).Methods("GET")
+	r.HandleFunc("/api/stream/{session}/{kind}/{file}", handlers.StreamFileHandler).Methods("GET")
+	r.HandleFunc("/api/uploads", handlers.UploadOptionsHandler).Methods("OPTIONS")
+	r.HandleFunc("/api/uploads", handlers.UploadCreateHandler).Methods("POST")
+	r.HandleFunc("/api/uploads/{id}", handlers.UploadOptionsHandler).Methods("OPTIONS")
+	r.HandleFunc("/api/uploads/{id}", handlers.UploadHeadHandler).Methods("HEAD")
+	r.HandleFunc("/api/uploads/{id}", handlers.UploadPatchHandler).Methods("PATCH")
+	r.HandleFunc("/api/uploads/{id}", handlers.UploadDeleteHandler).Methods("DELETE")
+	r.HandleFunc("/api/assets", handlers.AssetUploadHandler).Methods("POST")
+	r.HandleFunc("/api/assets", handlers.AssetListHandler).Methods("GET")
+	r.HandleFunc("/api/assets/{id}", handlers.AssetGetHandler).Methods("GET")
+	r.HandleFunc("/api/assets/{id}", handlers.AssetDeleteHandler).Methods("DELETE")
 	r.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		http.ServeFile(w, r, "static/index.html")
 	})
@@ -30,9 +51,12 @@ func main() {
 	// CORS - Allow all origins for web/VPS deployment
 	c := cors.New(cors.Options{
 		AllowedOrigins:   []string{"*"},
-		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
+		AllowedMethods:   []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
 		AllowedHeaders:   []string{"*"},
 		AllowCredentials: false,
+		// tus clients read these from upload responses, mix clients the result's session ID
+		ExposedHeaders: []string{"Location", "Tus-Resumable", "Tus-Version", "Tus-Extension", "Tus-Max-Size",
+			"Upload-Offset", "Upload-Length", "Upload-Metadata", "Upload-Expires", "Mixloop-Asset-Id", "X-Session-ID"},
 	})
 
 	handler := c.Handler(r)
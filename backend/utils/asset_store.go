@@ -0,0 +1,347 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// assetIDPattern matches SHA-256 asset IDs
+var assetIDPattern = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// Asset is a source file in the library, with the probe and analysis results cached at import
+type Asset struct {
+	ID       string         `json:"id"` // SHA-256 of the contents
+	Name     string         `json:"name"`
+	Size     int64          `json:"size"`
+	Created  int64          `json:"created"`
+	Format   *AudioFormat   `json:"format,omitempty"`
+	Analysis *AudioAnalysis `json:"analysis,omitempty"`
+}
+
+// AssetStore is a persistent library of source files keyed by content hash, so the same
+// file is stored once however often it is uploaded. Every asset is kept in the storage as
+// <Prefix>/<id><ext> with its metadata in <Prefix>/<id>.json. Assets in remote storage are
+// downloaded to CacheDir the first time an instance mixes them.
+type AssetStore struct {
+	Storage    Storage
+	Prefix     string
+	CacheDir   string
+	assets     map[string]*Asset
+	imports    map[string]*importLock
+	mutex      sync.RWMutex
+	fetchMutex sync.Mutex
+}
+
+// NewAssetStore creates a new asset store
+func NewAssetStore(storage Storage, prefix, cacheDir string) *AssetStore {
+	return &AssetStore{
+		Storage:  storage,
+		Prefix:   prefix,
+		CacheDir: cacheDir,
+		assets:   make(map[string]*Asset),
+		imports:  make(map[string]*importLock),
+	}
+}
+
+// GlobalAssetStore is the shared asset library
+var GlobalAssetStore = NewAssetStore(GlobalStorage, "assets", filepath.Join(GlobalConfig.WorkDir, "assets"))
+
+// Import moves a file into the library and returns its asset. When the contents are already
+// stored, the file is removed and the existing asset is returned with created false.
+// Imports of different files run in parallel; imports of the same contents wait for the
+// first one, so every file is analysed once.
+func (as *AssetStore) Import(file, name string) (*Asset, bool, error) {
+	if err := NewAudioValidator().ValidateFile(file); err != nil {
+		return nil, false, err
+	}
+
+	id, err := FileSHA256(file)
+	if err != nil {
+		return nil, false, err
+	}
+
+	unlock := as.lockImport(id)
+	defer unlock()
+	if asset, ok := as.Get(id); ok {
+		os.Remove(file)
+		return asset, false, nil
+	}
+
+	info, err := os.Stat(file)
+	if err != nil {
+		return nil, false, err
+	}
+	asset := &Asset{
+		ID:      id,
+		Name:    strings.TrimSuffix(filepath.Base(name), filepath.Ext(name)) + strings.ToLower(filepath.Ext(file)),
+		Size:    info.Size(),
+		Created: time.Now().UnixMilli(),
+	}
+
+	// Probing and analysis run once here instead of on every mix
+	if asset.Format, err = ProbeAudioFormat(file); err != nil {
+		return nil, false, err
+	}
+	if asset.Analysis, err = NewAudioAnalyzer().Analyze(file); err != nil {
+		return nil, false, err
+	}
+
+	key := as.key(asset)
+	if dst, ok := LocalPath(as.Storage, key); ok {
+		if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+			return nil, false, fmt.Errorf("failed to create asset directory: %v", err)
+		}
+		err = moveFile(file, dst)
+	} else if err = PutFile(as.Storage, key, file); err == nil {
+		os.Remove(file)
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to store asset: %v", err)
+	}
+
+	data, err := json.MarshalIndent(asset, "", "  ")
+	if err == nil {
+		err = as.Storage.Put(as.infoKey(id), bytes.NewReader(data), int64(len(data)))
+	}
+	if err != nil {
+		as.Storage.Delete(key)
+		return nil, false, err
+	}
+
+	as.mutex.Lock()
+	as.assets[id] = asset
+	as.mutex.Unlock()
+	return asset, true, nil
+}
+
+// importLock serialises the imports of one content hash
+type importLock struct {
+	sync.Mutex
+	users int
+}
+
+// lockImport locks the imports of a content hash and returns the unlock function. The lock
+// is dropped once nobody holds or waits for it.
+func (as *AssetStore) lockImport(id string) func() {
+	as.mutex.Lock()
+	lock, ok := as.imports[id]
+	if !ok {
+		lock = &importLock{}
+		as.imports[id] = lock
+	}
+	lock.users++
+	as.mutex.Unlock()
+
+	lock.Lock()
+	return func() {
+		lock.Unlock()
+		as.mutex.Lock()
+		lock.users--
+		if lock.users == 0 {
+			delete(as.imports, id)
+		}
+		as.mutex.Unlock()
+	}
+}
+
+// Get returns an asset by ID. The metadata is cached; assets another instance deleted drop
+// out of the cache when List or Resolve notice they are gone.
+func (as *AssetStore) Get(id string) (*Asset, bool) {
+	if !assetIDPattern.MatchString(id) {
+		return nil, false
+	}
+
+	as.mutex.RLock()
+	asset, ok := as.assets[id]
+	as.mutex.RUnlock()
+	if ok {
+		return asset, true
+	}
+
+	asset, err := as.readInfo(id)
+	if errors.Is(err, ErrObjectNotFound) {
+		return nil, false
+	}
+	if err != nil {
+		log.Printf("Skipping corrupt asset metadata %s: %v", id, err)
+		return nil, false
+	}
+	as.mutex.Lock()
+	as.assets[id] = asset
+	as.mutex.Unlock()
+	return asset, true
+}
+
+// List returns every asset in the storage, oldest first
+func (as *AssetStore) List() []*Asset {
+	objects, err := as.Storage.List(as.Prefix + "/")
+	if err != nil {
+		log.Printf("Failed to list assets: %v", err)
+		return []*Asset{}
+	}
+
+	assets := make([]*Asset, 0, len(objects)/2)
+	listed := make(map[string]bool)
+	for _, object := range objects {
+		id, ok := strings.CutSuffix(path.Base(object.Key), ".json")
+		if !ok || !assetIDPattern.MatchString(id) {
+			continue
+		}
+		listed[id] = true
+		as.mutex.RLock()
+		asset, ok := as.assets[id]
+		as.mutex.RUnlock()
+		if !ok {
+			if asset, err = as.readInfo(id); err != nil {
+				log.Printf("Skipping corrupt asset metadata %s: %v", object.Key, err)
+				continue
+			}
+			as.mutex.Lock()
+			as.assets[id] = asset
+			as.mutex.Unlock()
+		}
+		assets = append(assets, asset)
+	}
+
+	// Assets deleted by another instance leave the cache
+	as.mutex.RLock()
+	var gone []string
+	for id := range as.assets {
+		if !listed[id] {
+			gone = append(gone, id)
+		}
+	}
+	as.mutex.RUnlock()
+	for _, id := range gone {
+		as.forget(id)
+	}
+
+	sort.Slice(assets, func(i, j int) bool {
+		if assets[i].Created != assets[j].Created {
+			return assets[i].Created < assets[j].Created
+		}
+		return assets[i].ID < assets[j].ID
+	})
+	return assets
+}
+
+// Delete removes an asset and its metadata
+func (as *AssetStore) Delete(id string) error {
+	asset, ok := as.Get(id)
+	if !ok {
+		return os.ErrNotExist
+	}
+
+	if err := as.Storage.Delete(as.infoKey(id)); err != nil {
+		return err
+	}
+	as.forget(id)
+	return as.Storage.Delete(as.key(asset))
+}
+
+// Resolve returns a local file of an asset for use as a mix input
+func (as *AssetStore) Resolve(id string) (string, *Asset, error) {
+	asset, ok := as.Get(id)
+	if !ok {
+		return "", nil, fmt.Errorf("asset %s not found", id)
+	}
+	if file, ok := LocalPath(as.Storage, as.key(asset)); ok {
+		if _, err := os.Stat(file); err != nil {
+			as.forget(id)
+			return "", nil, fmt.Errorf("asset %s not found", id)
+		}
+		return file, asset, nil
+	}
+
+	as.fetchMutex.Lock()
+	defer as.fetchMutex.Unlock()
+	file := filepath.Join(as.CacheDir, path.Base(as.key(asset)))
+	if _, err := os.Stat(file); err == nil {
+		return file, asset, nil
+	}
+	if err := FetchFile(as.Storage, as.key(asset), file); err != nil {
+		if errors.Is(err, ErrObjectNotFound) {
+			as.forget(id)
+			return "", nil, fmt.Errorf("asset %s not found", id)
+		}
+		return "", nil, fmt.Errorf("failed to fetch asset %s: %v", id, err)
+	}
+	return file, asset, nil
+}
+
+// key is the storage key of the contents of an asset
+func (as *AssetStore) key(asset *Asset) string {
+	return path.Join(as.Prefix, asset.ID+strings.ToLower(filepath.Ext(asset.Name)))
+}
+
+// infoKey is the storage key of the metadata of an asset
+func (as *AssetStore) infoKey(id string) string {
+	return path.Join(as.Prefix, id+".json")
+}
+
+func (as *AssetStore) readInfo(id string) (*Asset, error) {
+	src, _, err := as.Storage.Get(as.infoKey(id))
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+
+	var asset Asset
+	if err := json.NewDecoder(src).Decode(&asset); err != nil {
+		return nil, err
+	}
+	if asset.ID != id {
+		return nil, fmt.Errorf("metadata is for asset %s", asset.ID)
+	}
+	return &asset, nil
+}
+
+// forget drops the cached metadata and file of an asset that left the storage
+func (as *AssetStore) forget(id string) {
+	as.mutex.Lock()
+	asset, ok := as.assets[id]
+	delete(as.assets, id)
+	as.mutex.Unlock()
+	if ok {
+		os.Remove(filepath.Join(as.CacheDir, path.Base(as.key(asset))))
+	}
+}
+
+// moveFile renames src to dst, copying when they are on different file systems
+func moveFile(src, dst string) error {
+	if err := os.Rename(src, dst); err == nil {
+		return nil
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(dst)
+		return err
+	}
+	return os.Remove(src)
+}
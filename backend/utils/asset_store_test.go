@@ -0,0 +1,142 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countingStorage counts the metadata reads that reach the storage
+type countingStorage struct {
+	Storage
+	gets  atomic.Int32
+	stats atomic.Int32
+}
+
+func (cs *countingStorage) Get(key string) (io.ReadCloser, *ObjectInfo, error) {
+	cs.gets.Add(1)
+	return cs.Storage.Get(key)
+}
+
+func (cs *countingStorage) Stat(key string) (*ObjectInfo, error) {
+	cs.stats.Add(1)
+	return cs.Storage.Stat(key)
+}
+
+func putTestAsset(t *testing.T, storage Storage, asset *Asset) {
+	t.Helper()
+	data, err := json.Marshal(asset)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.Put("assets/"+asset.ID+".json", bytes.NewReader(data), int64(len(data))); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAssetStoreGetUsesCache(t *testing.T) {
+	storage := &countingStorage{Storage: NewLocalStorage(t.TempDir())}
+	store := NewAssetStore(storage, "assets", t.TempDir())
+	asset := &Asset{ID: strings.Repeat("a", 64), Name: "loop.wav", Format: &AudioFormat{SampleRate: 44100, Channels: 2}}
+	putTestAsset(t, storage, asset)
+
+	for i := 0; i < 3; i++ {
+		got, ok := store.Get(asset.ID)
+		if !ok || got.Name != "loop.wav" {
+			t.Fatalf("Get = %v, %v", got, ok)
+		}
+	}
+	if gets, stats := storage.gets.Load(), storage.stats.Load(); gets != 1 || stats != 0 {
+		t.Errorf("three lookups read the storage %d times and stat it %d times, want 1 and 0", gets, stats)
+	}
+	if _, ok := store.Get(strings.Repeat("b", 64)); ok {
+		t.Error("Get of an unknown asset succeeded")
+	}
+}
+
+func TestAssetStoreListForgetsDeletedAssets(t *testing.T) {
+	storage := NewLocalStorage(t.TempDir())
+	store := NewAssetStore(storage, "assets", t.TempDir())
+	kept := &Asset{ID: strings.Repeat("a", 64), Name: "kept.wav", Created: 1}
+	deleted := &Asset{ID: strings.Repeat("b", 64), Name: "deleted.wav", Created: 2}
+	putTestAsset(t, storage, kept)
+	putTestAsset(t, storage, deleted)
+	store.Get(kept.ID)
+	store.Get(deleted.ID)
+
+	// Another instance deletes an asset
+	if err := storage.Delete("assets/" + deleted.ID + ".json"); err != nil {
+		t.Fatal(err)
+	}
+	if assets := store.List(); len(assets) != 1 || assets[0].ID != kept.ID {
+		t.Errorf("List = %v, want only the kept asset", assets)
+	}
+	if _, ok := store.Get(deleted.ID); ok {
+		t.Error("deleted asset is still cached after List")
+	}
+	if _, _, err := store.Resolve(deleted.ID); err == nil {
+		t.Error("Resolve of a deleted asset succeeded")
+	}
+}
+
+func TestAssetStoreImportLocksPerHash(t *testing.T) {
+	store := NewAssetStore(NewLocalStorage(t.TempDir()), "assets", t.TempDir())
+	first, second := strings.Repeat("a", 64), strings.Repeat("b", 64)
+
+	unlockFirst := store.lockImport(first)
+
+	// Other contents import in parallel
+	done := make(chan struct{})
+	go func() {
+		store.lockImport(second)()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("import of other contents waited for a running import")
+	}
+
+	// The same contents wait for the running import
+	acquired := make(chan struct{})
+	go func() {
+		unlock := store.lockImport(first)
+		close(acquired)
+		unlock()
+	}()
+	select {
+	case <-acquired:
+		t.Fatal("import of the same contents ran in parallel")
+	case <-time.After(50 * time.Millisecond):
+	}
+	unlockFirst()
+	<-acquired
+
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+	if len(store.imports) != 0 {
+		t.Errorf("%d import locks left behind", len(store.imports))
+	}
+}
+
+func TestFormatCacheProbe(t *testing.T) {
+	cached := &AudioFormat{Codec: "pcm_s16le", SampleRate: 44100, Channels: 2}
+	cache := FormatCache{"/library/loop.wav": cached}
+
+	format, err := cache.Probe("/library/loop.wav")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *format != *cached {
+		t.Errorf("Probe = %v, want %v", format, cached)
+	}
+	// Callers may change what they get without touching the cache
+	format.SampleRate = 48000
+	if cached.SampleRate != 44100 {
+		t.Error("Probe returned the cached format itself")
+	}
+}
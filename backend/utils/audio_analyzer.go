@@ -0,0 +1,65 @@
+package utils
+
+import (
+	"strconv"
+)
+
+// AudioAnalysis is the result of analysing one audio file
+type AudioAnalysis struct {
+	Duration   float64    `json:"duration"`
+	Codec      string     `json:"codec,omitempty"`
+	SampleRate int        `json:"sample_rate,omitempty"`
+	Channels   int        `json:"channels,omitempty"`
+	Tempo      *TempoInfo `json:"tempo,omitempty"`
+	Key        *KeyInfo   `json:"key,omitempty"`
+	*EnergyStats
+
+	Errors []string `json:"errors,omitempty"`
+}
+
+// AudioAnalyzer extracts format, tempo, key and energy information from audio files
+type AudioAnalyzer struct {
+	Validator *AudioValidator
+}
+
+// NewAudioAnalyzer creates a new audio analyzer
+func NewAudioAnalyzer() *AudioAnalyzer {
+	return &AudioAnalyzer{
+		Validator: NewAudioValidator(),
+	}
+}
+
+// Analyze runs every analysis on a file; failures of individual detectors are reported, not returned
+func (aa *AudioAnalyzer) Analyze(file string) (*AudioAnalysis, error) {
+	info, err := aa.Validator.GetAudioInfo(file)
+	if err != nil {
+		return nil, err
+	}
+
+	analysis := &AudioAnalysis{
+		Codec: info["codec"],
+	}
+	analysis.Duration, _ = strconv.ParseFloat(info["duration"], 64)
+	analysis.SampleRate, _ = strconv.Atoi(info["sample_rate"])
+	analysis.Channels, _ = strconv.Atoi(info["channels"])
+
+	if tempo, err := DetectTempo(file); err == nil {
+		analysis.Tempo = tempo
+	} else {
+		analysis.Errors = append(analysis.Errors, "tempo: "+err.Error())
+	}
+
+	if key, err := DetectKey(file); err == nil {
+		analysis.Key = key
+	} else {
+		analysis.Errors = append(analysis.Errors, "key: "+err.Error())
+	}
+
+	if energy, err := MeasureEnergy(file); err == nil {
+		analysis.EnergyStats = energy
+	} else {
+		analysis.Errors = append(analysis.Errors, "energy: "+err.Error())
+	}
+
+	return analysis, nil
+}
@@ -0,0 +1,309 @@
+package utils
+
+import (
+	"fmt"
+	"math"
+	"os/exec"
+	"sort"
+	"strings"
+)
+
+// Denoise and dehum modes
+const (
+	DenoiseFixed = "fixed" // adaptive afftdn with a generic white noise profile
+	DenoiseLearn = "learn" // afftdn with a profile learned from the quietest passage
+	DehumAuto    = "auto"  // pick 50 or 60 Hz from the spectrum
+	Dehum50      = "50"
+	Dehum60      = "60"
+)
+
+const (
+	defaultDenoiseStrength = 12.0 // dB
+	defaultHumHarmonics    = 5
+	humSampleRate          = 8000
+	humFrameSize           = 8192 // ~1 Hz resolution at humSampleRate
+	humMinProminence       = 6.0  // dB above the neighbouring spectrum
+	noiseProfileSeconds    = 1.0
+	noiseBlockSeconds      = 0.05
+	noiseSampleRate        = 8000
+)
+
+// CleanupOptions selects the noise reduction and hum removal for the mix or a track
+type CleanupOptions struct {
+	Denoise         string  `json:"denoise,omitempty"`          // "", "fixed" or "learn"
+	DenoiseStrength float64 `json:"denoise_strength,omitempty"` // dB of noise reduction
+	Dehum           string  `json:"dehum,omitempty"`            // "", "auto", "50" or "60"
+	HumHarmonics    int     `json:"hum_harmonics,omitempty"`
+}
+
+// Enabled reports whether any cleanup stage is selected
+func (co CleanupOptions) Enabled() bool {
+	return co.Denoise != "" || co.Dehum != ""
+}
+
+// Validate checks the options and fills in defaults; "true" selects the default mode
+func (co *CleanupOptions) Validate() error {
+	switch co.Denoise {
+	case "", "false":
+		co.Denoise = ""
+	case "true":
+		co.Denoise = DenoiseFixed
+	case DenoiseFixed, DenoiseLearn:
+	default:
+		return fmt.Errorf("invalid denoise mode: %s", co.Denoise)
+	}
+
+	switch co.Dehum {
+	case "", "false":
+		co.Dehum = ""
+	case "true":
+		co.Dehum = DehumAuto
+	case DehumAuto, Dehum50, Dehum60:
+	default:
+		return fmt.Errorf("invalid dehum mode: %s", co.Dehum)
+	}
+
+	if co.DenoiseStrength == 0 {
+		co.DenoiseStrength = defaultDenoiseStrength
+	} else if co.DenoiseStrength < 1 || co.DenoiseStrength > 97 {
+		return fmt.Errorf("denoise_strength must be between 1 and 97 dB")
+	}
+
+	if co.HumHarmonics == 0 {
+		co.HumHarmonics = defaultHumHarmonics
+	} else if co.HumHarmonics < 1 || co.HumHarmonics > 10 {
+		return fmt.Errorf("hum_harmonics must be between 1 and 10")
+	}
+	return nil
+}
+
+// CleanupReport describes the cleanup applied to the mix or to one track
+type CleanupReport struct {
+	Target          string    `json:"target"` // "master" or "track"
+	Index           int       `json:"index"`
+	Name            string    `json:"name,omitempty"`
+	Denoise         string    `json:"denoise,omitempty"`
+	DenoiseStrength float64   `json:"denoise_strength,omitempty"`
+	NoiseProfile    []float64 `json:"noise_profile,omitempty"` // start and end in seconds
+	Dehum           string    `json:"dehum,omitempty"`
+	HumFrequency    float64   `json:"hum_frequency,omitempty"`
+	HumHarmonics    int       `json:"hum_harmonics,omitempty"`
+	Note            string    `json:"note,omitempty"`
+}
+
+// ApplyCleanup removes hum and broadband noise from inputFile into a WAV outputFile.
+// It returns the file to continue with, which is inputFile when there was nothing to do.
+func (ae *AudioEnhancer) ApplyCleanup(inputFile, outputFile string, options CleanupOptions) (string, *CleanupReport, error) {
+	report := &CleanupReport{}
+	var chain, notes []string
+
+	if options.Dehum != "" {
+		frequency := 0.0
+		switch options.Dehum {
+		case Dehum50:
+			frequency = 50
+		case Dehum60:
+			frequency = 60
+		default:
+			detected, err := DetectHumFrequency(inputFile)
+			if err != nil {
+				return "", nil, fmt.Errorf("hum detection failed: %v", err)
+			}
+			frequency = detected
+		}
+
+		report.Dehum = options.Dehum
+		if frequency > 0 {
+			report.HumFrequency = frequency
+			report.HumHarmonics = options.HumHarmonics
+			chain = append(chain, humNotchFilters(frequency, options.HumHarmonics)...)
+		} else {
+			notes = append(notes, "no mains hum detected")
+		}
+	}
+
+	var graph string
+	inputs := []string{"-i", inputFile}
+	switch options.Denoise {
+	case DenoiseFixed:
+		report.Denoise = DenoiseFixed
+		report.DenoiseStrength = options.DenoiseStrength
+		chain = append(chain, fmt.Sprintf("afftdn=nr=%.1f:nf=-50:tn=1", options.DenoiseStrength))
+	case DenoiseLearn:
+		report.Denoise = DenoiseLearn
+		report.DenoiseStrength = options.DenoiseStrength
+		start, end, err := FindNoiseProfile(inputFile)
+		if err != nil {
+			// Without a usable quiet passage fall back to the generic profile
+			report.Denoise = DenoiseFixed
+			notes = append(notes, "no quiet passage to learn from, used the fixed profile")
+			chain = append(chain, fmt.Sprintf("afftdn=nr=%.1f:nf=-50:tn=1", options.DenoiseStrength))
+			break
+		}
+		report.NoiseProfile = []float64{start, end}
+		// The noise passage is read a second time as its own input
+		inputs = append(inputs, "-ss", fmt.Sprintf("%.3f", start), "-t", fmt.Sprintf("%.3f", end-start), "-i", inputFile)
+		graph = learnedDenoiseGraph(chain, end-start, options.DenoiseStrength)
+	}
+
+	report.Note = strings.Join(notes, "; ")
+
+	if graph == "" {
+		if len(chain) == 0 {
+			return inputFile, report, nil
+		}
+		graph = "[0:a]" + strings.Join(chain, ",") + "[out]"
+	}
+
+	args := append(inputs,
+		"-filter_complex", graph,
+		"-map", "[out]",
+		"-c:a", "pcm_s24le",
+		"-y", outputFile)
+	cmd := exec.Command("ffmpeg", args...)
+
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return "", nil, fmt.Errorf("ffmpeg cleanup error: %v\nOutput: %s", err, output)
+	}
+	return outputFile, report, nil
+}
+
+// humNotchFilters returns narrow band-reject filters at the mains frequency and its harmonics
+func humNotchFilters(frequency float64, harmonics int) []string {
+	var filters []string
+	for h := 1; h <= harmonics; h++ {
+		filters = append(filters, fmt.Sprintf("bandreject=f=%.0f:width_type=q:w=25", frequency*float64(h)))
+	}
+	return filters
+}
+
+// learnedDenoiseGraph plays the noise passage, the second input, ahead of the audio so
+// afftdn learns its profile first, then cuts the passage off again. Reading the passage as
+// its own input means concat never has to hold back the main audio while the passage plays.
+// pre filters run on both inputs before everything else.
+func learnedDenoiseGraph(pre []string, length, strength float64) string {
+	chain := "anull"
+	if len(pre) > 0 {
+		chain = strings.Join(pre, ",")
+	}
+	return fmt.Sprintf("[1:a]%s,asetpts=PTS-STARTPTS[noise];", chain) +
+		fmt.Sprintf("[0:a]%s[main];", chain) +
+		"[noise][main]concat=n=2:v=0:a=1," +
+		fmt.Sprintf("asendcmd=c='0.0 afftdn@denoise sample_noise start;%.3f afftdn@denoise sample_noise stop',", length) +
+		fmt.Sprintf("afftdn@denoise=nr=%.1f:nf=-50,", strength) +
+		fmt.Sprintf("atrim=start=%.3f,asetpts=PTS-STARTPTS[out]", length)
+}
+
+// FindNoiseProfile returns the quietest one-second passage that isn't digital silence,
+// which on field recordings is the best sample of the noise floor
+func FindNoiseProfile(file string) (float64, float64, error) {
+	blockFrames := int(noiseBlockSeconds * noiseSampleRate)
+	var energies []float64
+	var sum float64
+	count := 0
+
+	err := StreamPCM(file, noiseSampleRate, 1, func(samples []float32) error {
+		for _, s := range samples {
+			sum += float64(s) * float64(s)
+			count++
+			if count == blockFrames {
+				energies = append(energies, sum/float64(count))
+				sum, count = 0, 0
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+
+	window := int(noiseProfileSeconds / noiseBlockSeconds)
+	if len(energies) < window {
+		return 0, 0, fmt.Errorf("audio too short for a noise profile")
+	}
+
+	best, bestIndex := math.Inf(1), -1
+	for i := 0; i+window <= len(energies); i++ {
+		total, usable := 0.0, true
+		for _, energy := range energies[i : i+window] {
+			// Digital silence has no noise to learn
+			if energy < 1e-10 {
+				usable = false
+				break
+			}
+			total += energy
+		}
+		if usable && total < best {
+			best, bestIndex = total, i
+		}
+	}
+	if bestIndex < 0 {
+		return 0, 0, fmt.Errorf("no passage with a noise floor found")
+	}
+
+	start := float64(bestIndex) * noiseBlockSeconds
+	return start, start + noiseProfileSeconds, nil
+}
+
+// DetectHumFrequency compares the spectral peaks at the 50 Hz and 60 Hz harmonic series
+// and returns the mains frequency, or 0 when neither stands out from its neighbourhood
+func DetectHumFrequency(file string) (float64, error) {
+	window := hannWindow(humFrameSize)
+	spectrum := make([]float64, humFrameSize/2)
+	frames := 0
+
+	err := streamFrames(file, humSampleRate, humFrameSize, func(frame []float32) {
+		for bin, magnitude := range magnitudeSpectrum(frame, window) {
+			spectrum[bin] += magnitude
+		}
+		frames++
+	})
+	if err != nil {
+		return 0, err
+	}
+	if frames == 0 {
+		return 0, fmt.Errorf("audio too short for hum detection")
+	}
+
+	score50 := humProminence(spectrum, 50)
+	score60 := humProminence(spectrum, 60)
+	switch {
+	case score50 < humMinProminence && score60 < humMinProminence:
+		return 0, nil
+	case score50 >= score60:
+		return 50, nil
+	default:
+		return 60, nil
+	}
+}
+
+// humProminence averages how far the peaks at the first harmonics of f0 rise above the
+// median of the surrounding bins, in dB
+func humProminence(spectrum []float64, f0 float64) float64 {
+	binWidth := float64(humSampleRate) / humFrameSize
+	total := 0.0
+	harmonics := 3
+	for h := 1; h <= harmonics; h++ {
+		center := f0 * float64(h) / binWidth
+		peak := 0.0
+		for bin := int(center - 1.5); bin <= int(center+1.5); bin++ {
+			peak = math.Max(peak, spectrum[bin])
+		}
+
+		// Neighbourhood of +/-8 Hz, leaving out the peak itself
+		var around []float64
+		for bin := int(center - 8/binWidth); bin <= int(center+8/binWidth); bin++ {
+			if math.Abs(float64(bin)-center) > 2/binWidth {
+				around = append(around, spectrum[bin])
+			}
+		}
+		sort.Float64s(around)
+		median := around[len(around)/2]
+		if median <= 0 || peak <= 0 {
+			continue
+		}
+		total += 20 * math.Log10(peak/median)
+	}
+	return total / float64(harmonics)
+}
@@ -0,0 +1,28 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLearnedDenoiseGraphReadsNoiseAsSecondInput(t *testing.T) {
+	graph := learnedDenoiseGraph(humNotchFilters(50, 2), 1, 12)
+
+	// Splitting the main input would buffer all of it while the noise passage plays
+	if strings.Contains(graph, "asplit") {
+		t.Errorf("graph splits the input: %s", graph)
+	}
+	if !strings.HasPrefix(graph, "[1:a]bandreject=f=50:width_type=q:w=25,bandreject=f=100:width_type=q:w=25,") {
+		t.Errorf("noise input doesn't get the hum filters: %s", graph)
+	}
+	if !strings.Contains(graph, "[0:a]bandreject=f=50:width_type=q:w=25,bandreject=f=100:width_type=q:w=25[main]") {
+		t.Errorf("main input doesn't get the hum filters: %s", graph)
+	}
+	if !strings.Contains(graph, "[noise][main]concat=n=2") || !strings.HasSuffix(graph, "atrim=start=1.000,asetpts=PTS-STARTPTS[out]") {
+		t.Errorf("noise passage isn't played first and cut off again: %s", graph)
+	}
+
+	if graph := learnedDenoiseGraph(nil, 1, 12); !strings.HasPrefix(graph, "[1:a]anull,") || !strings.Contains(graph, "[0:a]anull[main]") {
+		t.Errorf("graph without pre filters: %s", graph)
+	}
+}
@@ -10,6 +10,7 @@ import (
 // AudioEnhancer handles audio enhancement filters
 type AudioEnhancer struct {
 	TempDir string
+	Tags    OutputTags // written into the output of ApplyEnhancement
 }
 
 // NewAudioEnhancer creates a new audio enhancer
@@ -21,36 +22,46 @@ func NewAudioEnhancer(tempDir string) *AudioEnhancer {
 
 // ApplyEnhancement applies audio enhancement filters to improve quality
 func (ae *AudioEnhancer) ApplyEnhancement(inputFile, outputFile string, outputFormat, quality string) error {
+	_, err := ae.ApplyEnhancementWithLimiter(inputFile, outputFile, outputFormat, quality, nil)
+	return err
+}
+
+// ApplyEnhancementWithLimiter applies the enhancement filters followed by the output limiter.
+// The limiter report is nil when limiter is nil.
+func (ae *AudioEnhancer) ApplyEnhancementWithLimiter(inputFile, outputFile string, outputFormat, quality string, limiter *Limiter) (*LimiterReport, error) {
 	// Build enhancement filter chain
 	filterChain := ae.buildEnhancementFilters()
+	sampleRate := OutputSampleRate(outputFormat, SourceSampleRate(inputFile))
+	if limiter != nil {
+		filterChain += "," + limiter.Filter(sampleRate)
+	}
+	
+	tagInputs, tagOutputs, err := ae.Tags.FFmpegArgs(outputFormat, ae.TempDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to prepare output tags: %v", err)
+	}
 	
 	// Build FFmpeg command based on output format
 	var args []string
 	args = append(args, "-i", inputFile)
+	args = append(args, tagInputs...)
 	args = append(args, "-af", filterChain)
 	
-	// Add metadata
-	args = append(args, "-metadata", "artist=e.bitzy.id")
-	args = append(args, "-metadata", "author=e.bitzy.id")
-	args = append(args, "-metadata", "composer=e.bitzy.id")
-	args = append(args, "-metadata", "comment=Mixed with MixLoop by BITZY.ID")
-	
-	if outputFormat == "wav" {
-		args = append(args, "-c:a", quality, "-ar", "48000")
-	} else {
-		// MP3 format
-		args = append(args, "-c:a", "libmp3lame", "-b:a", quality, "-ar", "48000")
-	}
+	args = append(args, OutputCodecArgs(outputFormat, quality, sampleRate)...)
+	args = append(args, tagOutputs...)
 	
 	args = append(args, "-y", outputFile)
 	
 	cmd := exec.Command("ffmpeg", args...)
 	output, err := cmd.CombinedOutput()
 	if err != nil {
-		return fmt.Errorf("ffmpeg enhancement error: %v\nOutput: %s", err, output)
+		return nil, fmt.Errorf("ffmpeg enhancement error: %v\nOutput: %s", err, output)
 	}
 	
-	return nil
+	if limiter == nil {
+		return nil, nil
+	}
+	return limiter.ParseReport(output)
 }
 
 // buildEnhancementFilters creates the audio enhancement filter chain
@@ -69,13 +80,7 @@ func (ae *AudioEnhancer) buildEnhancementFilters() string {
 func (ae *AudioEnhancer) ApplyEnhancementToFile(inputFile string, outputFormat, quality string) (string, error) {
 	// Generate output filename
 	baseName := strings.TrimSuffix(filepath.Base(inputFile), filepath.Ext(inputFile))
-	var outputFile string
-	
-	if outputFormat == "wav" {
-		outputFile = filepath.Join(ae.TempDir, baseName+"_enhanced.wav")
-	} else {
-		outputFile = filepath.Join(ae.TempDir, baseName+"_enhanced.mp3")
-	}
+	outputFile := filepath.Join(ae.TempDir, baseName+"_enhanced"+OutputFormatSpecFor(outputFormat).Extension)
 	
 	err := ae.ApplyEnhancement(inputFile, outputFile, outputFormat, quality)
 	if err != nil {
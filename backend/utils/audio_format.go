@@ -0,0 +1,102 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strconv"
+)
+
+// AudioFormat describes the stream format of an audio file
+type AudioFormat struct {
+	Codec         string `json:"codec"`
+	SampleRate    int    `json:"sample_rate"`
+	Channels      int    `json:"channels"`
+	ChannelLayout string `json:"channel_layout,omitempty"`
+	SampleFormat  string `json:"sample_format,omitempty"`
+}
+
+// String formats the audio format for logs and reports, e.g. "mp3 44100Hz 2ch fltp"
+func (af AudioFormat) String() string {
+	return fmt.Sprintf("%s %dHz %dch %s", af.Codec, af.SampleRate, af.Channels, af.SampleFormat)
+}
+
+// FormatCache holds formats probed before a job, such as those of library assets, by file
+type FormatCache map[string]*AudioFormat
+
+// Probe returns a copy of the cached format of file, probing files it doesn't know
+func (fc FormatCache) Probe(file string) (*AudioFormat, error) {
+	if format, ok := fc[file]; ok {
+		probed := *format
+		return &probed, nil
+	}
+	return ProbeAudioFormat(file)
+}
+
+// ProbeAudioFormat reads the format of the first audio stream with ffprobe
+func ProbeAudioFormat(file string) (*AudioFormat, error) {
+	cmd := exec.Command("ffprobe",
+		"-v", "error",
+		"-select_streams", "a:0",
+		"-show_entries", "stream=codec_name,sample_rate,channels,channel_layout,sample_fmt",
+		"-of", "json",
+		file)
+
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("ffprobe error: %v", err)
+	}
+
+	var probe struct {
+		Streams []struct {
+			CodecName     string `json:"codec_name"`
+			SampleRate    string `json:"sample_rate"`
+			Channels      int    `json:"channels"`
+			ChannelLayout string `json:"channel_layout"`
+			SampleFmt     string `json:"sample_fmt"`
+		} `json:"streams"`
+	}
+	if err := json.Unmarshal(output, &probe); err != nil {
+		return nil, fmt.Errorf("failed to parse ffprobe output: %v", err)
+	}
+	if len(probe.Streams) == 0 {
+		return nil, fmt.Errorf("file does not contain an audio stream")
+	}
+
+	stream := probe.Streams[0]
+	sampleRate, err := strconv.Atoi(stream.SampleRate)
+	if err != nil {
+		return nil, fmt.Errorf("invalid sample rate: %s", stream.SampleRate)
+	}
+
+	return &AudioFormat{
+		Codec:         stream.CodecName,
+		SampleRate:    sampleRate,
+		Channels:      stream.Channels,
+		ChannelLayout: stream.ChannelLayout,
+		SampleFormat:  stream.SampleFmt,
+	}, nil
+}
+
+// CommonAudioFormat picks the sample rate most inputs already use (the highest on a tie)
+// and enough channels for the widest input, capped at stereo
+func CommonAudioFormat(formats []*AudioFormat) AudioFormat {
+	counts := make(map[int]int)
+	target := AudioFormat{SampleRate: 48000, Channels: 1}
+	best := 0
+	for _, format := range formats {
+		counts[format.SampleRate]++
+		count := counts[format.SampleRate]
+		if count > best || (count == best && format.SampleRate > target.SampleRate) {
+			best = count
+			target.SampleRate = format.SampleRate
+		}
+		if format.Channels > target.Channels {
+			target.Channels = format.Channels
+		}
+	}
+	if target.Channels > 2 {
+		target.Channels = 2
+	}
+	return target
+}
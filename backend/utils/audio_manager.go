@@ -39,6 +39,19 @@ func (am *AudioManager) ProcessAudioSequenceWithProgress(inputFiles []string, ou
 
 // ProcessAudioSequenceWithProgressAndStereo handles audio processing with progress tracking and stereo options
 func (am *AudioManager) ProcessAudioSequenceWithProgressAndStereo(inputFiles []string, outputFile string, loops int, crossfadeDuration float64, enhance bool, dolbyStereo bool, format, sessionID string) error {
+	options := DefaultMixOptions()
+	options.Loops = loops
+	options.Crossfade = crossfadeDuration
+	options.Enhance = enhance
+	if dolbyStereo {
+		options.StereoMode = StereoModeWiden
+	}
+	options.Format = format
+	return am.ProcessAudioSequenceWithMixOptions(inputFiles, outputFile, options, sessionID)
+}
+
+// ProcessAudioSequenceWithMixOptions handles audio processing with the full set of mix options
+func (am *AudioManager) ProcessAudioSequenceWithMixOptions(inputFiles []string, outputFile string, options MixOptions, sessionID string) error {
 	// Step 1: Validate all input files
 	if err := am.Validator.ValidateFiles(inputFiles); err != nil {
 		return fmt.Errorf("validation failed: %v", err)
@@ -54,10 +67,20 @@ func (am *AudioManager) ProcessAudioSequenceWithProgressAndStereo(inputFiles []s
 	}
 	defer os.RemoveAll(sessionDir)
 
-	// Step 3: Initialize sequencer with options including stereo
-	am.Sequencer = NewAudioSequencerWithStereoOptions(inputFiles, outputFile, crossfadeDuration, loops, sessionDir, enhance, dolbyStereo, format)
+	// Step 3: Run per-track stages such as silence trimming, tempo analysis and ordering
+	if options.HasTrackStages() {
+		GlobalProgressTracker.UpdateProgress(sessionID, "preparing", "Preparing tracks...", 10, "", len(inputFiles))
+		prepared, preparedOptions, err := PrepareTracks(filepath.Join(sessionDir, "prepared"), inputFiles, options)
+		if err != nil {
+			return fmt.Errorf("track preparation failed: %v", err)
+		}
+		inputFiles, options = prepared, preparedOptions
+	}
+
+	// Step 4: Initialize sequencer with options including stereo
+	am.Sequencer = NewAudioSequencerWithMixOptions(inputFiles, outputFile, sessionDir, options.WithoutTrackStages())
 
-	// Step 4: Process the sequence with progress tracking
+	// Step 5: Process the sequence with progress tracking
 	if err := am.Sequencer.ProcessWithProgress(sessionID, GlobalProgressTracker); err != nil {
 		return fmt.Errorf("sequencing failed: %v", err)
 	}
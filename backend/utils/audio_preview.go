@@ -0,0 +1,318 @@
+package utils
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+)
+
+const (
+	DefaultSamplesPerPixel   = 512
+	DefaultSpectrogramWidth  = 1024
+	DefaultSpectrogramHeight = 512
+
+	waveformSampleRate = 44100
+	waveformVersion    = 2
+)
+
+// previewIDPattern matches cache IDs produced by PreviewGenerator
+var previewIDPattern = regexp.MustCompile(`^[a-f0-9]{64}_[a-z0-9_]+\.(png|dat)$`)
+
+// WaveformPeaks holds downsampled min/max pairs in the audiowaveform (peaks.js) data layout
+type WaveformPeaks struct {
+	Version         int   `json:"version"`
+	Channels        int   `json:"channels"`
+	SampleRate      int   `json:"sample_rate"`
+	SamplesPerPixel int   `json:"samples_per_pixel"`
+	Bits            int   `json:"bits"`
+	Length          int   `json:"length"`
+	Data            []int `json:"data"`
+}
+
+// PreviewGenerator renders waveform peaks and spectrogram images, cached by content hash
+// under <Prefix>/ in the storage. Images are rendered in WorkDir before they are stored.
+type PreviewGenerator struct {
+	Storage Storage
+	Prefix  string
+	WorkDir string
+}
+
+// NewPreviewGenerator creates a new preview generator
+func NewPreviewGenerator(storage Storage, prefix, workDir string) *PreviewGenerator {
+	return &PreviewGenerator{
+		Storage: storage,
+		Prefix:  prefix,
+		WorkDir: workDir,
+	}
+}
+
+// GlobalPreviewGenerator is the shared preview generator under the previews prefix
+var GlobalPreviewGenerator = NewPreviewGenerator(GlobalStorage, "previews", filepath.Join(GlobalConfig.WorkDir, "previews"))
+
+// key is the storage key of a preview
+func (pg *PreviewGenerator) key(id string) string {
+	return path.Join(pg.Prefix, id)
+}
+
+// WaveformPeaks returns the min/max peaks of a file along with their cache ID
+func (pg *PreviewGenerator) WaveformPeaks(file string, samplesPerPixel, bits int) (*WaveformPeaks, string, error) {
+	if samplesPerPixel < 32 {
+		samplesPerPixel = DefaultSamplesPerPixel
+	}
+	if bits != 16 {
+		bits = 8
+	}
+
+	hash, err := FileSHA256(file)
+	if err != nil {
+		return nil, "", err
+	}
+	id := fmt.Sprintf("%s_peaks_%d_%d.dat", hash, samplesPerPixel, bits)
+
+	// Serve from cache if these peaks were computed before
+	if cached, _, err := pg.Storage.Get(pg.key(id)); err == nil {
+		defer cached.Close()
+		peaks, err := ReadWaveformData(cached)
+		if err == nil {
+			return peaks, id, nil
+		}
+	}
+
+	peaks, err := computeWaveformPeaks(file, samplesPerPixel, bits)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var data bytes.Buffer
+	if err := peaks.WriteBinary(&data); err != nil {
+		return nil, "", err
+	}
+	if err := pg.Storage.Put(pg.key(id), &data, int64(data.Len())); err != nil {
+		return nil, "", fmt.Errorf("failed to store waveform: %v", err)
+	}
+
+	return peaks, id, nil
+}
+
+// Spectrogram renders a spectrogram PNG of a file and returns its cache ID
+func (pg *PreviewGenerator) Spectrogram(file string, width, height int) (string, error) {
+	if width <= 0 || width > 8192 {
+		width = DefaultSpectrogramWidth
+	}
+	if height <= 0 || height > 4096 {
+		height = DefaultSpectrogramHeight
+	}
+
+	hash, err := FileSHA256(file)
+	if err != nil {
+		return "", err
+	}
+	id := fmt.Sprintf("%s_spectrum_%dx%d.png", hash, width, height)
+
+	if _, err := pg.Storage.Stat(pg.key(id)); err == nil {
+		return id, nil
+	}
+
+	if err := os.MkdirAll(pg.WorkDir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create preview directory: %v", err)
+	}
+
+	// Render to a unique name, the storage only ever sees the finished image
+	temp, err := os.CreateTemp(pg.WorkDir, "spectrum_*.png")
+	if err != nil {
+		return "", fmt.Errorf("failed to create preview file: %v", err)
+	}
+	temp.Close()
+	defer os.Remove(temp.Name())
+
+	cmd := exec.Command("ffmpeg",
+		"-i", file,
+		"-lavfi", fmt.Sprintf("showspectrumpic=s=%dx%d:legend=1", width, height),
+		"-frames:v", "1",
+		"-y", temp.Name())
+
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("ffmpeg spectrogram error: %v\nOutput: %s", err, output)
+	}
+
+	if err := PutFile(pg.Storage, pg.key(id), temp.Name()); err != nil {
+		return "", fmt.Errorf("failed to store spectrogram: %v", err)
+	}
+
+	return id, nil
+}
+
+// ServePreview answers a request for a cached preview; content-hashed IDs never change,
+// so found previews can be cached forever
+func (pg *PreviewGenerator) ServePreview(w http.ResponseWriter, r *http.Request, id string) {
+	if !previewIDPattern.MatchString(id) {
+		http.Error(w, "Preview not found", http.StatusNotFound)
+		return
+	}
+	if _, err := pg.Storage.Stat(pg.key(id)); err != nil {
+		http.Error(w, "Preview not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	ServeObject(w, r, pg.Storage, pg.key(id), "")
+}
+
+// computeWaveformPeaks decodes a file to mono and reduces it to min/max pairs
+func computeWaveformPeaks(file string, samplesPerPixel, bits int) (*WaveformPeaks, error) {
+	scale := 127.0
+	if bits == 16 {
+		scale = 32767.0
+	}
+	quantize := func(v float32) int {
+		q := int(math.Round(float64(v) * scale))
+		if q > int(scale) {
+			q = int(scale)
+		}
+		if q < -int(scale)-1 {
+			q = -int(scale) - 1
+		}
+		return q
+	}
+
+	peaks := &WaveformPeaks{
+		Version:         waveformVersion,
+		Channels:        1,
+		SampleRate:      waveformSampleRate,
+		SamplesPerPixel: samplesPerPixel,
+		Bits:            bits,
+	}
+
+	var minVal, maxVal float32
+	count := 0
+	flush := func() {
+		peaks.Data = append(peaks.Data, quantize(minVal), quantize(maxVal))
+		count = 0
+	}
+
+	err := StreamPCM(file, waveformSampleRate, 1, func(samples []float32) error {
+		for _, s := range samples {
+			if count == 0 || s < minVal {
+				minVal = s
+			}
+			if count == 0 || s > maxVal {
+				maxVal = s
+			}
+			count++
+			if count == samplesPerPixel {
+				flush()
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if count > 0 {
+		flush()
+	}
+
+	peaks.Length = len(peaks.Data) / 2
+	return peaks, nil
+}
+
+// WriteBinary writes the peaks in the audiowaveform version 2 .dat format
+func (wp *WaveformPeaks) WriteBinary(w io.Writer) error {
+	flags := uint32(0)
+	if wp.Bits == 8 {
+		flags = 1
+	}
+
+	header := []interface{}{
+		int32(wp.Version),
+		flags,
+		int32(wp.SampleRate),
+		int32(wp.SamplesPerPixel),
+		uint32(wp.Length),
+		int32(wp.Channels),
+	}
+	for _, field := range header {
+		if err := binary.Write(w, binary.LittleEndian, field); err != nil {
+			return fmt.Errorf("failed to write waveform header: %v", err)
+		}
+	}
+
+	for _, v := range wp.Data {
+		var err error
+		if wp.Bits == 8 {
+			err = binary.Write(w, binary.LittleEndian, int8(v))
+		} else {
+			err = binary.Write(w, binary.LittleEndian, int16(v))
+		}
+		if err != nil {
+			return fmt.Errorf("failed to write waveform data: %v", err)
+		}
+	}
+	return nil
+}
+
+// ReadWaveformData parses audiowaveform .dat data (version 1 or 2)
+func ReadWaveformData(r io.Reader) (*WaveformPeaks, error) {
+	br := bufio.NewReader(r)
+
+	var version int32
+	var flags uint32
+	var sampleRate, samplesPerPixel int32
+	var length uint32
+	for _, field := range []interface{}{&version, &flags, &sampleRate, &samplesPerPixel, &length} {
+		if err := binary.Read(br, binary.LittleEndian, field); err != nil {
+			return nil, fmt.Errorf("failed to read waveform header: %v", err)
+		}
+	}
+
+	channels := int32(1)
+	if version == 2 {
+		if err := binary.Read(br, binary.LittleEndian, &channels); err != nil {
+			return nil, fmt.Errorf("failed to read waveform header: %v", err)
+		}
+	} else if version != 1 {
+		return nil, fmt.Errorf("unsupported waveform data version: %d", version)
+	}
+
+	peaks := &WaveformPeaks{
+		Version:         int(version),
+		Channels:        int(channels),
+		SampleRate:      int(sampleRate),
+		SamplesPerPixel: int(samplesPerPixel),
+		Bits:            16,
+		Length:          int(length),
+	}
+	if flags&1 == 1 {
+		peaks.Bits = 8
+	}
+
+	total := int(length) * int(channels) * 2
+	peaks.Data = make([]int, total)
+	for i := 0; i < total; i++ {
+		if peaks.Bits == 8 {
+			var v int8
+			if err := binary.Read(br, binary.LittleEndian, &v); err != nil {
+				return nil, fmt.Errorf("failed to read waveform data: %v", err)
+			}
+			peaks.Data[i] = int(v)
+		} else {
+			var v int16
+			if err := binary.Read(br, binary.LittleEndian, &v); err != nil {
+				return nil, fmt.Errorf("failed to read waveform data: %v", err)
+			}
+			peaks.Data[i] = int(v)
+		}
+	}
+
+	return peaks, nil
+}
@@ -2,6 +2,8 @@ package utils
 
 import (
 	"fmt"
+	"log"
+	"math"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -16,9 +18,14 @@ type AudioSequencer struct {
 	LoopCount         int
 	TempDir           string
 	Enhance           bool
-	DolbyStereo       bool   // Dolby Stereo simulation
 	OutputFormat      string // "mp3" or "wav"
 	Quality           string // "320k" for mp3, "pcm_s24le" for wav
+	Options           MixOptions
+	// Timeline is where every track plays in the output, set while processing
+	Timeline []TrackMarker
+
+	// sequence places every input in the sequence file (Start, Fade, SourceStart), set by the concatenation
+	sequence []TrackMarker
 }
 
 // NewAudioSequencer creates a new audio sequencer with default settings
@@ -33,22 +40,36 @@ func NewAudioSequencerWithOptions(inputFiles []string, outputFile string, crossf
 
 // NewAudioSequencerWithStereoOptions creates a new audio sequencer with stereo options
 func NewAudioSequencerWithStereoOptions(inputFiles []string, outputFile string, crossfadeDuration float64, loopCount int, tempDir string, enhance bool, dolbyStereo bool, format string) *AudioSequencer {
+	options := DefaultMixOptions()
+	options.Loops = loopCount
+	options.Crossfade = crossfadeDuration
+	options.Enhance = enhance
+	if dolbyStereo {
+		options.StereoMode = StereoModeWiden
+	}
+	options.Format = format
+	return NewAudioSequencerWithMixOptions(inputFiles, outputFile, tempDir, options)
+}
+
+// NewAudioSequencerWithMixOptions creates a new audio sequencer from the full set of mix options
+func NewAudioSequencerWithMixOptions(inputFiles []string, outputFile string, tempDir string, options MixOptions) *AudioSequencer {
 	// Determine quality based on format
-	quality := "320k"
-	if format == "wav" {
-		quality = "pcm_s24le"
+	spec := OutputFormatSpecFor(options.Format)
+	quality := spec.Bitrate
+	if options.Format == "wav" {
+		quality = spec.Codec
 	}
 	
 	return &AudioSequencer{
 		InputFiles:        inputFiles,
 		OutputFile:        outputFile,
-		CrossfadeDuration: crossfadeDuration,
-		LoopCount:         loopCount,
+		CrossfadeDuration: options.Crossfade,
+		LoopCount:         options.Loops,
 		TempDir:           tempDir,
-		Enhance:           enhance,
-		DolbyStereo:       dolbyStereo,
-		OutputFormat:      format,
+		Enhance:           options.Enhance,
+		OutputFormat:      options.Format,
 		Quality:           quality,
+		Options:           options,
 	}
 }
 
@@ -81,12 +102,13 @@ func (as *AudioSequencer) ProcessWithProgress(sessionID string, tracker *Progres
 		tracker.UpdateProgress(sessionID, "sequencing", "Creating audio sequence...", 20, "", len(as.InputFiles))
 	}
 	
-	sequenceFile := filepath.Join(as.TempDir, "sequence.mp3")
+	sequenceFile := filepath.Join(as.TempDir, "sequence"+as.intermediateExt())
 	err := as.createSequenceWithCrossfades(sequenceFile)
 	if err != nil {
 		return fmt.Errorf("failed to create sequence: %v", err)
 	}
 	defer os.Remove(sequenceFile)
+	as.Timeline = as.markersFor(nil, as.sequence)
 
 	// Step 3: Apply looping with crossfade at boundaries
 	var finalFile string
@@ -94,37 +116,131 @@ func (as *AudioSequencer) ProcessWithProgress(sessionID string, tracker *Progres
 		if tracker != nil && sessionID != "" {
 			tracker.UpdateProgress(sessionID, "looping", "Creating looped sequence...", 50, "", as.LoopCount)
 		}
-		err = as.createLoopedSequence(sequenceFile)
+		if as.Options.ReshuffleLoops && len(as.InputFiles) > 1 {
+			err = as.createReshuffledLoops(sequenceFile)
+		} else if as.Options.SeamlessLoop && len(as.InputFiles) == 1 {
+			err = as.createSeamlessLoop(sequenceFile)
+		} else {
+			err = as.createLoopedSequence(sequenceFile)
+		}
 		if err != nil {
 			return fmt.Errorf("failed to create looped sequence: %v", err)
 		}
-		finalFile = filepath.Join(as.TempDir, "looped.mp3")
+		finalFile = filepath.Join(as.TempDir, "looped"+as.intermediateExt())
 	} else {
 		finalFile = sequenceFile
 	}
 
-	// Step 4: Apply enhancement if requested
+	// Step 4: Noise and hum removal on the whole mix
+	if as.Options.Cleanup.Enabled() {
+		if tracker != nil && sessionID != "" {
+			tracker.UpdateProgress(sessionID, "cleanup", "Removing noise and hum...", 60, "", 0)
+		}
+		enhancer := NewAudioEnhancer(as.TempDir)
+		cleanedFile, report, err := enhancer.ApplyCleanup(finalFile, filepath.Join(as.TempDir, "cleaned.wav"), as.Options.Cleanup)
+		if err != nil {
+			return fmt.Errorf("failed to clean up audio: %v", err)
+		}
+		report.Target = "master"
+		report.Index = -1
+		if as.Options.Report != nil {
+			as.Options.Report.AddCleanup(*report)
+		}
+		if cleanedFile != finalFile {
+			defer os.Remove(cleanedFile)
+			finalFile = cleanedFile
+		}
+	}
+
+	// Step 5: Tone shaping with the master EQ
+	if len(as.Options.EQ) > 0 {
+		if tracker != nil && sessionID != "" {
+			tracker.UpdateProgress(sessionID, "eq", "Applying EQ...", 63, "", 0)
+		}
+		eqFile := filepath.Join(as.TempDir, "eq.wav")
+		enhancer := NewAudioEnhancer(as.TempDir)
+		if err := enhancer.ApplyEQ(finalFile, eqFile, as.Options.EQ); err != nil {
+			return fmt.Errorf("failed to apply eq: %v", err)
+		}
+		defer os.Remove(eqFile)
+		finalFile = eqFile
+	}
+
+	// Step 6: Gate and multiband compression
+	if as.Options.Dynamics.Enabled() {
+		if tracker != nil && sessionID != "" {
+			tracker.UpdateProgress(sessionID, "dynamics", "Applying dynamics processing...", 64, "", 0)
+		}
+		dynamicsFile := filepath.Join(as.TempDir, "dynamics.wav")
+		enhancer := NewAudioEnhancer(as.TempDir)
+		if err := enhancer.ApplyDynamics(finalFile, dynamicsFile, as.Options.Dynamics); err != nil {
+			return fmt.Errorf("failed to apply dynamics: %v", err)
+		}
+		if as.Options.Report != nil {
+			as.Options.Report.SetDynamics(as.Options.Dynamics)
+		}
+		defer os.Remove(dynamicsFile)
+		finalFile = dynamicsFile
+	}
+
+	// Step 7: Stereo processing of the finished sequence
+	if as.Options.StereoMode != "" && as.Options.StereoMode != StereoModePassthrough {
+		if tracker != nil && sessionID != "" {
+			tracker.UpdateProgress(sessionID, "stereo", "Applying stereo processing...", 65, "", 0)
+		}
+		processor := NewStereoProcessor(as.TempDir, as.Options)
+		stereoFile, report, err := processor.Process(finalFile)
+		if err != nil {
+			return fmt.Errorf("failed to apply stereo mode: %v", err)
+		}
+		if as.Options.Report != nil {
+			as.Options.Report.SetStereo(*report)
+		}
+		if stereoFile != finalFile {
+			defer os.Remove(stereoFile)
+			finalFile = stereoFile
+		}
+	}
+
+	// Chapters need the final length; the stages from here on don't change it
+	if err := as.finishTimeline(finalFile); err != nil {
+		return err
+	}
+
+	// Step 8: Apply enhancement if requested
 	if as.Enhance {
 		if tracker != nil && sessionID != "" {
 			tracker.UpdateProgress(sessionID, "enhancing", "Applying audio enhancement...", 75, "", 0)
 		}
 		enhancer := NewAudioEnhancer(as.TempDir)
-		err = enhancer.ApplyEnhancement(finalFile, as.OutputFile, as.OutputFormat, as.Quality)
+		enhancer.Tags = as.outputTags()
+		report, err := enhancer.ApplyEnhancementWithLimiter(finalFile, as.OutputFile, as.OutputFormat, as.Quality, as.limiter())
 		if err != nil {
 			return fmt.Errorf("failed to enhance audio: %v", err)
 		}
+		as.recordLimiter(report)
 	} else {
 		if tracker != nil && sessionID != "" {
 			tracker.UpdateProgress(sessionID, "finalizing", "Finalizing output...", 90, "", 0)
 		}
-		// Copy final file to output
-		err = as.copyFile(finalFile, as.OutputFile)
+		// Render final file to output through the limiter
+		report, err := as.renderFile(finalFile, as.OutputFile, as.limiter())
 		if err != nil {
 			return fmt.Errorf("failed to copy final file: %v", err)
 		}
+		as.recordLimiter(report)
+	}
+	if err := as.outputTags().WriteChunks(as.OutputFile, as.OutputFormat); err != nil {
+		// The tags ffmpeg wrote are in place, only the iXML chunk or FLAC cuesheet is missing
+		log.Printf("Skipping extra metadata for %s: %v", as.OutputFile, err)
+	}
+	if as.Options.ExportStems {
+		if err := as.exportStemManifest(); err != nil {
+			return fmt.Errorf("failed to export stems: %v", err)
+		}
 	}
 
-	// Step 5: Complete
+	// Step 9: Complete
 	if tracker != nil && sessionID != "" {
 		tracker.UpdateProgress(sessionID, "completed", "Audio processing completed!", 100, "", 0)
 	}
@@ -136,14 +252,25 @@ func (as *AudioSequencer) ProcessWithProgress(sessionID string, tracker *Progres
 func (as *AudioSequencer) createSequenceWithCrossfades(outputFile string) error {
 	if len(as.InputFiles) == 1 {
 		// Single file, just copy it
+		as.sequence = []TrackMarker{{}}
 		return as.copyFile(as.InputFiles[0], outputFile)
 	}
 
+	if as.Options.Gapless {
+		// Sample-accurate join; tracks butt up against each other without crossfades
+		return as.concatenateGapless(outputFile)
+	}
+
 	if as.CrossfadeDuration <= 0 {
 		// No crossfade, use simple concatenation
 		return as.concatenateFiles(outputFile)
 	}
 
+	if as.Options.BeatAlignedCrossfades() {
+		// Beat-aligned crossfades starting on downbeats
+		return as.concatenateOnBeats(outputFile)
+	}
+
 	// Use crossfade concatenation
 	return as.concatenateWithCrossfade(outputFile)
 }
@@ -155,8 +282,17 @@ func (as *AudioSequencer) concatenateFiles(outputFile string) error {
 	defer os.Remove(concatFile)
 
 	var concatContent strings.Builder
-	for _, file := range as.InputFiles {
+	as.sequence = make([]TrackMarker, len(as.InputFiles))
+	position := 0.0
+	for i, file := range as.InputFiles {
 		concatContent.WriteString(fmt.Sprintf("file '%s'\n", file))
+
+		duration, err := GetAudioDuration(file)
+		if err != nil {
+			return fmt.Errorf("failed to get duration of file %d: %v", i+1, err)
+		}
+		as.sequence[i].Start = position
+		position += duration
 	}
 
 	err := os.WriteFile(concatFile, []byte(concatContent.String()), 0644)
@@ -180,27 +316,70 @@ func (as *AudioSequencer) concatenateFiles(outputFile string) error {
 	return nil
 }
 
+// concatenateGapless decodes every input to a common PCM format, strips MP3 encoder delay
+// and padding and joins the samples back to back
+func (as *AudioSequencer) concatenateGapless(outputFile string) error {
+	gaplessFile := filepath.Join(as.TempDir, "gapless.wav")
+	defer os.Remove(gaplessFile)
+
+	concatenator := NewGaplessConcatenator(as.TempDir, as.Options.InputNames)
+	concatenator.Formats = as.Options.KnownFormats
+	result, err := concatenator.Concatenate(as.InputFiles, gaplessFile)
+	if err != nil {
+		return err
+	}
+	if as.Options.Report != nil {
+		as.Options.Report.AddGapless(*result)
+	}
+
+	as.sequence = make([]TrackMarker, len(result.Inputs))
+	var position int64
+	for i, input := range result.Inputs {
+		as.sequence[i].Start = float64(position) / float64(result.SampleRate)
+		position += input.Samples
+	}
+
+	// The join is already in the intermediate format; re-encoding it to MP3 would add
+	// encoder delay and padding back
+	if filepath.Ext(outputFile) == ".wav" {
+		return os.Rename(gaplessFile, outputFile)
+	}
+	return as.copyFile(gaplessFile, outputFile)
+}
+
 // concatenateWithCrossfade concatenates files with crossfade transitions
 func (as *AudioSequencer) concatenateWithCrossfade(outputFile string) error {
 	// Start with first file
 	currentFile := as.InputFiles[0]
 	
+	// Every track starts fading in where the previous sequence is one fade short of its end
+	fade := math.Round(as.CrossfadeDuration*10) / 10
+	length, err := GetAudioDuration(currentFile)
+	if err != nil {
+		return fmt.Errorf("failed to get duration of file 1: %v", err)
+	}
+	as.sequence = []TrackMarker{{}}
+	
 	for i := 1; i < len(as.InputFiles); i++ {
 		nextFile := as.InputFiles[i]
-		tempOutput := filepath.Join(as.TempDir, fmt.Sprintf("temp_concat_%d.mp3", i))
+		tempOutput := filepath.Join(as.TempDir, fmt.Sprintf("temp_concat_%d%s", i, as.intermediateExt()))
+		
+		duration, err := GetAudioDuration(nextFile)
+		if err != nil {
+			return fmt.Errorf("failed to get duration of file %d: %v", i+1, err)
+		}
+		as.sequence = append(as.sequence, TrackMarker{Start: length - fade, Fade: fade})
+		length += duration - fade
 		
 		// Crossfade current with next
-		cmd := exec.Command("ffmpeg",
+		args := []string{
 			"-i", currentFile,
 			"-i", nextFile,
 			"-filter_complex",
 			fmt.Sprintf("[0][1]acrossfade=d=%.1f:c1=tri:c2=tri", as.CrossfadeDuration),
-			"-metadata", "artist=e.bitzy.id",
-			"-metadata", "author=e.bitzy.id",
-			"-metadata", "composer=e.bitzy.id",
-			"-metadata", "comment=Mixed with MixLoop by BITZY.ID",
-			"-acodec", "libmp3lame",
-			"-y", tempOutput)
+		}
+		args = append(args, as.intermediateCodecArgs()...)
+		cmd := exec.Command("ffmpeg", append(args, "-y", tempOutput)...)
 		
 		output, err := cmd.CombinedOutput()
 		if err != nil {
@@ -233,25 +412,25 @@ func (as *AudioSequencer) createLoopedSequence(sequenceFile string) error {
 	}
 
 	// Adjust crossfade if it's too long
-	crossfade := as.CrossfadeDuration
+	crossfade := as.loopCrossfadeDuration()
 	if crossfade > duration/2 {
 		crossfade = duration / 2
 	}
 
+	// Every iteration starts fading in one crossfade before the previous one ends
+	as.Timeline = repeatTimeline(as.Timeline, duration-crossfade, as.LoopCount, crossfade)
+
 	if as.LoopCount == 2 {
 		// Simple case: two loops with crossfade
-		loopedFile := filepath.Join(as.TempDir, "looped.mp3")
-		cmd := exec.Command("ffmpeg",
+		loopedFile := filepath.Join(as.TempDir, "looped"+as.intermediateExt())
+		args := []string{
 			"-i", sequenceFile,
 			"-i", sequenceFile,
 			"-filter_complex",
-			fmt.Sprintf("[0][1]acrossfade=d=%.1f:c1=tri:c2=tri", crossfade),
-			"-metadata", "artist=e.bitzy.id",
-			"-metadata", "author=e.bitzy.id",
-			"-metadata", "composer=e.bitzy.id",
-			"-metadata", "comment=Mixed with MixLoop by BITZY.ID",
-			"-acodec", "libmp3lame",
-			"-y", loopedFile)
+			fmt.Sprintf("[0][1]acrossfade=d=%.3f:c1=tri:c2=tri", crossfade),
+		}
+		args = append(args, as.intermediateCodecArgs()...)
+		cmd := exec.Command("ffmpeg", append(args, "-y", loopedFile)...)
 		
 		output, err := cmd.CombinedOutput()
 		if err != nil {
@@ -261,7 +440,7 @@ func (as *AudioSequencer) createLoopedSequence(sequenceFile string) error {
 	}
 
 	// Multiple loops: create chain of crossfades
-	loopedFile := filepath.Join(as.TempDir, "looped.mp3")
+	loopedFile := filepath.Join(as.TempDir, "looped"+as.intermediateExt())
 	err = as.createMultipleLoops(sequenceFile, crossfade, loopedFile)
 	if err != nil {
 		return err
@@ -273,44 +452,108 @@ func (as *AudioSequencer) createLoopedSequence(sequenceFile string) error {
 func (as *AudioSequencer) createMultipleLoops(sequenceFile string, crossfade float64, outputFile string) error {
 	// Create temporary copies for each loop
 	var tempFiles []string
-	var inputs []string
 	
 	for i := 0; i < as.LoopCount; i++ {
-		tempFile := filepath.Join(as.TempDir, fmt.Sprintf("loop_%d.mp3", i))
+		tempFile := filepath.Join(as.TempDir, fmt.Sprintf("loop_%d%s", i, as.intermediateExt()))
 		err := as.copyFile(sequenceFile, tempFile)
 		if err != nil {
 			return fmt.Errorf("failed to create loop copy %d: %v", i, err)
 		}
 		tempFiles = append(tempFiles, tempFile)
-		inputs = append(inputs, "-i", tempFile)
 		defer os.Remove(tempFile)
 	}
 
+	return as.crossfadeChain(tempFiles, crossfade, outputFile)
+}
+
+// createReshuffledLoops builds every loop iteration from its own shuffled track order
+// and chains the iterations with crossfades into the looped intermediate
+func (as *AudioSequencer) createReshuffledLoops(firstSequence string) error {
+	orders := ShuffledLoopOrders(len(as.InputFiles), as.LoopCount, as.Options.OrderSeed)
+	if as.Options.Report != nil {
+		as.Options.Report.SetOrderSeed(as.Options.OrderSeed)
+	}
+
+	iterations := []string{firstSequence}
+	markers := [][]TrackMarker{as.Timeline}
+	for loop := 1; loop < len(orders); loop++ {
+		// Each iteration gets its own sequencer so temp files don't collide
+		iteration := *as
+		iteration.InputFiles = make([]string, len(orders[loop]))
+		for i, index := range orders[loop] {
+			iteration.InputFiles[i] = as.InputFiles[index]
+		}
+		iteration.TempDir = filepath.Join(as.TempDir, fmt.Sprintf("loop_%d", loop))
+		if err := os.MkdirAll(iteration.TempDir, 0755); err != nil {
+			return fmt.Errorf("failed to create loop directory: %v", err)
+		}
+		defer os.RemoveAll(iteration.TempDir)
+
+		sequenceFile := filepath.Join(iteration.TempDir, "sequence"+as.intermediateExt())
+		if err := iteration.createSequenceWithCrossfades(sequenceFile); err != nil {
+			return fmt.Errorf("failed to create loop %d: %v", loop+1, err)
+		}
+		iterations = append(iterations, sequenceFile)
+		markers = append(markers, as.markersFor(orders[loop], iteration.sequence))
+	}
+
+	// Shorter iterations limit the boundary crossfade like createLoopedSequence does
+	crossfade := as.loopCrossfadeDuration()
+	durations := make([]float64, len(iterations))
+	for i, file := range iterations {
+		duration, err := GetAudioDuration(file)
+		if err != nil {
+			return fmt.Errorf("failed to get sequence duration: %v", err)
+		}
+		if crossfade > duration/2 {
+			crossfade = duration / 2
+		}
+		durations[i] = duration
+	}
+
+	as.Timeline = nil
+	offset := 0.0
+	for i := range iterations {
+		iteration := ShiftMarkers(markers[i], offset, i+1)
+		if i > 0 {
+			fadeIn(iteration, crossfade)
+		}
+		as.Timeline = append(as.Timeline, iteration...)
+		offset += durations[i] - crossfade
+	}
+
+	return as.crossfadeChain(iterations, crossfade, filepath.Join(as.TempDir, "looped"+as.intermediateExt()))
+}
+
+// crossfadeChain joins files in order with a crossfade at every boundary
+func (as *AudioSequencer) crossfadeChain(files []string, crossfade float64, outputFile string) error {
+	var inputs []string
+	for _, file := range files {
+		inputs = append(inputs, "-i", file)
+	}
+
 	// Build crossfade chain
 	var filterParts []string
 	currentLabel := "0"
 	
-	for i := 1; i < as.LoopCount; i++ {
-		if i == as.LoopCount-1 {
+	for i := 1; i < len(files); i++ {
+		if i == len(files)-1 {
 			// Last crossfade
-			filterParts = append(filterParts, fmt.Sprintf("[%s][%d]acrossfade=d=%.1f:c1=tri:c2=tri", currentLabel, i, crossfade))
+			filterParts = append(filterParts, fmt.Sprintf("[%s][%d]acrossfade=d=%.3f:c1=tri:c2=tri", currentLabel, i, crossfade))
 		} else {
 			nextLabel := fmt.Sprintf("cf%d", i)
-			filterParts = append(filterParts, fmt.Sprintf("[%s][%d]acrossfade=d=%.1f:c1=tri:c2=tri[%s]", currentLabel, i, crossfade, nextLabel))
+			filterParts = append(filterParts, fmt.Sprintf("[%s][%d]acrossfade=d=%.3f:c1=tri:c2=tri[%s]", currentLabel, i, crossfade, nextLabel))
 			currentLabel = nextLabel
 		}
 	}
 
 	filterComplex := strings.Join(filterParts, ";")
 
-	// Execute FFmpeg command with metadata
+	// Execute FFmpeg command
 	args := inputs
 	args = append(args, "-filter_complex", filterComplex)
-	args = append(args, "-metadata", "artist=e.bitzy.id")
-	args = append(args, "-metadata", "author=e.bitzy.id")
-	args = append(args, "-metadata", "composer=e.bitzy.id")
-	args = append(args, "-metadata", "comment=Mixed with MixLoop by BITZY.ID")
-	args = append(args, "-acodec", "libmp3lame", "-y", outputFile)
+	args = append(args, as.intermediateCodecArgs()...)
+	args = append(args, "-y", outputFile)
 
 	cmd := exec.Command("ffmpeg", args...)
 	output, err := cmd.CombinedOutput()
@@ -321,31 +564,175 @@ func (as *AudioSequencer) createMultipleLoops(sequenceFile string, crossfade flo
 	return nil
 }
 
+// markersFor names the placements of a sequence. order maps sequence positions to
+// InputFiles indexes, nil meaning InputFiles order. Inputs that are themselves mixes, such
+// as batch chunks, contribute their own timelines from InputMarkers.
+func (as *AudioSequencer) markersFor(order []int, placements []TrackMarker) []TrackMarker {
+	var markers []TrackMarker
+	for position, placement := range placements {
+		i := position
+		if order != nil {
+			i = order[position]
+		}
+		if len(as.Options.InputMarkers) == len(as.InputFiles) {
+			markers = append(markers, fadeIn(ShiftMarkers(as.Options.InputMarkers[i], placement.Start, 1), placement.Fade)...)
+			continue
+		}
+
+		marker := placement
+		marker.Index = as.Options.InputIndex(i)
+		marker.Loop = 1
+		if i < len(as.Options.InputNames) {
+			marker.Name = as.Options.InputNames[i]
+		}
+		markers = append(markers, marker)
+	}
+	return markers
+}
+
+// repeatTimeline repeats markers for loops iterations that start period seconds apart,
+// every iteration after the first fading in over fade seconds
+func repeatTimeline(markers []TrackMarker, period float64, loops int, fade float64) []TrackMarker {
+	var timeline []TrackMarker
+	for loop := 0; loop < loops; loop++ {
+		iteration := ShiftMarkers(markers, float64(loop)*period, loop+1)
+		if loop > 0 {
+			fadeIn(iteration, fade)
+		}
+		timeline = append(timeline, iteration...)
+	}
+	return timeline
+}
+
+// fadeIn sets the crossfade into the first marker when there is one
+func fadeIn(markers []TrackMarker, fade float64) []TrackMarker {
+	if len(markers) > 0 && fade > 0 {
+		markers[0].Fade = fade
+	}
+	return markers
+}
+
+// finishTimeline closes the timeline at the length of finalFile and records it in the report
+func (as *AudioSequencer) finishTimeline(finalFile string) error {
+	duration, err := GetAudioDuration(finalFile)
+	if err != nil {
+		return fmt.Errorf("failed to get mix duration: %v", err)
+	}
+	as.Timeline = FinishTimeline(as.Timeline, duration)
+
+	if as.Options.Report != nil {
+		name := as.Options.OutputName
+		if name == "" {
+			name = filepath.Base(as.OutputFile)
+		}
+		as.Options.Report.SetTracklist(as.Timeline,
+			CueSheet(as.Timeline, as.Options.Metadata, name, as.OutputFormat),
+			YouTubeTimestamps(as.Timeline))
+	}
+	return nil
+}
+
+// outputTags returns the tags for the final output; a single track gets no chapters
+func (as *AudioSequencer) outputTags() OutputTags {
+	tags := OutputTags{Metadata: as.Options.Metadata, Cover: as.Options.Cover}
+	if as.Options.Chapters && len(as.Timeline) > 1 {
+		tags.Chapters = as.Timeline
+	}
+	return tags
+}
+
+// limiter returns the output limiter, or nil when it is disabled
+func (as *AudioSequencer) limiter() *Limiter {
+	if !as.Options.Limiter {
+		return nil
+	}
+	return NewLimiter(as.Options.LimiterCeiling)
+}
+
+// recordLimiter adds the limiter result to the job report
+func (as *AudioSequencer) recordLimiter(report *LimiterReport) {
+	if report != nil && as.Options.Report != nil {
+		as.Options.Report.SetLimiter(*report)
+	}
+}
+
+// pcmIntermediates reports whether intermediates are kept in PCM. Gapless jobs need the exact
+// sample count, since every MP3 generation adds encoder delay and padding, and lossless
+// outputs must not pass through a lossy encoder on the way.
+func (as *AudioSequencer) pcmIntermediates() bool {
+	return as.Options.Gapless || OutputFormatSpecFor(as.OutputFormat).Bitrate == ""
+}
+
+// intermediateExt is the extension of the sequence and loop intermediates
+func (as *AudioSequencer) intermediateExt() string {
+	if as.pcmIntermediates() {
+		return ".wav"
+	}
+	return ".mp3"
+}
+
+// intermediateCodecArgs are the ffmpeg encoder arguments for intermediates
+func (as *AudioSequencer) intermediateCodecArgs() []string {
+	if as.pcmIntermediates() {
+		return []string{"-c:a", "pcm_f32le", "-rf64", "auto"}
+	}
+	return []string{"-acodec", "libmp3lame", "-b:a", OutputFormats["mp3"].Bitrate}
+}
+
 // copyFile copies a file from src to dst with proper format handling
 func (as *AudioSequencer) copyFile(src, dst string) error {
+	_, err := as.renderFile(src, dst, nil)
+	return err
+}
+
+// renderFile copies src to dst like copyFile, running the limiter last when it is set
+func (as *AudioSequencer) renderFile(src, dst string, limiter *Limiter) (*LimiterReport, error) {
+	// Pick the codec from the destination, intermediates are MP3 even for WAV output.
+	// Only the final output is tagged.
+	format := OutputFormatForFile(dst)
+	final := dst == as.OutputFile
+	var tagInputs, tagOutputs []string
+	if final {
+		var err error
+		tagInputs, tagOutputs, err = as.outputTags().FFmpegArgs(format, as.TempDir)
+		if err != nil {
+			return nil, fmt.Errorf("failed to prepare output tags: %v", err)
+		}
+	}
+	
+	// Outputs keep the rate of the source where the codec allows
+	sampleRate := OutputSampleRate(format, SourceSampleRate(src))
+	
 	var args []string
 	args = append(args, "-i", src)
-	
-	// Apply Dolby Stereo simulation if enabled
-	if as.DolbyStereo {
-		args = append(args, "-af", "stereotools=mlev=1.2")
-		args = append(args, "-ac", "2") // Force stereo output
+	args = append(args, tagInputs...)
+	if limiter != nil {
+		args = append(args, "-af", limiter.Filter(sampleRate))
 	}
 	
-	if as.OutputFormat == "wav" {
-		args = append(args, "-c:a", as.Quality, "-ar", "48000")
+	quality := ""
+	if format == as.OutputFormat {
+		quality = as.Quality
+	}
+	if final || !as.Options.Gapless {
+		args = append(args, OutputCodecArgs(format, quality, sampleRate)...)
 	} else {
-		args = append(args, "-c:a", "libmp3lame", "-b:a", as.Quality, "-ar", "48000")
+		// PCM intermediates keep the sample rate and sample count of the gapless join
+		args = append(args, as.intermediateCodecArgs()...)
 	}
+	args = append(args, tagOutputs...)
 	
 	args = append(args, "-y", dst)
 	
 	cmd := exec.Command("ffmpeg", args...)
 	output, err := cmd.CombinedOutput()
 	if err != nil {
-		return fmt.Errorf("ffmpeg copy error: %v\nOutput: %s", err, output)
+		return nil, fmt.Errorf("ffmpeg copy error: %v\nOutput: %s", err, output)
 	}
-	return nil
+	if limiter == nil {
+		return nil, nil
+	}
+	return limiter.ParseReport(output)
 }
 
 // GetAudioDuration returns the duration of an audio file in seconds
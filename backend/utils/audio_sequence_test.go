@@ -0,0 +1,33 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestIntermediatesStayLosslessForLosslessOutputs(t *testing.T) {
+	tests := []struct {
+		format  string
+		gapless bool
+		pcm     bool
+	}{
+		{"mp3", false, false},
+		{"mp3", true, true},
+		{"wav", false, true},
+		{"flac", false, true},
+	}
+	for _, test := range tests {
+		options := DefaultMixOptions()
+		options.Format = test.format
+		options.Gapless = test.gapless
+		as := NewAudioSequencerWithMixOptions([]string{"a.wav"}, "out", t.TempDir(), options)
+
+		args := strings.Join(as.intermediateCodecArgs(), " ")
+		if pcm := strings.Contains(args, "pcm_"); pcm != test.pcm {
+			t.Errorf("%s (gapless %v): intermediates encoded with %q", test.format, test.gapless, args)
+		}
+		if ext := as.intermediateExt(); (ext == ".wav") != test.pcm {
+			t.Errorf("%s (gapless %v): intermediate extension %s", test.format, test.gapless, ext)
+		}
+	}
+}
@@ -43,10 +43,32 @@ func (bp *BatchProcessor) ProcessLargeAudioSet(inputFiles []string, outputFile s
 
 // ProcessLargeAudioSetWithStereo processes 100+ audio files efficiently with stereo options
 func (bp *BatchProcessor) ProcessLargeAudioSetWithStereo(inputFiles []string, outputFile string, loops int, crossfade float64, enhance bool, dolbyStereo bool, format, sessionID string) error {
+	options := DefaultMixOptions()
+	options.Loops = loops
+	options.Crossfade = crossfade
+	options.Enhance = enhance
+	if dolbyStereo {
+		options.StereoMode = StereoModeWiden
+	}
+	options.Format = format
+	return bp.ProcessLargeAudioSetWithMixOptions(inputFiles, outputFile, options, sessionID)
+}
+
+// ProcessLargeAudioSetWithMixOptions processes 100+ audio files efficiently with the full set of mix options
+func (bp *BatchProcessor) ProcessLargeAudioSetWithMixOptions(inputFiles []string, outputFile string, options MixOptions, sessionID string) error {
 	if len(inputFiles) <= 20 {
 		// Use regular processing for smaller sets
 		manager := NewAudioManager(bp.TempDir)
-		return manager.ProcessAudioSequenceWithProgress(inputFiles, outputFile, loops, crossfade, enhance, format, sessionID)
+		return manager.ProcessAudioSequenceWithMixOptions(inputFiles, outputFile, options, sessionID)
+	}
+
+	// The merge only sees chunk files, so reshuffling would move whole chunks and keep
+	// the order inside them; play every loop in the first loop's order instead
+	if options.ReshuffleLoops {
+		options.ReshuffleLoops = false
+		if options.Report != nil {
+			options.Report.AddWarning("reshuffle_loops is not supported above 20 inputs; every loop uses the same order")
+		}
 	}
 
 	// Update progress
@@ -54,9 +76,46 @@ func (bp *BatchProcessor) ProcessLargeAudioSetWithStereo(inputFiles []string, ou
 		GlobalProgressTracker.UpdateProgress(sessionID, "preparation", "Preparing batch processing...", 5, "", len(inputFiles))
 	}
 
+	// Run per-track stages once on the whole set so chunks only sequence
+	if options.HasTrackStages() {
+		if err := NewAudioValidator().ValidateFiles(inputFiles); err != nil {
+			return fmt.Errorf("validation failed: %v", err)
+		}
+
+		prepareDir := filepath.Join(bp.TempDir, fmt.Sprintf("prepared_%s", sessionID))
+		defer os.RemoveAll(prepareDir)
+
+		prepared, preparedOptions, err := PrepareTracks(prepareDir, inputFiles, options)
+		if err != nil {
+			return fmt.Errorf("track preparation failed: %v", err)
+		}
+		inputFiles, options = prepared, preparedOptions
+	}
+
+	// Chunks are plain sequences; looping and enhancement happen once at merge time
+	chunkOptions := options
+	chunkOptions.Loops = 1
+	chunkOptions.Enhance = false
+	chunkOptions.StereoMode = StereoModePassthrough
+	chunkOptions.Limiter = false
+	chunkOptions.Cleanup = CleanupOptions{}
+	chunkOptions.EQ = nil
+	chunkOptions.Dynamics = DynamicsOptions{}
+	chunkOptions.Metadata = Metadata{}
+	chunkOptions.Cover = nil
+	chunkOptions.Chapters = false
+	chunkOptions.ExportStems = false
+	chunkOptions.Format = "mp3"
+	if options.Gapless || OutputFormatSpecFor(options.Format).Bitrate == "" {
+		// Chunks stay PCM so the merge joins them without MP3 delay and padding, and
+		// lossless outputs don't pass through a lossy encoder
+		chunkOptions.Format = "wav"
+	}
+
 	// Process in chunks to manage memory
 	chunks := bp.chunkFiles(inputFiles)
 	chunkOutputs := make([]string, len(chunks))
+	chunkTimelines := make([][]TrackMarker, len(chunks))
 	
 	// Process chunks with limited concurrency
 	semaphore := make(chan struct{}, bp.MaxConcurrent)
@@ -64,8 +123,12 @@ func (bp *BatchProcessor) ProcessLargeAudioSetWithStereo(inputFiles []string, ou
 	var mu sync.Mutex
 	var processingError error
 
+	chunkStart := 0
 	for i, chunk := range chunks {
 		wg.Add(1)
+		// Names and upload indexes follow the chunk's files so its timeline is right
+		options := chunkOptions.ForInputs(chunkStart, chunkStart+len(chunk))
+		chunkStart += len(chunk)
 		go func(chunkIndex int, files []string) {
 			defer wg.Done()
 			semaphore <- struct{}{} // Acquire semaphore
@@ -89,7 +152,7 @@ func (bp *BatchProcessor) ProcessLargeAudioSetWithStereo(inputFiles []string, ou
 			defer os.RemoveAll(chunkDir)
 
 			// Process chunk
-			chunkOutput := filepath.Join(chunkDir, fmt.Sprintf("chunk_%d.mp3", chunkIndex))
+			chunkOutput := filepath.Join(chunkDir, fmt.Sprintf("chunk_%d%s", chunkIndex, OutputFormatSpecFor(options.Format).Extension))
 			manager := NewAudioManager(chunkDir)
 			
 			// Update progress for this chunk
@@ -100,13 +163,14 @@ func (bp *BatchProcessor) ProcessLargeAudioSetWithStereo(inputFiles []string, ou
 					progress, "", len(files))
 			}
 
-			err := manager.ProcessAudioSequenceWithOptions(files, chunkOutput, 1, crossfade, false, "mp3")
+			err := manager.ProcessAudioSequenceWithMixOptions(files, chunkOutput, options, "")
 			
 			mu.Lock()
 			if err != nil && processingError == nil {
 				processingError = fmt.Errorf("chunk %d processing failed: %v", chunkIndex, err)
-			} else {
+			} else if err == nil {
 				chunkOutputs[chunkIndex] = chunkOutput
+				chunkTimelines[chunkIndex] = manager.Sequencer.Timeline
 			}
 			mu.Unlock()
 		}(i, chunk)
@@ -123,8 +187,9 @@ func (bp *BatchProcessor) ProcessLargeAudioSetWithStereo(inputFiles []string, ou
 		GlobalProgressTracker.UpdateProgress(sessionID, "merging", "Merging processed chunks...", 75, "", len(chunks))
 	}
 
-	// Merge all chunks into final output
-	err := bp.mergeChunksWithStereo(chunkOutputs, outputFile, loops, enhance, dolbyStereo, format, sessionID)
+	// Merge all chunks into final output, placing every chunk's tracks on the final timeline
+	options.InputMarkers = chunkTimelines
+	err := bp.mergeChunksWithMixOptions(chunkOutputs, outputFile, options, sessionID)
 	if err != nil {
 		return fmt.Errorf("failed to merge chunks: %v", err)
 	}
@@ -137,6 +202,42 @@ func (bp *BatchProcessor) ProcessLargeAudioSetWithStereo(inputFiles []string, ou
 	return nil
 }
 
+// ForEach runs fn for indexes 0..n-1 with the processor's concurrency limit and CPU
+// throttling, and returns the first error
+func (bp *BatchProcessor) ForEach(n int, fn func(i int) error) error {
+	semaphore := make(chan struct{}, bp.MaxConcurrent)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var firstError error
+
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(index int) {
+			defer wg.Done()
+			semaphore <- struct{}{} // Acquire semaphore
+			defer func() { <-semaphore }() // Release semaphore
+
+			if index > 0 {
+				bp.CPUMonitor.WaitForCPUCooldown()
+				if delay := bp.CPUMonitor.GetThrottleDelay(); delay > 0 {
+					time.Sleep(delay)
+				}
+			}
+
+			err := fn(index)
+
+			mu.Lock()
+			if err != nil && firstError == nil {
+				firstError = err
+			}
+			mu.Unlock()
+		}(i)
+	}
+
+	wg.Wait()
+	return firstError
+}
+
 // chunkFiles splits input files into manageable chunks
 func (bp *BatchProcessor) chunkFiles(files []string) [][]string {
 	var chunks [][]string
@@ -157,15 +258,32 @@ func (bp *BatchProcessor) mergeChunks(chunkFiles []string, outputFile string, lo
 
 // mergeChunksWithStereo combines processed chunks into final output with stereo options
 func (bp *BatchProcessor) mergeChunksWithStereo(chunkFiles []string, outputFile string, loops int, enhance bool, dolbyStereo bool, format, sessionID string) error {
+	options := DefaultMixOptions()
+	options.Loops = loops
+	options.Enhance = enhance
+	if dolbyStereo {
+		options.StereoMode = StereoModeWiden
+	}
+	options.Format = format
+	return bp.mergeChunksWithMixOptions(chunkFiles, outputFile, options, sessionID)
+}
+
+// mergeChunksWithMixOptions combines processed chunks into final output with the job's mix options
+func (bp *BatchProcessor) mergeChunksWithMixOptions(chunkFiles []string, outputFile string, options MixOptions, sessionID string) error {
 	// Filter out empty chunk files
 	validChunks := make([]string, 0, len(chunkFiles))
-	for _, chunk := range chunkFiles {
+	var validMarkers [][]TrackMarker
+	for i, chunk := range chunkFiles {
 		if chunk != "" {
 			if _, err := os.Stat(chunk); err == nil {
 				validChunks = append(validChunks, chunk)
+				if i < len(options.InputMarkers) {
+					validMarkers = append(validMarkers, options.InputMarkers[i])
+				}
 			}
 		}
 	}
+	options.InputMarkers = validMarkers
 
 	if len(validChunks) == 0 {
 		return fmt.Errorf("no valid chunks to merge")
@@ -176,9 +294,9 @@ func (bp *BatchProcessor) mergeChunksWithStereo(chunkFiles []string, outputFile
 	os.MkdirAll(mergeDir, 0755)
 	defer os.RemoveAll(mergeDir)
 
-	// Use AudioManager to merge chunks with stereo options
+	// Use AudioManager to merge chunks with the job's options
 	manager := NewAudioManager(mergeDir)
-	return manager.ProcessAudioSequenceWithProgressAndStereo(validChunks, outputFile, loops, 2.0, enhance, dolbyStereo, format, sessionID)
+	return manager.ProcessAudioSequenceWithMixOptions(validChunks, outputFile, options.WithoutTrackStages(), sessionID)
 }
 
 // OptimizeForLargeFiles adjusts settings for processing many files
@@ -0,0 +1,30 @@
+package utils
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+)
+
+func TestBatchReportsUnsupportedReshuffle(t *testing.T) {
+	dir := t.TempDir()
+	var files []string
+	for i := 0; i < 21; i++ {
+		files = append(files, filepath.Join(dir, fmt.Sprintf("missing_%d.wav", i)))
+	}
+
+	report := NewJobReport("batch")
+	options := DefaultMixOptions()
+	options.Loops = 3
+	options.ReshuffleLoops = true
+	options.Report = report
+
+	// The missing files fail the job, but only after the options were checked
+	bp := NewBatchProcessor(dir)
+	if err := bp.ProcessLargeAudioSetWithMixOptions(files, filepath.Join(dir, "out.mp3"), options, ""); err == nil {
+		t.Fatal("batch of missing files succeeded")
+	}
+	if len(report.Warnings) != 1 {
+		t.Errorf("warnings = %v, want one about reshuffle_loops", report.Warnings)
+	}
+}
@@ -0,0 +1,158 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+// CrossfadeFallback records a transition that couldn't start on a downbeat because one
+// of its tracks has no beat grid; it uses the crossfade length in seconds instead
+type CrossfadeFallback struct {
+	Position int    `json:"position"` // sequence position of the incoming track
+	Name     string `json:"name"`     // the track without a beat grid
+	Reason   string `json:"reason"`
+}
+
+// concatenateOnBeats chains the inputs with crossfades that start on a downbeat
+// of the outgoing track and last a whole number of beats or bars. Transitions that
+// touch a track without a detectable tempo fall back to a plain crossfade in seconds.
+func (as *AudioSequencer) concatenateOnBeats(outputFile string) error {
+	tempos := make([]*TempoInfo, len(as.InputFiles))
+	misses := make([]error, len(as.InputFiles))
+	for i, file := range as.InputFiles {
+		tempo, err := as.Options.Tempos.Detect(file)
+		if errors.Is(err, ErrNoTempo) {
+			misses[i] = err
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("tempo detection failed for file %d: %v", i+1, err)
+		}
+		tempos[i] = tempo
+	}
+
+	currentFile := as.InputFiles[0]
+	// origin is where downbeat zero of the most recently added track sits in currentFile,
+	// or where the track starts when it has no beat grid
+	origin := firstDownbeat(tempos[0])
+	as.sequence = []TrackMarker{{}}
+
+	for i := 1; i < len(as.InputFiles); i++ {
+		prev, next := tempos[i-1], tempos[i]
+		nextFile := as.InputFiles[i]
+		tempOutput := filepath.Join(as.TempDir, fmt.Sprintf("temp_beats_%d.wav", i))
+
+		currentDuration, err := GetAudioDuration(currentFile)
+		if err != nil {
+			return fmt.Errorf("failed to get duration at step %d: %v", i, err)
+		}
+		nextDuration, err := GetAudioDuration(nextFile)
+		if err != nil {
+			return fmt.Errorf("failed to get duration at step %d: %v", i, err)
+		}
+
+		var nextStart, fade, cutEnd float64
+		if prev != nil && next != nil {
+			// The incoming track starts on its own first downbeat
+			nextStart = next.FirstDownbeat
+			fade = as.beatCrossfadeDuration(prev, next, currentDuration-origin, nextDuration-nextStart)
+
+			// Cut the outgoing track so the fade begins on its last downbeat that leaves room for the whole fade
+			bar := prev.BarDuration()
+			cutEnd = currentDuration
+			if bars := math.Floor((currentDuration - fade - origin) / bar); bars >= 0 {
+				cutEnd = origin + bars*bar + fade
+			}
+		} else {
+			fade = math.Min(as.CrossfadeDuration, math.Min(currentDuration-origin, nextDuration)/2)
+			cutEnd = currentDuration
+			as.recordCrossfadeFallback(i, misses)
+		}
+
+		cmd := exec.Command("ffmpeg",
+			"-i", currentFile,
+			"-i", nextFile,
+			"-filter_complex",
+			fmt.Sprintf("[0]atrim=end=%.6f,asetpts=PTS-STARTPTS[a];[1]atrim=start=%.6f,asetpts=PTS-STARTPTS[b];[a][b]acrossfade=d=%.6f:c1=tri:c2=tri",
+				cutEnd, nextStart, fade),
+			"-c:a", "pcm_s24le",
+			"-y", tempOutput)
+
+		output, err := cmd.CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("ffmpeg beat crossfade error at step %d: %v\nOutput: %s", i, err, output)
+		}
+
+		// Clean up previous temp file if it's not an original input
+		if i > 1 {
+			os.Remove(currentFile)
+		}
+
+		currentFile = tempOutput
+		origin = cutEnd - fade - nextStart + firstDownbeat(next)
+		as.sequence = append(as.sequence, TrackMarker{Start: origin, Fade: fade, SourceStart: nextStart})
+		defer os.Remove(tempOutput)
+	}
+
+	return as.copyFile(currentFile, outputFile)
+}
+
+// recordCrossfadeFallback reports the transition into track i as a crossfade in seconds
+func (as *AudioSequencer) recordCrossfadeFallback(i int, misses []error) {
+	if as.Options.Report == nil {
+		return
+	}
+	missing := i
+	if misses[i] == nil {
+		missing = i - 1
+	}
+	as.Options.Report.AddCrossfadeFallback(CrossfadeFallback{
+		Position: i,
+		Name:     as.Options.InputName(missing, filepath.Base(as.InputFiles[missing])),
+		Reason:   misses[missing].Error(),
+	})
+}
+
+// firstDownbeat returns where downbeat zero of a track sits, or its start without a beat grid
+func firstDownbeat(tempo *TempoInfo) float64 {
+	if tempo == nil {
+		return 0
+	}
+	return tempo.FirstDownbeat
+}
+
+// beatCrossfadeDuration converts the crossfade length in beats or bars to seconds,
+// shortening it by whole beats when either side is too short
+func (as *AudioSequencer) beatCrossfadeDuration(prev, next *TempoInfo, prevAvailable, nextAvailable float64) float64 {
+	beat := (prev.BeatDuration() + next.BeatDuration()) / 2
+
+	beats := math.Max(1, math.Round(as.CrossfadeDuration))
+	if as.Options.CrossfadeUnit == CrossfadeUnitBars {
+		beats *= float64(prev.BeatsPerBar)
+	}
+
+	limit := math.Min(prevAvailable, nextAvailable) / 2
+	for beats > 1 && beats*beat > limit {
+		beats--
+	}
+
+	return beats * beat
+}
+
+// loopCrossfadeDuration returns the loop boundary crossfade in seconds, using the
+// first track's tempo when the crossfade is measured in beats or bars
+func (as *AudioSequencer) loopCrossfadeDuration() float64 {
+	if !as.Options.BeatAlignedCrossfades() || len(as.InputFiles) == 0 {
+		return as.CrossfadeDuration
+	}
+
+	tempo, err := as.Options.Tempos.Detect(as.InputFiles[0])
+	if err != nil {
+		return as.CrossfadeDuration
+	}
+	return as.beatCrossfadeDuration(tempo, tempo, math.Inf(1), math.Inf(1))
+}
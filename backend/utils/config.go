@@ -0,0 +1,126 @@
+package utils
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config holds the server-wide settings read from the environment
+type Config struct {
+	// DefaultMetadata fills the tags a mix request leaves empty
+	DefaultMetadata Metadata
+
+	// CoverMaxDimension is the longest side, in pixels, uploaded cover art is scaled down to
+	CoverMaxDimension int
+
+	// ResultRetention is how long finished outputs stay available for download
+	ResultRetention time.Duration
+
+	// MaxUploadFileSize, MaxUploadSize and MaxUploadFiles limit a single uploaded file,
+	// a whole upload request in bytes and the number of files in one request
+	MaxUploadFileSize int64
+	MaxUploadSize     int64
+	MaxUploadFiles    int
+
+	// WorkDir holds request uploads and other scratch files of this instance
+	WorkDir string
+
+	// Storage selects where results, assets, previews and resumable uploads are kept: "local"
+	// stores them under StorageDir, by default WorkDir/storage, "s3" in the S3 bucket
+	// shared by every instance
+	Storage    string
+	StorageDir string
+	S3         S3Config
+}
+
+// GlobalConfig is the configuration loaded at startup
+var GlobalConfig = LoadConfig()
+
+// LoadConfig reads the configuration from MIXLOOP_* environment variables.
+// MIXLOOP_META_CUSTOM holds extra tags as "key=value;key=value".
+func LoadConfig() *Config {
+	config := &Config{
+		DefaultMetadata: Metadata{
+			Title:   os.Getenv("MIXLOOP_META_TITLE"),
+			Artist:  os.Getenv("MIXLOOP_META_ARTIST"),
+			Album:   os.Getenv("MIXLOOP_META_ALBUM"),
+			Genre:   os.Getenv("MIXLOOP_META_GENRE"),
+			Year:    os.Getenv("MIXLOOP_META_YEAR"),
+			Comment: os.Getenv("MIXLOOP_META_COMMENT"),
+		},
+		CoverMaxDimension: 1400,
+		ResultRetention:   24 * time.Hour,
+		MaxUploadFileSize: 1 << 30,
+		MaxUploadSize:     4 << 30,
+		MaxUploadFiles:    200,
+		WorkDir:           "uploads",
+		Storage:           "local",
+		S3: S3Config{
+			Endpoint:  os.Getenv("MIXLOOP_S3_ENDPOINT"),
+			Region:    os.Getenv("MIXLOOP_S3_REGION"),
+			Bucket:    os.Getenv("MIXLOOP_S3_BUCKET"),
+			AccessKey: os.Getenv("MIXLOOP_S3_ACCESS_KEY"),
+			SecretKey: os.Getenv("MIXLOOP_S3_SECRET_KEY"),
+			PathStyle: os.Getenv("MIXLOOP_S3_PATH_STYLE") != "false",
+		},
+	}
+
+	if dimension, err := strconv.Atoi(os.Getenv("MIXLOOP_COVER_MAX_DIMENSION")); err == nil && dimension >= 64 {
+		config.CoverMaxDimension = dimension
+	}
+
+	if retention, err := time.ParseDuration(os.Getenv("MIXLOOP_RESULT_RETENTION")); err == nil && retention > 0 {
+		config.ResultRetention = retention
+	}
+
+	// Upload sizes are given in megabytes
+	if size, err := strconv.ParseInt(os.Getenv("MIXLOOP_MAX_FILE_MB"), 10, 64); err == nil && size > 0 {
+		config.MaxUploadFileSize = size << 20
+	}
+	if size, err := strconv.ParseInt(os.Getenv("MIXLOOP_MAX_UPLOAD_MB"), 10, 64); err == nil && size > 0 {
+		config.MaxUploadSize = size << 20
+	}
+	if files, err := strconv.Atoi(os.Getenv("MIXLOOP_MAX_FILES")); err == nil && files > 0 {
+		config.MaxUploadFiles = files
+	}
+
+	if dir := os.Getenv("MIXLOOP_WORK_DIR"); dir != "" {
+		config.WorkDir = dir
+	}
+	switch storage := os.Getenv("MIXLOOP_STORAGE"); storage {
+	case "":
+	case "local", "s3":
+		config.Storage = storage
+	default:
+		log.Printf("Ignoring unknown storage %q, using local", storage)
+	}
+	config.StorageDir = os.Getenv("MIXLOOP_STORAGE_DIR")
+	if config.StorageDir == "" {
+		config.StorageDir = filepath.Join(config.WorkDir, "storage")
+	}
+	// The standard AWS variables work too
+	if config.S3.AccessKey == "" {
+		config.S3.AccessKey = os.Getenv("AWS_ACCESS_KEY_ID")
+	}
+	if config.S3.SecretKey == "" {
+		config.S3.SecretKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
+	}
+
+	if custom := os.Getenv("MIXLOOP_META_CUSTOM"); custom != "" {
+		config.DefaultMetadata.Custom = make(map[string]string)
+		for _, pair := range strings.Split(custom, ";") {
+			if key, value, ok := strings.Cut(pair, "="); ok && strings.TrimSpace(key) != "" {
+				config.DefaultMetadata.Custom[strings.TrimSpace(key)] = strings.TrimSpace(value)
+			}
+		}
+	}
+	if err := config.DefaultMetadata.Validate(); err != nil {
+		log.Printf("Ignoring default metadata: %v", err)
+		config.DefaultMetadata = Metadata{}
+	}
+	return config
+}
@@ -0,0 +1,192 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	coverMaxUploadBytes = 20 << 20
+	coverMaxPixels      = 64 << 20 // refuse to decode anything larger, e.g. decompression bombs
+	coverJPEGQuality    = 90
+	coverPictureType    = 3 // front cover in ID3 APIC and FLAC PICTURE
+)
+
+// CoverArt is a validated cover image ready to be embedded into the output
+type CoverArt struct {
+	Path   string `json:"-"`
+	MIME   string `json:"mime"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+	Depth  int    `json:"-"` // bits per pixel, for the FLAC picture block
+}
+
+// PrepareCoverArt decodes a JPEG or PNG image, scales it down so neither side exceeds
+// maxDimension and writes it to dir in its original format
+func PrepareCoverArt(r io.Reader, dir string, maxDimension int) (*CoverArt, error) {
+	data, err := io.ReadAll(io.LimitReader(r, coverMaxUploadBytes+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read image: %v", err)
+	}
+	if len(data) > coverMaxUploadBytes {
+		return nil, fmt.Errorf("image is larger than %d MB", coverMaxUploadBytes>>20)
+	}
+
+	config, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("not a JPEG or PNG image")
+	}
+	if format != "jpeg" && format != "png" {
+		return nil, fmt.Errorf("unsupported image format %s, use JPEG or PNG", format)
+	}
+	if config.Width <= 0 || config.Height <= 0 || config.Width*config.Height > coverMaxPixels {
+		return nil, fmt.Errorf("image dimensions %dx%d are out of range", config.Width, config.Height)
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode image: %v", err)
+	}
+
+	width, height := fitDimensions(config.Width, config.Height, maxDimension)
+	if width != config.Width || height != config.Height {
+		img = resizeImage(img, width, height)
+	}
+
+	cover := &CoverArt{Width: width, Height: height, Depth: 24}
+	var buf bytes.Buffer
+	if format == "png" {
+		cover.MIME = "image/png"
+		cover.Path = filepath.Join(dir, "cover.png")
+		if !isOpaque(img) {
+			cover.Depth = 32
+		}
+		err = png.Encode(&buf, img)
+	} else {
+		cover.MIME = "image/jpeg"
+		cover.Path = filepath.Join(dir, "cover.jpg")
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: coverJPEGQuality})
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode image: %v", err)
+	}
+
+	if err := os.WriteFile(cover.Path, buf.Bytes(), 0644); err != nil {
+		return nil, err
+	}
+	return cover, nil
+}
+
+// fitDimensions scales width and height down to fit a square of maxDimension, keeping the aspect ratio
+func fitDimensions(width, height, maxDimension int) (int, int) {
+	if maxDimension <= 0 || (width <= maxDimension && height <= maxDimension) {
+		return width, height
+	}
+	if width >= height {
+		return maxDimension, max(1, height*maxDimension/width)
+	}
+	return max(1, width*maxDimension/height), maxDimension
+}
+
+// resizeImage downscales with a box filter: every destination pixel averages the source
+// area it covers, weighted by overlap
+func resizeImage(src image.Image, width, height int) *image.RGBA {
+	bounds := src.Bounds()
+	rgba := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(rgba, rgba.Bounds(), src, bounds.Min, draw.Src)
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	scaleX := float64(bounds.Dx()) / float64(width)
+	scaleY := float64(bounds.Dy()) / float64(height)
+
+	for y := 0; y < height; y++ {
+		y0, y1 := float64(y)*scaleY, float64(y+1)*scaleY
+		for x := 0; x < width; x++ {
+			x0, x1 := float64(x)*scaleX, float64(x+1)*scaleX
+
+			var sum [4]float64
+			var total float64
+			for sy := int(y0); sy < int(y1+0.999999) && sy < bounds.Dy(); sy++ {
+				wy := overlap(y0, y1, sy)
+				for sx := int(x0); sx < int(x1+0.999999) && sx < bounds.Dx(); sx++ {
+					weight := wy * overlap(x0, x1, sx)
+					offset := rgba.PixOffset(sx, sy)
+					for c := 0; c < 4; c++ {
+						sum[c] += weight * float64(rgba.Pix[offset+c])
+					}
+					total += weight
+				}
+			}
+
+			offset := dst.PixOffset(x, y)
+			for c := 0; c < 4; c++ {
+				dst.Pix[offset+c] = uint8(sum[c]/total + 0.5)
+			}
+		}
+	}
+	return dst
+}
+
+// overlap returns how much of source pixel i lies inside [a, b)
+func overlap(a, b float64, i int) float64 {
+	lo, hi := float64(i), float64(i+1)
+	if a > lo {
+		lo = a
+	}
+	if b < hi {
+		hi = b
+	}
+	if hi <= lo {
+		return 0
+	}
+	return hi - lo
+}
+
+func isOpaque(img image.Image) bool {
+	if o, ok := img.(interface{ Opaque() bool }); ok {
+		return o.Opaque()
+	}
+	return false
+}
+
+// PictureBlock returns the cover as a FLAC METADATA_BLOCK_PICTURE body
+func (c *CoverArt) PictureBlock() ([]byte, error) {
+	data, err := os.ReadFile(c.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	var block []byte
+	block = binary.BigEndian.AppendUint32(block, coverPictureType)
+	block = binary.BigEndian.AppendUint32(block, uint32(len(c.MIME)))
+	block = append(block, c.MIME...)
+	block = binary.BigEndian.AppendUint32(block, 0) // description
+	block = binary.BigEndian.AppendUint32(block, uint32(c.Width))
+	block = binary.BigEndian.AppendUint32(block, uint32(c.Height))
+	block = binary.BigEndian.AppendUint32(block, uint32(c.Depth))
+	block = binary.BigEndian.AppendUint32(block, 0) // indexed colours
+	block = binary.BigEndian.AppendUint32(block, uint32(len(data)))
+	return append(block, data...), nil
+}
+
+// escapeFFMetadata escapes the characters that are special in ffmetadata values
+func escapeFFMetadata(value string) string {
+	var b strings.Builder
+	for _, r := range value {
+		switch r {
+		case '=', ';', '#', '\\', '\n':
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
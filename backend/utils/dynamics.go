@@ -0,0 +1,234 @@
+package utils
+
+import (
+	"fmt"
+	"os/exec"
+	"sort"
+	"strings"
+)
+
+// CompressorSettings are the parameters of one acompressor
+type CompressorSettings struct {
+	Threshold float64 `json:"threshold"` // dBFS
+	Ratio     float64 `json:"ratio"`
+	Attack    float64 `json:"attack"`  // ms
+	Release   float64 `json:"release"` // ms
+	Makeup    float64 `json:"makeup"`  // dB
+}
+
+// GateSettings are the parameters of the optional noise gate in front of the compressors
+type GateSettings struct {
+	Threshold float64 `json:"threshold"` // dBFS
+	Ratio     float64 `json:"ratio"`
+	Attack    float64 `json:"attack"`  // ms
+	Release   float64 `json:"release"` // ms
+	Range     float64 `json:"range"`   // dB of attenuation when closed
+}
+
+// DynamicsOptions describes the dynamics stage: an optional gate, then one compressor per
+// band. Crossovers split the signal into len(Crossovers)+1 bands; none means broadband.
+type DynamicsOptions struct {
+	Preset     string               `json:"preset,omitempty"`
+	Crossovers []float64            `json:"crossovers,omitempty"` // Hz, ascending
+	Bands      []CompressorSettings `json:"bands,omitempty"`
+	Gate       *GateSettings        `json:"gate,omitempty"`
+}
+
+// DynamicsPresets are the built-in multiband settings
+var DynamicsPresets = map[string]DynamicsOptions{
+	"gentle": {
+		Crossovers: []float64{200, 4000},
+		Bands: []CompressorSettings{
+			{Threshold: -24, Ratio: 2, Attack: 20, Release: 250, Makeup: 1},
+			{Threshold: -22, Ratio: 1.8, Attack: 15, Release: 200, Makeup: 1},
+			{Threshold: -26, Ratio: 2, Attack: 5, Release: 120, Makeup: 1},
+		},
+	},
+	"punchy": {
+		Crossovers: []float64{120, 2500},
+		Bands: []CompressorSettings{
+			{Threshold: -18, Ratio: 4, Attack: 30, Release: 150, Makeup: 3},
+			{Threshold: -20, Ratio: 2.5, Attack: 10, Release: 120, Makeup: 2},
+			{Threshold: -22, Ratio: 3, Attack: 3, Release: 80, Makeup: 2},
+		},
+	},
+	"broadcast": {
+		Crossovers: []float64{150, 800, 5000},
+		Bands: []CompressorSettings{
+			{Threshold: -20, Ratio: 4, Attack: 20, Release: 200, Makeup: 3},
+			{Threshold: -20, Ratio: 3, Attack: 10, Release: 150, Makeup: 3},
+			{Threshold: -22, Ratio: 3, Attack: 5, Release: 100, Makeup: 3},
+			{Threshold: -24, Ratio: 4, Attack: 2, Release: 60, Makeup: 2},
+		},
+	},
+	"mastering": {
+		Crossovers: []float64{100, 600, 6000},
+		Bands: []CompressorSettings{
+			{Threshold: -16, Ratio: 1.5, Attack: 30, Release: 300, Makeup: 0.5},
+			{Threshold: -16, Ratio: 1.3, Attack: 20, Release: 250, Makeup: 0.5},
+			{Threshold: -18, Ratio: 1.3, Attack: 10, Release: 150, Makeup: 0.5},
+			{Threshold: -20, Ratio: 1.5, Attack: 3, Release: 80, Makeup: 0.5},
+		},
+	},
+	"voice": {
+		Crossovers: []float64{250, 3500},
+		Bands: []CompressorSettings{
+			{Threshold: -26, Ratio: 3, Attack: 15, Release: 200, Makeup: 2},
+			{Threshold: -22, Ratio: 3, Attack: 8, Release: 120, Makeup: 3},
+			{Threshold: -26, Ratio: 2.5, Attack: 3, Release: 80, Makeup: 2},
+		},
+		Gate: &GateSettings{Threshold: -50, Ratio: 4, Attack: 5, Release: 250, Range: -24},
+	},
+}
+
+// Enabled reports whether the dynamics stage has anything to do
+func (do DynamicsOptions) Enabled() bool {
+	return len(do.Bands) > 0 || do.Gate != nil
+}
+
+// Validate resolves the preset and checks every setting. Explicit crossovers and bands
+// replace those of the preset together, since the band count follows the crossovers; an
+// explicit gate replaces the preset's gate.
+func (do *DynamicsOptions) Validate() error {
+	if do.Preset != "" {
+		preset, ok := DynamicsPresets[do.Preset]
+		if !ok {
+			return fmt.Errorf("unknown dynamics preset: %s", do.Preset)
+		}
+		switch {
+		case len(do.Crossovers) == 0 && len(do.Bands) == 0:
+			do.Crossovers = append([]float64(nil), preset.Crossovers...)
+			do.Bands = append([]CompressorSettings(nil), preset.Bands...)
+		case len(do.Bands) == 0:
+			return fmt.Errorf("crossovers given with preset %s need their own bands", do.Preset)
+		case len(do.Crossovers) == 0 && len(do.Bands) > 1:
+			return fmt.Errorf("bands given with preset %s need their own crossovers", do.Preset)
+		}
+		if do.Gate == nil && preset.Gate != nil {
+			gate := *preset.Gate
+			do.Gate = &gate
+		}
+	}
+
+	if len(do.Crossovers) > 3 {
+		return fmt.Errorf("at most 3 crossovers (4 bands) are supported")
+	}
+	if (len(do.Bands) > 0 || len(do.Crossovers) > 0) && len(do.Bands) != len(do.Crossovers)+1 {
+		return fmt.Errorf("%d crossovers need %d bands, got %d", len(do.Crossovers), len(do.Crossovers)+1, len(do.Bands))
+	}
+	if !sort.Float64sAreSorted(do.Crossovers) {
+		return fmt.Errorf("crossovers must be in ascending order")
+	}
+	for i, frequency := range do.Crossovers {
+		if frequency < 20 || frequency > 20000 || (i > 0 && frequency == do.Crossovers[i-1]) {
+			return fmt.Errorf("crossover %d: frequency must be between 20 and 20000 Hz and distinct", i+1)
+		}
+	}
+
+	for i, band := range do.Bands {
+		if err := band.validate(); err != nil {
+			return fmt.Errorf("band %d: %v", i+1, err)
+		}
+	}
+	if do.Gate != nil {
+		if err := do.Gate.validate(); err != nil {
+			return fmt.Errorf("gate: %v", err)
+		}
+	}
+	return nil
+}
+
+func (cs CompressorSettings) validate() error {
+	switch {
+	case cs.Threshold < -60 || cs.Threshold > 0:
+		return fmt.Errorf("threshold must be between -60 and 0 dB")
+	case cs.Ratio < 1 || cs.Ratio > 20:
+		return fmt.Errorf("ratio must be between 1 and 20")
+	case cs.Attack < 0.01 || cs.Attack > 2000:
+		return fmt.Errorf("attack must be between 0.01 and 2000 ms")
+	case cs.Release < 0.01 || cs.Release > 9000:
+		return fmt.Errorf("release must be between 0.01 and 9000 ms")
+	case cs.Makeup < 0 || cs.Makeup > 36:
+		return fmt.Errorf("makeup must be between 0 and 36 dB")
+	}
+	return nil
+}
+
+func (gs GateSettings) validate() error {
+	switch {
+	case gs.Threshold < -80 || gs.Threshold > 0:
+		return fmt.Errorf("threshold must be between -80 and 0 dB")
+	case gs.Ratio < 1 || gs.Ratio > 9000:
+		return fmt.Errorf("ratio must be between 1 and 9000")
+	case gs.Attack < 0.01 || gs.Attack > 9000:
+		return fmt.Errorf("attack must be between 0.01 and 9000 ms")
+	case gs.Release < 0.01 || gs.Release > 9000:
+		return fmt.Errorf("release must be between 0.01 and 9000 ms")
+	case gs.Range < -80 || gs.Range > 0:
+		return fmt.Errorf("range must be between -80 and 0 dB")
+	}
+	return nil
+}
+
+// Filter returns the acompressor filter for the settings
+func (cs CompressorSettings) Filter() string {
+	return fmt.Sprintf("acompressor=threshold=%gdB:ratio=%g:attack=%g:release=%g:makeup=%gdB",
+		cs.Threshold, cs.Ratio, cs.Attack, cs.Release, cs.Makeup)
+}
+
+// Filter returns the agate filter for the settings
+func (gs GateSettings) Filter() string {
+	return fmt.Sprintf("agate=threshold=%gdB:ratio=%g:attack=%g:release=%g:range=%gdB",
+		gs.Threshold, gs.Ratio, gs.Attack, gs.Release, gs.Range)
+}
+
+// FilterGraph renders the stage as a single-input, single-output filter graph. Multiband
+// settings split with acrossover (Linkwitz-Riley, sums flat), compress every band and sum
+// them again with amix without normalisation.
+func (do DynamicsOptions) FilterGraph() string {
+	var head []string
+	if do.Gate != nil {
+		head = append(head, do.Gate.Filter())
+	}
+
+	if len(do.Crossovers) == 0 {
+		for _, band := range do.Bands {
+			head = append(head, band.Filter())
+		}
+		return strings.Join(head, ",")
+	}
+
+	splits := make([]string, len(do.Crossovers))
+	for i, frequency := range do.Crossovers {
+		splits[i] = fmt.Sprintf("%g", frequency)
+	}
+
+	var split, mix strings.Builder
+	for i := range do.Bands {
+		fmt.Fprintf(&split, "[band%d]", i)
+		fmt.Fprintf(&mix, "[comp%d]", i)
+	}
+	head = append(head, fmt.Sprintf("acrossover=split=%s:order=4th%s", strings.Join(splits, " "), split.String()))
+
+	parts := []string{strings.Join(head, ",")}
+	for i, band := range do.Bands {
+		parts = append(parts, fmt.Sprintf("[band%d]%s[comp%d]", i, band.Filter(), i))
+	}
+	parts = append(parts, fmt.Sprintf("%samix=inputs=%d:normalize=0", mix.String(), len(do.Bands)))
+	return strings.Join(parts, ";")
+}
+
+// ApplyDynamics renders the dynamics stage over inputFile into a WAV outputFile
+func (ae *AudioEnhancer) ApplyDynamics(inputFile, outputFile string, options DynamicsOptions) error {
+	cmd := exec.Command("ffmpeg",
+		"-i", inputFile,
+		"-af", options.FilterGraph(),
+		"-c:a", "pcm_s24le",
+		"-y", outputFile)
+
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("ffmpeg dynamics error: %v\nOutput: %s", err, output)
+	}
+	return nil
+}
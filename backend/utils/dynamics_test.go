@@ -0,0 +1,165 @@
+package utils
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+var (
+	acrossoverPattern = regexp.MustCompile(`acrossover=split=([^:]+):order=4th((?:\[band\d+\])+)`)
+	labelPattern      = regexp.MustCompile(`\[[a-z]+\d+\]`)
+)
+
+// checkFilterGraph checks the structure of a rendered dynamics graph against its options
+func checkFilterGraph(t *testing.T, options DynamicsOptions) {
+	t.Helper()
+	graph := options.FilterGraph()
+
+	if options.Gate != nil {
+		if !strings.HasPrefix(graph, "agate=") {
+			t.Errorf("gate doesn't run first: %s", graph)
+		}
+	} else if strings.Contains(graph, "agate=") {
+		t.Errorf("graph has a gate that wasn't asked for: %s", graph)
+	}
+	if got := strings.Count(graph, "acompressor="); got != len(options.Bands) {
+		t.Errorf("graph has %d compressors, want %d: %s", got, len(options.Bands), graph)
+	}
+
+	if len(options.Crossovers) == 0 {
+		// Broadband: one linear chain
+		if strings.Contains(graph, "acrossover") || strings.Contains(graph, ";") || strings.Contains(graph, "amix") {
+			t.Errorf("broadband graph isn't a plain chain: %s", graph)
+		}
+		return
+	}
+
+	match := acrossoverPattern.FindStringSubmatch(graph)
+	if match == nil {
+		t.Fatalf("multiband graph has no acrossover: %s", graph)
+	}
+	// acrossover has one output more than it has split frequencies
+	splits := strings.Fields(match[1])
+	outputs := labelPattern.FindAllString(match[2], -1)
+	if len(splits) != len(options.Crossovers) || len(outputs) != len(splits)+1 {
+		t.Errorf("acrossover splits at %v into %d outputs, want %d splits into %d", splits, len(outputs), len(options.Crossovers), len(options.Crossovers)+1)
+	}
+	if len(outputs) != len(options.Bands) {
+		t.Errorf("acrossover has %d outputs for %d bands", len(outputs), len(options.Bands))
+	}
+
+	// Every label is produced once and consumed once
+	for _, label := range labelPattern.FindAllString(graph, -1) {
+		if count := strings.Count(graph, label); count != 2 {
+			t.Errorf("label %s appears %d times, want 2: %s", label, count, graph)
+		}
+	}
+	if !strings.HasSuffix(graph, "amix=inputs="+strconv.Itoa(len(options.Bands))+":normalize=0") {
+		t.Errorf("bands aren't summed without normalisation: %s", graph)
+	}
+}
+
+func TestDynamicsPresets(t *testing.T) {
+	for name, preset := range DynamicsPresets {
+		t.Run(name, func(t *testing.T) {
+			options := DynamicsOptions{Preset: name}
+			if err := options.Validate(); err != nil {
+				t.Fatalf("preset doesn't validate: %v", err)
+			}
+			if len(options.Bands) != len(preset.Bands) || len(options.Crossovers) != len(preset.Crossovers) {
+				t.Errorf("resolved %d crossovers and %d bands, want %d and %d",
+					len(options.Crossovers), len(options.Bands), len(preset.Crossovers), len(preset.Bands))
+			}
+			if (options.Gate != nil) != (preset.Gate != nil) {
+				t.Errorf("resolved gate %v, preset gate %v", options.Gate, preset.Gate)
+			}
+			checkFilterGraph(t, options)
+		})
+	}
+}
+
+func TestDynamicsFilterGraph(t *testing.T) {
+	band := CompressorSettings{Threshold: -20, Ratio: 3, Attack: 10, Release: 100, Makeup: 2}
+	gate := &GateSettings{Threshold: -50, Ratio: 4, Attack: 5, Release: 250, Range: -24}
+	tests := []struct {
+		name    string
+		options DynamicsOptions
+	}{
+		{"broadband", DynamicsOptions{Bands: []CompressorSettings{band}}},
+		{"broadband with gate", DynamicsOptions{Bands: []CompressorSettings{band}, Gate: gate}},
+		{"gate only", DynamicsOptions{Gate: gate}},
+		{"two bands", DynamicsOptions{Crossovers: []float64{1000}, Bands: []CompressorSettings{band, band}}},
+		{"four bands with gate", DynamicsOptions{Crossovers: []float64{100, 1000, 8000}, Bands: []CompressorSettings{band, band, band, band}, Gate: gate}},
+		{"preset with own bands", DynamicsOptions{Preset: "voice", Crossovers: []float64{500}, Bands: []CompressorSettings{band, band}}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			options := test.options
+			if err := options.Validate(); err != nil {
+				t.Fatal(err)
+			}
+			checkFilterGraph(t, options)
+		})
+	}
+}
+
+func TestDynamicsValidate(t *testing.T) {
+	band := CompressorSettings{Threshold: -20, Ratio: 3, Attack: 10, Release: 100, Makeup: 2}
+	tests := []struct {
+		name    string
+		options DynamicsOptions
+		wantErr string
+	}{
+		{"unknown preset", DynamicsOptions{Preset: "loud"}, "unknown dynamics preset"},
+		{"too many crossovers", DynamicsOptions{Crossovers: []float64{100, 200, 300, 400}, Bands: make([]CompressorSettings, 5)}, "at most 3 crossovers"},
+		{"missing band", DynamicsOptions{Crossovers: []float64{100, 1000}, Bands: []CompressorSettings{band, band}}, "2 crossovers need 3 bands"},
+		{"crossovers without bands", DynamicsOptions{Crossovers: []float64{1000}}, "1 crossovers need 2 bands"},
+		{"descending crossovers", DynamicsOptions{Crossovers: []float64{1000, 100}, Bands: []CompressorSettings{band, band, band}}, "ascending"},
+		{"duplicate crossovers", DynamicsOptions{Crossovers: []float64{1000, 1000}, Bands: []CompressorSettings{band, band, band}}, "distinct"},
+		{"inaudible crossover", DynamicsOptions{Crossovers: []float64{10}, Bands: []CompressorSettings{band, band}}, "between 20 and 20000 Hz"},
+		{"ratio below 1", DynamicsOptions{Bands: []CompressorSettings{{Threshold: -20, Ratio: 0.5, Attack: 10, Release: 100}}}, "band 1: ratio"},
+		{"positive threshold", DynamicsOptions{Crossovers: []float64{1000}, Bands: []CompressorSettings{band, {Threshold: 3, Ratio: 2, Attack: 10, Release: 100}}}, "band 2: threshold"},
+		{"gate range", DynamicsOptions{Gate: &GateSettings{Threshold: -50, Ratio: 4, Attack: 5, Release: 250, Range: 6}}, "gate: range"},
+		{"preset with crossovers only", DynamicsOptions{Preset: "gentle", Crossovers: []float64{300, 3000}}, "need their own bands"},
+		{"preset with bands only", DynamicsOptions{Preset: "gentle", Bands: []CompressorSettings{band, band, band}}, "need their own crossovers"},
+		{"preset with crossovers and bands", DynamicsOptions{Preset: "gentle", Crossovers: []float64{1000}, Bands: []CompressorSettings{band, band}}, ""},
+		{"preset gate with broadband band", DynamicsOptions{Preset: "voice", Bands: []CompressorSettings{band}}, ""},
+		{"valid broadband", DynamicsOptions{Bands: []CompressorSettings{band}}, ""},
+		{"empty", DynamicsOptions{}, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.options.Validate()
+			switch {
+			case test.wantErr == "" && err != nil:
+				t.Errorf("unexpected error: %v", err)
+			case test.wantErr != "" && (err == nil || !strings.Contains(err.Error(), test.wantErr)):
+				t.Errorf("error = %v, want one containing %q", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestDynamicsExplicitCrossoversReplacePreset(t *testing.T) {
+	band := CompressorSettings{Threshold: -20, Ratio: 3, Attack: 10, Release: 100, Makeup: 2}
+	options := DynamicsOptions{Preset: "gentle", Crossovers: []float64{1000}, Bands: []CompressorSettings{band, band}}
+	if err := options.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if len(options.Crossovers) != 1 || options.Crossovers[0] != 1000 || len(options.Bands) != 2 {
+		t.Errorf("resolved %v with %d bands, want the explicit crossover and bands", options.Crossovers, len(options.Bands))
+	}
+}
+
+func TestDynamicsPresetGateIsCopied(t *testing.T) {
+	options := DynamicsOptions{Preset: "voice"}
+	if err := options.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	options.Gate.Threshold = -10
+	if DynamicsPresets["voice"].Gate.Threshold == -10 {
+		t.Error("changing a resolved gate changed the preset")
+	}
+}
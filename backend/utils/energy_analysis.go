@@ -0,0 +1,55 @@
+package utils
+
+import (
+	"fmt"
+	"math"
+)
+
+const (
+	energySampleRate = 22050
+	energyFrameSize  = 2048
+)
+
+// EnergyStats summarises how loud and how bright a track is
+type EnergyStats struct {
+	LoudnessDB       float64 `json:"loudness_db"`
+	SpectralCentroid float64 `json:"spectral_centroid"`
+}
+
+// MeasureEnergy computes the RMS loudness (dBFS) and mean spectral centroid (Hz) of a file
+func MeasureEnergy(file string) (*EnergyStats, error) {
+	window := hannWindow(energyFrameSize)
+
+	var sumSquares float64
+	var centroidSum, centroidWeight float64
+	samples := 0
+
+	err := streamFrames(file, energySampleRate, energyFrameSize, func(frame []float32) {
+		for _, s := range frame {
+			sumSquares += float64(s) * float64(s)
+		}
+		samples += len(frame)
+
+		// Summing over all frames weights each frame's centroid by its level, so quiet frames don't skew it
+		magnitudes := magnitudeSpectrum(frame, window)
+		for bin, magnitude := range magnitudes {
+			centroidSum += float64(bin) * energySampleRate / energyFrameSize * magnitude
+			centroidWeight += magnitude
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	if samples == 0 {
+		return nil, fmt.Errorf("audio too short for energy analysis")
+	}
+
+	stats := &EnergyStats{LoudnessDB: -120}
+	if rms := math.Sqrt(sumSquares / float64(samples)); rms > 0 {
+		stats.LoudnessDB = math.Round(20*math.Log10(rms)*100) / 100
+	}
+	if centroidWeight > 0 {
+		stats.SpectralCentroid = math.Round(centroidSum / centroidWeight)
+	}
+	return stats, nil
+}
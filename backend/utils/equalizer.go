@@ -0,0 +1,185 @@
+package utils
+
+import (
+	"fmt"
+	"math"
+	"math/cmplx"
+	"os/exec"
+	"strings"
+)
+
+// EQ band types
+const (
+	EQPeak      = "peak"
+	EQLowShelf  = "lowshelf"
+	EQHighShelf = "highshelf"
+	EQLowPass   = "lowpass"
+	EQHighPass  = "highpass"
+)
+
+const (
+	eqMaxBands        = 16
+	eqMaxGain         = 24.0 // dB
+	eqResponseRate    = 48000
+	eqResponsePoints  = 256
+	eqResponseMinFreq = 20.0
+	eqResponseMaxFreq = 20000.0
+)
+
+// EQBand is one parametric EQ band. Gain is ignored by the pass filters.
+type EQBand struct {
+	Type      string  `json:"type"`
+	Frequency float64 `json:"frequency"`
+	Gain      float64 `json:"gain"`
+	Q         float64 `json:"q"`
+}
+
+// EQPoint is one point of a magnitude response curve
+type EQPoint struct {
+	Frequency float64 `json:"frequency"`
+	Gain      float64 `json:"gain_db"`
+}
+
+// ValidateEQ checks a list of bands and fills in the default Q
+func ValidateEQ(bands []EQBand) error {
+	if len(bands) > eqMaxBands {
+		return fmt.Errorf("at most %d EQ bands are allowed", eqMaxBands)
+	}
+	for i := range bands {
+		band := &bands[i]
+		switch band.Type {
+		case EQPeak, EQLowShelf, EQHighShelf:
+			if band.Gain < -eqMaxGain || band.Gain > eqMaxGain {
+				return fmt.Errorf("band %d: gain must be between -%.0f and %.0f dB", i+1, eqMaxGain, eqMaxGain)
+			}
+		case EQLowPass, EQHighPass:
+			band.Gain = 0
+		default:
+			return fmt.Errorf("band %d: unknown type %q", i+1, band.Type)
+		}
+
+		if band.Frequency < 20 || band.Frequency > 20000 {
+			return fmt.Errorf("band %d: frequency must be between 20 and 20000 Hz", i+1)
+		}
+
+		if band.Q == 0 {
+			band.Q = 1
+			if band.Type != EQPeak {
+				band.Q = 1 / math.Sqrt2
+			}
+		}
+		if band.Q < 0.1 || band.Q > 20 {
+			return fmt.Errorf("band %d: q must be between 0.1 and 20", i+1)
+		}
+	}
+	return nil
+}
+
+// EQFilters renders validated bands to ffmpeg filters
+func EQFilters(bands []EQBand) []string {
+	var filters []string
+	for _, band := range bands {
+		switch band.Type {
+		case EQPeak:
+			filters = append(filters, fmt.Sprintf("equalizer=f=%g:t=q:w=%g:g=%g", band.Frequency, band.Q, band.Gain))
+		case EQLowShelf:
+			filters = append(filters, fmt.Sprintf("bass=f=%g:t=q:w=%g:g=%g", band.Frequency, band.Q, band.Gain))
+		case EQHighShelf:
+			filters = append(filters, fmt.Sprintf("treble=f=%g:t=q:w=%g:g=%g", band.Frequency, band.Q, band.Gain))
+		case EQLowPass:
+			filters = append(filters, fmt.Sprintf("lowpass=f=%g:t=q:w=%g", band.Frequency, band.Q))
+		case EQHighPass:
+			filters = append(filters, fmt.Sprintf("highpass=f=%g:t=q:w=%g", band.Frequency, band.Q))
+		}
+	}
+	return filters
+}
+
+// ApplyEQ renders the EQ bands over inputFile into a WAV outputFile
+func (ae *AudioEnhancer) ApplyEQ(inputFile, outputFile string, bands []EQBand) error {
+	cmd := exec.Command("ffmpeg",
+		"-i", inputFile,
+		"-af", strings.Join(EQFilters(bands), ","),
+		"-c:a", "pcm_s24le",
+		"-y", outputFile)
+
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("ffmpeg eq error: %v\nOutput: %s", err, output)
+	}
+	return nil
+}
+
+// EQResponse returns the combined magnitude response of the bands at log-spaced frequencies
+// from 20 Hz to 20 kHz. It uses the same biquad designs as the ffmpeg filters.
+func EQResponse(bands []EQBand, sampleRate float64, points int) []EQPoint {
+	if sampleRate <= 0 {
+		sampleRate = eqResponseRate
+	}
+	if points < 2 {
+		points = eqResponsePoints
+	}
+
+	coefficients := make([][6]float64, len(bands))
+	for i, band := range bands {
+		coefficients[i] = biquadCoefficients(band, sampleRate)
+	}
+
+	maxFreq := math.Min(eqResponseMaxFreq, sampleRate/2)
+	ratio := math.Log(maxFreq / eqResponseMinFreq)
+	curve := make([]EQPoint, points)
+	for p := range curve {
+		frequency := eqResponseMinFreq * math.Exp(ratio*float64(p)/float64(points-1))
+		z := cmplx.Exp(complex(0, -2*math.Pi*frequency/sampleRate)) // z^-1
+
+		gain := 0.0
+		for _, c := range coefficients {
+			num := complex(c[0], 0) + complex(c[1], 0)*z + complex(c[2], 0)*z*z
+			den := complex(c[3], 0) + complex(c[4], 0)*z + complex(c[5], 0)*z*z
+			gain += 20 * math.Log10(cmplx.Abs(num/den))
+		}
+		curve[p] = EQPoint{
+			Frequency: math.Round(frequency*10) / 10,
+			Gain:      math.Round(gain*100) / 100,
+		}
+	}
+	return curve
+}
+
+// biquadCoefficients returns b0, b1, b2, a0, a1, a2 of the RBJ cookbook filter for a band
+func biquadCoefficients(band EQBand, sampleRate float64) [6]float64 {
+	w0 := 2 * math.Pi * band.Frequency / sampleRate
+	cosW0, sinW0 := math.Cos(w0), math.Sin(w0)
+	alpha := sinW0 / (2 * band.Q)
+	a := math.Pow(10, band.Gain/40)
+
+	switch band.Type {
+	case EQPeak:
+		return [6]float64{1 + alpha*a, -2 * cosW0, 1 - alpha*a, 1 + alpha/a, -2 * cosW0, 1 - alpha/a}
+	case EQLowShelf:
+		sq := 2 * math.Sqrt(a) * alpha
+		return [6]float64{
+			a * ((a + 1) - (a-1)*cosW0 + sq),
+			2 * a * ((a - 1) - (a+1)*cosW0),
+			a * ((a + 1) - (a-1)*cosW0 - sq),
+			(a + 1) + (a-1)*cosW0 + sq,
+			-2 * ((a - 1) + (a+1)*cosW0),
+			(a + 1) + (a-1)*cosW0 - sq,
+		}
+	case EQHighShelf:
+		sq := 2 * math.Sqrt(a) * alpha
+		return [6]float64{
+			a * ((a + 1) + (a-1)*cosW0 + sq),
+			-2 * a * ((a - 1) + (a+1)*cosW0),
+			a * ((a + 1) + (a-1)*cosW0 - sq),
+			(a + 1) - (a-1)*cosW0 + sq,
+			2 * ((a - 1) - (a+1)*cosW0),
+			(a + 1) - (a-1)*cosW0 - sq,
+		}
+	case EQLowPass:
+		return [6]float64{(1 - cosW0) / 2, 1 - cosW0, (1 - cosW0) / 2, 1 + alpha, -2 * cosW0, 1 - alpha}
+	case EQHighPass:
+		return [6]float64{(1 + cosW0) / 2, -(1 + cosW0), (1 + cosW0) / 2, 1 + alpha, -2 * cosW0, 1 - alpha}
+	}
+	return [6]float64{1, 0, 0, 1, 0, 0}
+}
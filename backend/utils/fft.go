@@ -0,0 +1,76 @@
+package utils
+
+import (
+	"math"
+	"math/cmplx"
+)
+
+// fft computes an in-place radix-2 FFT; len(x) must be a power of two
+func fft(x []complex128) {
+	n := len(x)
+
+	// Bit reversal permutation
+	for i, j := 1, 0; i < n; i++ {
+		bit := n >> 1
+		for ; j&bit != 0; bit >>= 1 {
+			j ^= bit
+		}
+		j ^= bit
+		if i < j {
+			x[i], x[j] = x[j], x[i]
+		}
+	}
+
+	for size := 2; size <= n; size <<= 1 {
+		step := cmplx.Exp(complex(0, -2*math.Pi/float64(size)))
+		for start := 0; start < n; start += size {
+			w := complex(1, 0)
+			for k := 0; k < size/2; k++ {
+				even := x[start+k]
+				odd := w * x[start+k+size/2]
+				x[start+k] = even + odd
+				x[start+k+size/2] = even - odd
+				w *= step
+			}
+		}
+	}
+}
+
+// hannWindow returns a Hann window of the given size
+func hannWindow(size int) []float64 {
+	window := make([]float64, size)
+	for i := range window {
+		window[i] = 0.5 - 0.5*math.Cos(2*math.Pi*float64(i)/float64(size-1))
+	}
+	return window
+}
+
+// magnitudeSpectrum returns the magnitudes of the first half of the windowed FFT of frame
+func magnitudeSpectrum(frame []float32, window []float64) []float64 {
+	buf := make([]complex128, len(frame))
+	for i, s := range frame {
+		buf[i] = complex(float64(s)*window[i], 0)
+	}
+	fft(buf)
+
+	magnitudes := make([]float64, len(frame)/2)
+	for i := range magnitudes {
+		magnitudes[i] = cmplx.Abs(buf[i])
+	}
+	return magnitudes
+}
+
+// streamFrames decodes a mono file and calls fn for every non-overlapping frame of the given size
+func streamFrames(file string, sampleRate, size int, fn func(frame []float32)) error {
+	frame := make([]float32, 0, size)
+	return StreamPCM(file, sampleRate, 1, func(samples []float32) error {
+		for _, s := range samples {
+			frame = append(frame, s)
+			if len(frame) == size {
+				fn(frame)
+				frame = frame[:0]
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,166 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+)
+
+const (
+	flacBlockStreamInfo = 0
+	flacBlockPadding    = 1
+	flacBlockCueSheet   = 5
+	flacLeadOutTrack    = 255
+	flacMaxCueTracks    = 254
+	flacRewritePadding  = 4096
+)
+
+type flacBlock struct {
+	Type byte
+	Data []byte
+}
+
+// WriteFLACCueSheet replaces the CUESHEET block of a FLAC file with one track per marker.
+// The block goes into the existing padding when it fits, otherwise the file is rewritten.
+func WriteFLACCueSheet(path string, markers []TrackMarker) error {
+	if len(markers) > flacMaxCueTracks {
+		return fmt.Errorf("a FLAC cuesheet holds at most %d tracks, got %d", flacMaxCueTracks, len(markers))
+	}
+
+	file, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	blocks, audioStart, err := readFLACMetadata(file)
+	if err != nil {
+		return err
+	}
+	if len(blocks) == 0 || blocks[0].Type != flacBlockStreamInfo || len(blocks[0].Data) < 18 {
+		return fmt.Errorf("FLAC file has no STREAMINFO block")
+	}
+	info := blocks[0].Data
+	sampleRate := int64(info[10])<<12 | int64(info[11])<<4 | int64(info[12])>>4
+	totalSamples := int64(info[13]&0x0F)<<32 | int64(binary.BigEndian.Uint32(info[14:18]))
+	if sampleRate == 0 {
+		return fmt.Errorf("FLAC file has no sample rate")
+	}
+
+	var kept []flacBlock
+	for _, block := range blocks {
+		if block.Type != flacBlockPadding && block.Type != flacBlockCueSheet {
+			kept = append(kept, block)
+		}
+	}
+	kept = append(kept, flacBlock{Type: flacBlockCueSheet, Data: flacCueSheet(markers, sampleRate, totalSamples)})
+
+	size := int64(4)
+	for _, block := range kept {
+		size += 4 + int64(len(block.Data))
+	}
+
+	// Rewrite in place when the new blocks plus a padding block fill the old space exactly
+	if spare := audioStart - size; spare == 0 || spare >= 4 {
+		if spare > 0 {
+			kept = append(kept, flacBlock{Type: flacBlockPadding, Data: make([]byte, spare-4)})
+		}
+		_, err := file.WriteAt(encodeFLACMetadata(kept), 0)
+		return err
+	}
+
+	kept = append(kept, flacBlock{Type: flacBlockPadding, Data: make([]byte, flacRewritePadding)})
+	tempPath := path + ".tmp"
+	temp, err := os.Create(tempPath)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tempPath)
+
+	if _, err := temp.Write(encodeFLACMetadata(kept)); err != nil {
+		temp.Close()
+		return err
+	}
+	if _, err := io.Copy(temp, io.NewSectionReader(file, audioStart, math.MaxInt64-audioStart)); err != nil {
+		temp.Close()
+		return err
+	}
+	if err := temp.Close(); err != nil {
+		return err
+	}
+	file.Close()
+	return os.Rename(tempPath, path)
+}
+
+// readFLACMetadata returns the metadata blocks and the offset of the first audio frame
+func readFLACMetadata(r io.ReadSeeker) ([]flacBlock, int64, error) {
+	marker := make([]byte, 4)
+	if _, err := io.ReadFull(r, marker); err != nil || string(marker) != "fLaC" {
+		return nil, 0, fmt.Errorf("not a FLAC file")
+	}
+
+	offset := int64(4)
+	var blocks []flacBlock
+	header := make([]byte, 4)
+	for {
+		if _, err := io.ReadFull(r, header); err != nil {
+			return nil, 0, fmt.Errorf("truncated FLAC metadata: %v", err)
+		}
+		length := int(header[1])<<16 | int(header[2])<<8 | int(header[3])
+		data := make([]byte, length)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, 0, fmt.Errorf("truncated FLAC metadata: %v", err)
+		}
+		blocks = append(blocks, flacBlock{Type: header[0] & 0x7F, Data: data})
+		offset += 4 + int64(length)
+
+		if header[0]&0x80 != 0 {
+			return blocks, offset, nil
+		}
+	}
+}
+
+// encodeFLACMetadata renders the stream marker and blocks, flagging the last block
+func encodeFLACMetadata(blocks []flacBlock) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("fLaC")
+	for i, block := range blocks {
+		blockType := block.Type
+		if i == len(blocks)-1 {
+			blockType |= 0x80
+		}
+		length := len(block.Data)
+		buf.Write([]byte{blockType, byte(length >> 16), byte(length >> 8), byte(length)})
+		buf.Write(block.Data)
+	}
+	return buf.Bytes()
+}
+
+// flacCueSheet builds a non-CD CUESHEET block body with one index per track and a lead-out
+func flacCueSheet(markers []TrackMarker, sampleRate, totalSamples int64) []byte {
+	var buf bytes.Buffer
+	buf.Write(make([]byte, 128))                    // media catalog number
+	binary.Write(&buf, binary.BigEndian, uint64(0)) // lead-in samples
+	buf.Write(make([]byte, 1+258))                  // not a CD, reserved
+	buf.WriteByte(byte(len(markers) + 1))
+
+	track := func(offset int64, number byte, indexes int) {
+		binary.Write(&buf, binary.BigEndian, uint64(offset))
+		buf.WriteByte(number)
+		buf.Write(make([]byte, 12))   // ISRC
+		buf.Write(make([]byte, 1+13)) // audio, no pre-emphasis, reserved
+		buf.WriteByte(byte(indexes))
+	}
+
+	for i, marker := range markers {
+		track(int64(math.Round(marker.Start*float64(sampleRate))), byte(i+1), 1)
+		binary.Write(&buf, binary.BigEndian, uint64(0)) // index 1 at the track start
+		buf.WriteByte(1)
+		buf.Write(make([]byte, 3))
+	}
+	track(totalSamples, flacLeadOutTrack, 0)
+	return buf.Bytes()
+}
@@ -0,0 +1,153 @@
+package utils
+
+import (
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// internalSampleFormat is the sample format every converted input is stored in (24-bit WAV)
+const internalSampleFormat = "s32"
+
+// FormatConversion records one input that was converted to the internal format
+type FormatConversion struct {
+	Index int         `json:"index"`
+	Name  string      `json:"name"`
+	From  AudioFormat `json:"from"`
+}
+
+// FormatSkip records one input that was left as it is, and why
+type FormatSkip struct {
+	Index  int    `json:"index"`
+	Name   string `json:"name"`
+	Reason string `json:"reason"`
+}
+
+// NormalizationReport lists the internal format of a job, the inputs converted to it and
+// the inputs that were skipped
+type NormalizationReport struct {
+	Target    AudioFormat        `json:"target"`
+	Converted []FormatConversion `json:"converted"`
+	Skipped   []FormatSkip       `json:"skipped"`
+}
+
+// FormatNormalizer converts every input to a common internal format (24-bit WAV at one
+// sample rate and channel layout) so crossfades and concatenation never resample or
+// convert implicitly
+type FormatNormalizer struct {
+	TempDir string
+	Batch   *BatchProcessor
+	Formats FormatCache // formats known before probing
+}
+
+// NewFormatNormalizer creates a normalizer that converts files with the batch processor's concurrency limits
+func NewFormatNormalizer(tempDir string) *FormatNormalizer {
+	return &FormatNormalizer{
+		TempDir: tempDir,
+		Batch:   NewBatchProcessor(tempDir),
+	}
+}
+
+// Normalize probes every input and converts it to the common format; only files already
+// in exactly that format are skipped. files is updated in place; names label the files
+// in the report.
+func (fn *FormatNormalizer) Normalize(files []string, names []string) (*NormalizationReport, error) {
+	formats := make([]*AudioFormat, len(files))
+	err := fn.Batch.ForEach(len(files), func(i int) error {
+		format, err := fn.Formats.Probe(files[i])
+		if err != nil {
+			return fmt.Errorf("file %d: %v", i+1, err)
+		}
+		formats[i] = format
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	target := CommonAudioFormat(formats)
+	target.Codec = "pcm_s24le"
+	target.SampleFormat = internalSampleFormat
+	target.ChannelLayout = "stereo"
+	if target.Channels == 1 {
+		target.ChannelLayout = "mono"
+	}
+
+	converted := make([]bool, len(files))
+	err = fn.Batch.ForEach(len(files), func(i int) error {
+		if fn.skipReason(files[i], formats[i], target) != "" {
+			return nil
+		}
+		outputFile := filepath.Join(fn.TempDir, fmt.Sprintf("normalized_%d.wav", i))
+		if err := ConvertAudioFormat(files[i], outputFile, target); err != nil {
+			return fmt.Errorf("file %d: %v", i+1, err)
+		}
+		files[i] = outputFile
+		converted[i] = true
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	report := &NormalizationReport{
+		Target:    target,
+		Converted: []FormatConversion{},
+		Skipped:   []FormatSkip{},
+	}
+	for i, wasConverted := range converted {
+		name := fmt.Sprintf("file %d", i+1)
+		if i < len(names) && names[i] != "" {
+			name = names[i]
+		}
+		if !wasConverted {
+			report.Skipped = append(report.Skipped, FormatSkip{
+				Index:  i,
+				Name:   name,
+				Reason: fn.skipReason(files[i], formats[i], target),
+			})
+			continue
+		}
+		report.Converted = append(report.Converted, FormatConversion{
+			Index: i,
+			Name:  name,
+			From:  *formats[i],
+		})
+	}
+	return report, nil
+}
+
+// skipReason explains why a file can be used as it is, or returns "" when it must be
+// converted. Only WAV files in exactly the internal format are skipped.
+func (fn *FormatNormalizer) skipReason(file string, format *AudioFormat, target AudioFormat) string {
+	if strings.ToLower(filepath.Ext(file)) != ".wav" || format.Codec != target.Codec || format.SampleFormat != target.SampleFormat {
+		return ""
+	}
+	if format.SampleRate != target.SampleRate || format.Channels != target.Channels {
+		return ""
+	}
+	// Layouts like "downmix" or "2 channels" are stereo in name only
+	if format.ChannelLayout != "" && format.ChannelLayout != target.ChannelLayout {
+		return ""
+	}
+	return "already in the internal format"
+}
+
+// ConvertAudioFormat converts a file to 24-bit WAV with the target sample rate and channel layout
+func ConvertAudioFormat(inputFile, outputFile string, target AudioFormat) error {
+	cmd := exec.Command("ffmpeg",
+		"-i", inputFile,
+		"-af", fmt.Sprintf("aformat=sample_fmts=%s:sample_rates=%d:channel_layouts=%s",
+			internalSampleFormat, target.SampleRate, target.ChannelLayout),
+		"-ar", strconv.Itoa(target.SampleRate),
+		"-c:a", "pcm_s24le",
+		"-y", outputFile)
+
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("ffmpeg format conversion error: %v\nOutput: %s", err, output)
+	}
+	return nil
+}
@@ -0,0 +1,33 @@
+package utils
+
+import "testing"
+
+func TestFormatNormalizerSkipsOnlyInternalFormat(t *testing.T) {
+	target := AudioFormat{Codec: "pcm_s24le", SampleRate: 48000, Channels: 2, ChannelLayout: "stereo", SampleFormat: internalSampleFormat}
+	internal := target
+	tests := []struct {
+		name    string
+		file    string
+		format  AudioFormat
+		convert bool
+	}{
+		{"internal format", "input_0.wav", internal, false},
+		{"no layout", "input_0.wav", AudioFormat{Codec: "pcm_s24le", SampleRate: 48000, Channels: 2, SampleFormat: "s32"}, false},
+		{"16-bit", "input_0.wav", AudioFormat{Codec: "pcm_s16le", SampleRate: 48000, Channels: 2, ChannelLayout: "stereo", SampleFormat: "s16"}, true},
+		{"float", "input_0.wav", AudioFormat{Codec: "pcm_f32le", SampleRate: 48000, Channels: 2, ChannelLayout: "stereo", SampleFormat: "flt"}, true},
+		{"other rate", "input_0.wav", AudioFormat{Codec: "pcm_s24le", SampleRate: 44100, Channels: 2, ChannelLayout: "stereo", SampleFormat: "s32"}, true},
+		{"mono", "input_0.wav", AudioFormat{Codec: "pcm_s24le", SampleRate: 48000, Channels: 1, ChannelLayout: "mono", SampleFormat: "s32"}, true},
+		{"downmix layout", "input_0.wav", AudioFormat{Codec: "pcm_s24le", SampleRate: 48000, Channels: 2, ChannelLayout: "downmix", SampleFormat: "s32"}, true},
+		{"mp3 at the same rate", "input_0.mp3", AudioFormat{Codec: "mp3", SampleRate: 48000, Channels: 2, ChannelLayout: "stereo", SampleFormat: "fltp"}, true},
+		{"24-bit in another container", "input_0.w64", internal, true},
+	}
+	normalizer := NewFormatNormalizer(t.TempDir())
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			reason := normalizer.skipReason(test.file, &test.format, target)
+			if converts := reason == ""; converts != test.convert {
+				t.Errorf("converts = %v (%q), want %v", converts, reason, test.convert)
+			}
+		})
+	}
+}
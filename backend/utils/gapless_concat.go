@@ -0,0 +1,190 @@
+package utils
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+)
+
+// GaplessInput describes how one input was joined by the gapless concatenator
+type GaplessInput struct {
+	Index        int    `json:"index"`
+	Name         string `json:"name"`
+	SourceFormat string `json:"source_format"`
+	Samples      int64  `json:"samples"`
+	TrimmedStart int64  `json:"trimmed_start,omitempty"`
+	TrimmedEnd   int64  `json:"trimmed_end,omitempty"`
+	Converted    bool   `json:"converted,omitempty"`
+}
+
+// GaplessResult summarises a gapless concatenation
+type GaplessResult struct {
+	SampleRate   int            `json:"sample_rate"`
+	Channels     int            `json:"channels"`
+	TotalSamples int64          `json:"total_samples"`
+	Inputs       []GaplessInput `json:"inputs"`
+}
+
+// GaplessConcatenator joins files sample-accurately by decoding them to a common PCM
+// format and removing MP3 encoder delay and padding
+type GaplessConcatenator struct {
+	TempDir string
+	Names   []string
+	Formats FormatCache // formats known before probing
+}
+
+// NewGaplessConcatenator creates a new gapless concatenator
+func NewGaplessConcatenator(tempDir string, names []string) *GaplessConcatenator {
+	return &GaplessConcatenator{
+		TempDir: tempDir,
+		Names:   names,
+	}
+}
+
+// Concatenate writes the inputs back to back into outputFile as float WAV
+func (gc *GaplessConcatenator) Concatenate(inputFiles []string, outputFile string) (*GaplessResult, error) {
+	formats := make([]*AudioFormat, len(inputFiles))
+	for i, file := range inputFiles {
+		format, err := gc.Formats.Probe(file)
+		if err != nil {
+			return nil, fmt.Errorf("file %d: %v", i+1, err)
+		}
+		formats[i] = format
+	}
+	target := CommonAudioFormat(formats)
+	frameSize := int64(4 * target.Channels)
+
+	rawFile := filepath.Join(gc.TempDir, "gapless.raw")
+	raw, err := os.Create(rawFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create gapless buffer: %v", err)
+	}
+	defer os.Remove(rawFile)
+	defer raw.Close()
+
+	result := &GaplessResult{
+		SampleRate: target.SampleRate,
+		Channels:   target.Channels,
+	}
+
+	for i, file := range inputFiles {
+		input, err := gc.appendInput(raw, file, formats[i], target, frameSize)
+		if err != nil {
+			return nil, fmt.Errorf("file %d: %v", i+1, err)
+		}
+		input.Index = i
+		input.Name = filepath.Base(file)
+		if i < len(gc.Names) && gc.Names[i] != "" {
+			input.Name = gc.Names[i]
+		}
+		result.Inputs = append(result.Inputs, *input)
+		result.TotalSamples += input.Samples
+	}
+
+	// The joined stream must hold exactly the sum of its parts
+	stat, err := raw.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat gapless buffer: %v", err)
+	}
+	if written := stat.Size() / frameSize; written != result.TotalSamples {
+		return nil, fmt.Errorf("gapless concat wrote %d samples, expected %d", written, result.TotalSamples)
+	}
+	raw.Close()
+
+	cmd := exec.Command("ffmpeg",
+		"-f", "f32le",
+		"-ar", strconv.Itoa(target.SampleRate),
+		"-ac", strconv.Itoa(target.Channels),
+		"-i", rawFile,
+		"-c:a", "pcm_f32le",
+		"-rf64", "auto",
+		"-y", outputFile)
+
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("ffmpeg gapless write error: %v\nOutput: %s", err, output)
+	}
+
+	return result, nil
+}
+
+// appendInput decodes one file in the target format and appends its real samples to dst
+func (gc *GaplessConcatenator) appendInput(dst io.Writer, file string, format *AudioFormat, target AudioFormat, frameSize int64) (*GaplessInput, error) {
+	partFile := filepath.Join(gc.TempDir, "gapless_part.raw")
+	defer os.Remove(partFile)
+
+	cmd := exec.Command("ffmpeg",
+		"-i", file,
+		"-f", "f32le",
+		"-acodec", "pcm_f32le",
+		"-ac", strconv.Itoa(target.Channels),
+		"-ar", strconv.Itoa(target.SampleRate),
+		"-y", partFile)
+
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("ffmpeg decode error: %v\nOutput: %s", err, output)
+	}
+
+	part, err := os.Open(partFile)
+	if err != nil {
+		return nil, err
+	}
+	defer part.Close()
+
+	stat, err := part.Stat()
+	if err != nil {
+		return nil, err
+	}
+	decoded := stat.Size() / frameSize
+
+	input := &GaplessInput{
+		SourceFormat: format.String(),
+		Converted:    format.SampleRate != target.SampleRate || format.Channels != target.Channels,
+	}
+
+	// Decoders that ignore the LAME header leave encoder delay at the start and padding at
+	// the end; trim whatever exceeds the real sample count the header promises
+	var lead, tail int64
+	if format.Codec == "mp3" {
+		if info, err := ReadMP3GaplessInfo(file); err == nil {
+			lead, tail = mp3Trim(decoded, info, format.SampleRate, target.SampleRate)
+		}
+	}
+
+	input.TrimmedStart = lead
+	input.TrimmedEnd = tail
+	input.Samples = decoded - lead - tail
+
+	if _, err := part.Seek(lead*frameSize, io.SeekStart); err != nil {
+		return nil, err
+	}
+	if _, err := io.CopyN(dst, part, input.Samples*frameSize); err != nil {
+		return nil, fmt.Errorf("failed to append samples: %v", err)
+	}
+
+	return input, nil
+}
+
+// mp3Trim returns the samples to drop from the start and end of an MP3 decoded at targetRate.
+// The header counts samples at the source rate, so they are scaled to what the resampler produced.
+func mp3Trim(decoded int64, info *MP3GaplessInfo, sourceRate, targetRate int) (lead, tail int64) {
+	scale := 1.0
+	if sourceRate > 0 && targetRate > 0 {
+		scale = float64(targetRate) / float64(sourceRate)
+	}
+
+	excess := decoded - int64(math.Round(float64(info.Samples())*scale))
+	if excess <= 0 {
+		return 0, 0
+	}
+	lead = int64(math.Round(float64(info.EncoderDelay+mp3DecoderDelay) * scale))
+	if lead > excess {
+		lead = excess
+	}
+	return lead, excess - lead
+}
@@ -0,0 +1,164 @@
+package utils
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+// requireFFmpeg skips tests that render audio when ffmpeg isn't installed
+func requireFFmpeg(t *testing.T) {
+	t.Helper()
+	for _, tool := range []string{"ffmpeg", "ffprobe"} {
+		if _, err := exec.LookPath(tool); err != nil {
+			t.Skipf("%s not installed", tool)
+		}
+	}
+}
+
+// writeSine renders a tone of exactly samples frames
+func writeSine(t *testing.T, file string, samples, sampleRate, channels int, codec string) {
+	t.Helper()
+	cmd := exec.Command("ffmpeg",
+		"-f", "lavfi",
+		"-i", fmt.Sprintf("sine=frequency=440:sample_rate=%d", sampleRate),
+		"-af", fmt.Sprintf("atrim=end_sample=%d", samples),
+		"-ac", strconv.Itoa(channels),
+		"-c:a", codec,
+		"-y", file)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("ffmpeg: %v\n%s", err, output)
+	}
+}
+
+// decodedSamples decodes a file to float PCM and counts its frames
+func decodedSamples(t *testing.T, file string, channels int) int64 {
+	t.Helper()
+	raw := file + ".raw"
+	defer os.Remove(raw)
+	cmd := exec.Command("ffmpeg", "-i", file, "-f", "f32le", "-c:a", "pcm_f32le", "-y", raw)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("ffmpeg decode: %v\n%s", err, output)
+	}
+	info, err := os.Stat(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return info.Size() / int64(4*channels)
+}
+
+func TestGaplessSequenceKeepsSampleCount(t *testing.T) {
+	requireFFmpeg(t)
+	dir := t.TempDir()
+
+	lengths := []int{44100, 12345, 30001}
+	var inputs []string
+	var total int64
+	for i, samples := range lengths {
+		file := filepath.Join(dir, fmt.Sprintf("input_%d.wav", i))
+		writeSine(t, file, samples, 44100, 2, "pcm_s16le")
+		inputs = append(inputs, file)
+		total += int64(samples)
+	}
+
+	options := DefaultMixOptions()
+	options.Gapless = true
+	sequencer := NewAudioSequencerWithMixOptions(inputs, filepath.Join(dir, "output.wav"), dir, options)
+	sequenceFile := filepath.Join(dir, "sequence"+sequencer.intermediateExt())
+	if err := sequencer.createSequenceWithCrossfades(sequenceFile); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := decodedSamples(t, sequenceFile, 2); got != total {
+		t.Errorf("sequence has %d samples, want %d", got, total)
+	}
+	format, err := ProbeAudioFormat(sequenceFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if format.SampleRate != 44100 {
+		t.Errorf("sequence sample rate %d, want 44100", format.SampleRate)
+	}
+}
+
+func TestGaplessConcatenateMP3(t *testing.T) {
+	requireFFmpeg(t)
+	dir := t.TempDir()
+
+	// MP3 inputs carry encoder delay and padding that must not reach the join
+	lengths := []int{48000, 20000}
+	var inputs []string
+	var total int64
+	for i, samples := range lengths {
+		file := filepath.Join(dir, fmt.Sprintf("input_%d.mp3", i))
+		writeSine(t, file, samples, 48000, 2, "libmp3lame")
+		inputs = append(inputs, file)
+		total += int64(samples)
+	}
+
+	output := filepath.Join(dir, "gapless.wav")
+	result, err := NewGaplessConcatenator(dir, nil).Concatenate(inputs, output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.TotalSamples != total {
+		t.Errorf("reported %d samples, want %d", result.TotalSamples, total)
+	}
+	if got := decodedSamples(t, output, result.Channels); got != total {
+		t.Errorf("output has %d samples, want %d", got, total)
+	}
+}
+
+func TestMP3TrimScalesToTargetRate(t *testing.T) {
+	// 100 frames of 1152 samples at 44.1 kHz with LAME's usual 576 delay
+	info := &MP3GaplessInfo{SampleRate: 44100, Frames: 100, SamplesPerFrame: 1152, EncoderDelay: 576, Padding: 1000}
+	tests := []struct {
+		name       string
+		decoded    int64
+		targetRate int
+		lead, tail int64
+	}{
+		// Decoded at the source rate: every frame sample, delay and padding included
+		{"same rate", 115200, 44100, 1105, 1000 - 529},
+		// Resampled to 48 kHz: the same frames come out 48000/44100 times longer
+		{"resampled", 125388, 48000, 1203, 125388 - 1203 - 123672},
+		// A decoder that already honoured the header leaves nothing to trim
+		{"already trimmed", 123672, 48000, 0, 0},
+	}
+	for _, test := range tests {
+		lead, tail := mp3Trim(test.decoded, info, info.SampleRate, test.targetRate)
+		if lead != test.lead || tail != test.tail {
+			t.Errorf("%s: trimmed %d + %d, want %d + %d", test.name, lead, tail, test.lead, test.tail)
+		}
+	}
+}
+
+func TestGaplessConcatenateMixedRates(t *testing.T) {
+	requireFFmpeg(t)
+	dir := t.TempDir()
+
+	// A 44.1 kHz MP3 in a 48 kHz job is resampled; its delay and padding must still go
+	mp3 := filepath.Join(dir, "input_0.mp3")
+	writeSine(t, mp3, 44100, 44100, 2, "libmp3lame")
+	wav := filepath.Join(dir, "input_1.wav")
+	writeSine(t, wav, 48000, 48000, 2, "pcm_s16le")
+
+	output := filepath.Join(dir, "gapless.wav")
+	result, err := NewGaplessConcatenator(dir, nil).Concatenate([]string{mp3, wav}, output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.SampleRate != 48000 {
+		t.Fatalf("joined at %d Hz, want 48000", result.SampleRate)
+	}
+	if result.Inputs[0].TrimmedStart == 0 {
+		t.Error("resampled MP3 kept its encoder delay")
+	}
+	// One second of each, give or take the resampler's rounding
+	if want := int64(96000); result.TotalSamples < want-2 || result.TotalSamples > want+2 {
+		t.Errorf("joined %d samples, want about %d", result.TotalSamples, want)
+	}
+}
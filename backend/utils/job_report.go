@@ -0,0 +1,354 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// PreviewRef points at a cached preview image for one audio file
+type PreviewRef struct {
+	Index       int    `json:"index"`
+	Name        string `json:"name"`
+	Spectrogram string `json:"spectrogram"`
+}
+
+// MixPreviews holds the spectrograms rendered for a mix job
+type MixPreviews struct {
+	Inputs []PreviewRef `json:"inputs"`
+	Mix    *PreviewRef  `json:"mix,omitempty"`
+}
+
+// TrackTempo is the detected tempo of one input
+type TrackTempo struct {
+	Index int    `json:"index"`
+	Name  string `json:"name"`
+	TempoInfo
+}
+
+// TrackKey is the detected musical key of one input
+type TrackKey struct {
+	Index int    `json:"index"`
+	Name  string `json:"name"`
+	KeyInfo
+}
+
+// UnkeyedTrack is an input without a detectable key
+type UnkeyedTrack struct {
+	Index  int    `json:"index"`
+	Name   string `json:"name"`
+	Reason string `json:"reason"`
+}
+
+// TrackEnergy is the loudness and brightness of one input
+type TrackEnergy struct {
+	Index int    `json:"index"`
+	Name  string `json:"name"`
+	EnergyStats
+}
+
+// JobReport collects the results of a mix job that don't fit in the audio response
+type JobReport struct {
+	mu            sync.Mutex
+	SessionID     string               `json:"session_id"`
+	CreatedAt     int64                `json:"created_at"`
+	Output        *ResultFile          `json:"output,omitempty"`
+	Previews      *MixPreviews         `json:"previews,omitempty"`
+	Normalization *NormalizationReport `json:"normalization,omitempty"`
+	Cleanup       []CleanupReport      `json:"cleanup,omitempty"`
+	Trimmed       []TrimResult         `json:"trimmed,omitempty"`
+	Tempos        []TrackTempo         `json:"tempos,omitempty"`
+	Stretched     []StretchResult      `json:"stretched,omitempty"`
+	Fallbacks     []CrossfadeFallback  `json:"crossfade_fallbacks,omitempty"`
+	Keys          []TrackKey           `json:"keys,omitempty"`
+	Unkeyed       []UnkeyedTrack       `json:"unkeyed,omitempty"`
+	Energy        []TrackEnergy        `json:"energy,omitempty"`
+	Order         []int                `json:"order,omitempty"`
+	OrderSeed     *int64               `json:"order_seed,omitempty"`
+	LoopPoints    *LoopPoints          `json:"loop_points,omitempty"`
+	Gapless       []GaplessResult      `json:"gapless,omitempty"`
+	Dynamics      *DynamicsOptions     `json:"dynamics,omitempty"`
+	Stereo        *StereoReport        `json:"stereo,omitempty"`
+	Limiter       *LimiterReport       `json:"limiter,omitempty"`
+	Cover         *CoverArt            `json:"cover,omitempty"`
+	Tracklist     []TrackMarker        `json:"tracklist,omitempty"`
+	CueSheet      string               `json:"cue_sheet,omitempty"`
+	Timestamps    string               `json:"timestamps,omitempty"`
+	Stream        *StreamReport        `json:"stream,omitempty"`
+	Warnings      []string             `json:"warnings,omitempty"`
+}
+
+// NewJobReport creates an empty report for a session
+func NewJobReport(sessionID string) *JobReport {
+	return &JobReport{
+		SessionID: sessionID,
+		CreatedAt: time.Now().UnixMilli(),
+	}
+}
+
+// SetOutput records the finished output in the result store
+func (jr *JobReport) SetOutput(output ResultFile) {
+	jr.mu.Lock()
+	defer jr.mu.Unlock()
+	jr.Output = &output
+}
+
+// SetPreviews records the rendered previews
+func (jr *JobReport) SetPreviews(previews *MixPreviews) {
+	jr.mu.Lock()
+	defer jr.mu.Unlock()
+	jr.Previews = previews
+}
+
+// AddTrim records the silence removed from one input
+func (jr *JobReport) AddTrim(result TrimResult) {
+	jr.mu.Lock()
+	defer jr.mu.Unlock()
+	jr.Trimmed = append(jr.Trimmed, result)
+}
+
+// AddTempo records the detected tempo of one input
+func (jr *JobReport) AddTempo(tempo TrackTempo) {
+	jr.mu.Lock()
+	defer jr.mu.Unlock()
+	jr.Tempos = append(jr.Tempos, tempo)
+}
+
+// AddStretch records the time stretch applied to one input
+func (jr *JobReport) AddStretch(result StretchResult) {
+	jr.mu.Lock()
+	defer jr.mu.Unlock()
+	jr.Stretched = append(jr.Stretched, result)
+}
+
+// AddCrossfadeFallback records a transition that couldn't be beat-aligned
+func (jr *JobReport) AddCrossfadeFallback(fallback CrossfadeFallback) {
+	jr.mu.Lock()
+	defer jr.mu.Unlock()
+	jr.Fallbacks = append(jr.Fallbacks, fallback)
+}
+
+// AddUnkeyed records an input whose key couldn't be detected
+func (jr *JobReport) AddUnkeyed(track UnkeyedTrack) {
+	jr.mu.Lock()
+	defer jr.mu.Unlock()
+	jr.Unkeyed = append(jr.Unkeyed, track)
+}
+
+// AddWarning records an option that was ignored or changed for this job
+func (jr *JobReport) AddWarning(warning string) {
+	jr.mu.Lock()
+	defer jr.mu.Unlock()
+	jr.Warnings = append(jr.Warnings, warning)
+}
+
+// AddKey records the detected key of one input
+func (jr *JobReport) AddKey(key TrackKey) {
+	jr.mu.Lock()
+	defer jr.mu.Unlock()
+	jr.Keys = append(jr.Keys, key)
+}
+
+// SetOrder records the sequence order as indices into the uploaded files
+func (jr *JobReport) SetOrder(order []int) {
+	jr.mu.Lock()
+	defer jr.mu.Unlock()
+	jr.Order = order
+}
+
+// AddEnergy records the loudness and brightness of one input
+func (jr *JobReport) AddEnergy(energy TrackEnergy) {
+	jr.mu.Lock()
+	defer jr.mu.Unlock()
+	jr.Energy = append(jr.Energy, energy)
+}
+
+// SetOrderSeed records the seed used for shuffling
+func (jr *JobReport) SetOrderSeed(seed int64) {
+	jr.mu.Lock()
+	defer jr.mu.Unlock()
+	jr.OrderSeed = &seed
+}
+
+// SetLoopPoints records the detected loop region of a single-file loop
+func (jr *JobReport) SetLoopPoints(points LoopPoints) {
+	jr.mu.Lock()
+	defer jr.mu.Unlock()
+	jr.LoopPoints = &points
+}
+
+// SetNormalization records the internal format and the inputs converted to it
+func (jr *JobReport) SetNormalization(report NormalizationReport) {
+	jr.mu.Lock()
+	defer jr.mu.Unlock()
+	jr.Normalization = &report
+}
+
+// SetDynamics records the resolved dynamics settings applied to the mix
+func (jr *JobReport) SetDynamics(options DynamicsOptions) {
+	jr.mu.Lock()
+	defer jr.mu.Unlock()
+	jr.Dynamics = &options
+}
+
+// SetStereo records the stereo processing applied to the mix
+func (jr *JobReport) SetStereo(report StereoReport) {
+	jr.mu.Lock()
+	defer jr.mu.Unlock()
+	jr.Stereo = &report
+}
+
+// SetLimiter records the gain reduction applied by the output limiter
+func (jr *JobReport) SetLimiter(report LimiterReport) {
+	jr.mu.Lock()
+	defer jr.mu.Unlock()
+	jr.Limiter = &report
+}
+
+// SetCover records the cover art embedded into the output
+func (jr *JobReport) SetCover(cover CoverArt) {
+	jr.mu.Lock()
+	defer jr.mu.Unlock()
+	jr.Cover = &cover
+}
+
+// SetTracklist records where every track plays in the output, also as a CUE sheet and
+// as YouTube timestamps
+func (jr *JobReport) SetTracklist(markers []TrackMarker, cueSheet, timestamps string) {
+	jr.mu.Lock()
+	defer jr.mu.Unlock()
+	jr.Tracklist = markers
+	jr.CueSheet = cueSheet
+	jr.Timestamps = timestamps
+}
+
+// SetStream records the playlists of a segmented mix
+func (jr *JobReport) SetStream(stream StreamReport) {
+	jr.mu.Lock()
+	defer jr.mu.Unlock()
+	jr.Stream = &stream
+}
+
+// AddCleanup records the noise and hum removal applied to a track or the mix
+func (jr *JobReport) AddCleanup(report CleanupReport) {
+	jr.mu.Lock()
+	defer jr.mu.Unlock()
+	jr.Cleanup = append(jr.Cleanup, report)
+}
+
+// AddGapless records a gapless join; batch jobs add one per chunk and one for the merge
+func (jr *JobReport) AddGapless(result GaplessResult) {
+	jr.mu.Lock()
+	defer jr.mu.Unlock()
+	jr.Gapless = append(jr.Gapless, result)
+}
+
+// MarshalJSON encodes the report while holding its lock
+func (jr *JobReport) MarshalJSON() ([]byte, error) {
+	jr.mu.Lock()
+	defer jr.mu.Unlock()
+	type report JobReport
+	return json.Marshal((*report)(jr))
+}
+
+// JobStore keeps the reports of finished jobs
+type JobStore struct {
+	reports map[string]*JobReport
+	mutex   sync.RWMutex
+}
+
+// NewJobStore creates a new job store
+func NewJobStore() *JobStore {
+	return &JobStore{
+		reports: make(map[string]*JobReport),
+	}
+}
+
+// Global job store instance
+var GlobalJobStore = NewJobStore()
+
+// Save stores a job report under its session ID
+func (js *JobStore) Save(report *JobReport) {
+	js.mutex.Lock()
+	defer js.mutex.Unlock()
+	js.reports[report.SessionID] = report
+}
+
+// Get returns the report for a session
+func (js *JobStore) Get(sessionID string) (*JobReport, bool) {
+	js.mutex.RLock()
+	defer js.mutex.RUnlock()
+	report, exists := js.reports[sessionID]
+	return report, exists
+}
+
+// Delete removes the report for a session
+func (js *JobStore) Delete(sessionID string) {
+	js.mutex.Lock()
+	defer js.mutex.Unlock()
+	delete(js.reports, sessionID)
+}
+
+// ResultHandler handles HTTP requests for job results
+func ResultHandler(w http.ResponseWriter, r *http.Request) {
+	sessionID := r.URL.Query().Get("session_id")
+	if sessionID == "" {
+		http.Error(w, "Session ID required", http.StatusBadRequest)
+		return
+	}
+	if !SessionIDPattern.MatchString(sessionID) {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+
+	report, exists := GlobalJobStore.Get(sessionID)
+	if !exists {
+		// The job may have run on another instance sharing the storage
+		stored, err := GlobalResultStore.LoadReport(sessionID)
+		if err != nil {
+			http.Error(w, "Session not found", http.StatusNotFound)
+			return
+		}
+		GlobalJobStore.Save(stored)
+		report = stored
+	}
+
+	switch r.URL.Query().Get("format") {
+	case "zip":
+		// The mix, its sidecars and this report as one archive
+		w.Header().Set("Content-Type", "application/zip")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=mixloop_%s.zip", sessionID))
+		if err := GlobalResultStore.WriteZip(w, report); err != nil {
+			log.Printf("Failed to write result zip for %s: %v", sessionID, err)
+		}
+		return
+	case "audio":
+		// The mix itself, resumable with Range requests
+		report.mu.Lock()
+		output := report.Output
+		report.mu.Unlock()
+		if output == nil {
+			http.Error(w, "Output not found", http.StatusNotFound)
+			return
+		}
+		GlobalResultStore.ServeOutput(w, r, sessionID, *output)
+		return
+	case "stems":
+		if !GlobalResultStore.HasStems(sessionID) {
+			http.Error(w, "No stems were exported for this session", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/zip")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=mixloop_%s_stems.zip", sessionID))
+		if err := GlobalResultStore.WriteStemsZip(w, sessionID); err != nil {
+			log.Printf("Failed to write stems zip for %s: %v", sessionID, err)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
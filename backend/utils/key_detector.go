@@ -0,0 +1,190 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+const (
+	keySampleRate = 11025
+	keyFrameSize  = 4096
+	keyMinFreq    = 55.0
+	keyMaxFreq    = 2000.0
+)
+
+var (
+	pitchClassNames = []string{"C", "Db", "D", "Eb", "E", "F", "F#", "G", "Ab", "A", "Bb", "B"}
+
+	// Krumhansl-Schmuckler key profiles
+	majorKeyProfile = []float64{6.35, 2.23, 3.48, 2.33, 4.38, 4.09, 2.52, 5.19, 2.39, 3.66, 2.29, 2.88}
+	minorKeyProfile = []float64{6.33, 2.68, 3.52, 5.38, 2.60, 3.53, 2.54, 4.75, 3.98, 2.69, 3.34, 3.17}
+)
+
+// ErrNoKey is returned for audio without a tonal centre, such as noise, percussion and
+// very short files
+var ErrNoKey = errors.New("no key found")
+
+// KeyInfo describes the detected musical key of a track
+type KeyInfo struct {
+	Key        string  `json:"key"`
+	Tonic      string  `json:"tonic"`
+	Mode       string  `json:"mode"`
+	Camelot    string  `json:"camelot"`
+	Confidence float64 `json:"confidence"`
+}
+
+// DetectKey estimates the key of an audio file from its chroma profile
+func DetectKey(file string) (*KeyInfo, error) {
+	window := hannWindow(keyFrameSize)
+	chroma := make([]float64, 12)
+
+	// Map every FFT bin in the musical range to its pitch class once
+	binClass := make([]int, keyFrameSize/2)
+	for bin := range binClass {
+		freq := float64(bin) * keySampleRate / keyFrameSize
+		if freq < keyMinFreq || freq > keyMaxFreq {
+			binClass[bin] = -1
+			continue
+		}
+		midi := int(math.Round(69 + 12*math.Log2(freq/440)))
+		binClass[bin] = midi % 12
+	}
+
+	frames := 0
+	err := streamFrames(file, keySampleRate, keyFrameSize, func(frame []float32) {
+		magnitudes := magnitudeSpectrum(frame, window)
+		for bin, magnitude := range magnitudes {
+			if class := binClass[bin]; class >= 0 {
+				chroma[class] += magnitude
+			}
+		}
+		frames++
+	})
+	if err != nil {
+		return nil, err
+	}
+	if frames == 0 {
+		return nil, fmt.Errorf("%w: audio too short", ErrNoKey)
+	}
+
+	return KeyFromChroma(chroma)
+}
+
+// KeyFromChroma correlates a 12-bin chroma vector (C first) with the major and minor key profiles
+func KeyFromChroma(chroma []float64) (*KeyInfo, error) {
+	if len(chroma) != 12 {
+		return nil, fmt.Errorf("chroma must have 12 bins")
+	}
+
+	best, second := -2.0, -2.0
+	bestTonic, bestMode := 0, "major"
+	for tonic := 0; tonic < 12; tonic++ {
+		for _, mode := range []string{"major", "minor"} {
+			profile := majorKeyProfile
+			if mode == "minor" {
+				profile = minorKeyProfile
+			}
+			rotated := make([]float64, 12)
+			for i := range rotated {
+				rotated[(i+tonic)%12] = profile[i]
+			}
+
+			score := pearson(chroma, rotated)
+			if score > best {
+				second = best
+				best = score
+				bestTonic, bestMode = tonic, mode
+			} else if score > second {
+				second = score
+			}
+		}
+	}
+
+	// A flat chroma correlates with nothing (NaN), leaving the initial score in place
+	if best < -1 {
+		return nil, fmt.Errorf("%w: no tonal content", ErrNoKey)
+	}
+
+	key := &KeyInfo{
+		Tonic:      pitchClassNames[bestTonic],
+		Mode:       bestMode,
+		Camelot:    CamelotCode(bestTonic, bestMode),
+		Confidence: math.Round((best-second)*1000) / 1000,
+	}
+	key.Key = key.Tonic + " " + key.Mode
+	return key, nil
+}
+
+// CamelotCode returns the Camelot wheel position of a key, e.g. "8A" for A minor
+func CamelotCode(tonic int, mode string) string {
+	if mode == "minor" {
+		// A minor shares its position with its relative major, C
+		number := (7*((tonic+3)%12) + 8) % 12
+		if number == 0 {
+			number = 12
+		}
+		return fmt.Sprintf("%dA", number)
+	}
+
+	number := (7*tonic + 8) % 12
+	if number == 0 {
+		number = 12
+	}
+	return fmt.Sprintf("%dB", number)
+}
+
+// CamelotDistance counts the wheel steps between two Camelot codes; 0 and 1 mix harmonically
+func CamelotDistance(a, b string) int {
+	numA, letterA, okA := parseCamelot(a)
+	numB, letterB, okB := parseCamelot(b)
+	if !okA || !okB {
+		return 12
+	}
+
+	diff := numA - numB
+	if diff < 0 {
+		diff = -diff
+	}
+	if diff > 6 {
+		diff = 12 - diff
+	}
+	if letterA != letterB {
+		diff++
+	}
+	return diff
+}
+
+// parseCamelot splits a Camelot code into its number and letter
+func parseCamelot(code string) (int, byte, bool) {
+	if len(code) < 2 {
+		return 0, 0, false
+	}
+	letter := code[len(code)-1]
+	number, err := strconv.Atoi(code[:len(code)-1])
+	if err != nil || number < 1 || number > 12 || (letter != 'A' && letter != 'B') {
+		return 0, 0, false
+	}
+	return number, letter, true
+}
+
+// pearson returns the correlation coefficient of two equally long vectors
+func pearson(a, b []float64) float64 {
+	var meanA, meanB float64
+	for i := range a {
+		meanA += a[i]
+		meanB += b[i]
+	}
+	meanA /= float64(len(a))
+	meanB /= float64(len(b))
+
+	var cov, varA, varB float64
+	for i := range a {
+		da, db := a[i]-meanA, b[i]-meanB
+		cov += da * db
+		varA += da * da
+		varB += db * db
+	}
+	return cov / math.Sqrt(varA*varB)
+}
@@ -0,0 +1,73 @@
+package utils
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+)
+
+const (
+	limiterOversampleRate = 192000
+	peakFloorDB           = -144.0
+)
+
+var truePeakPattern = regexp.MustCompile(`True peak:\s+Peak:\s+(-?inf|-?[\d.]+) dBFS`)
+
+// LimiterReport describes what the output limiter did to a mix
+type LimiterReport struct {
+	Ceiling       float64 `json:"ceiling_dbtp"`
+	InputPeak     float64 `json:"input_peak_dbtp"`
+	OutputPeak    float64 `json:"output_peak_dbtp"`
+	GainReduction float64 `json:"gain_reduction_db"`
+	Limited       bool    `json:"limited"`
+}
+
+// Limiter is a brickwall true-peak limiter that runs as the last filter of every output
+type Limiter struct {
+	Ceiling float64 // dBTP
+}
+
+// NewLimiter creates a limiter with the given ceiling in dBTP
+func NewLimiter(ceiling float64) *Limiter {
+	return &Limiter{
+		Ceiling: ceiling,
+	}
+}
+
+// Filter returns the filter chain: meter the input, limit oversampled so inter-sample peaks
+// are caught, resample to outputRate and meter the result. outputRate must be the rate the
+// output is encoded at, so nothing resamples the signal after it was limited.
+func (l *Limiter) Filter(outputRate int) string {
+	limit := math.Pow(10, l.Ceiling/20)
+	return fmt.Sprintf("ebur128=peak=true:framelog=verbose,aresample=%d,alimiter=limit=%.4f:attack=5:release=50:level=false,aresample=%d,ebur128=peak=true:framelog=verbose",
+		limiterOversampleRate, limit, outputRate)
+}
+
+// ParseReport reads the two true peak summaries printed by Filter from the ffmpeg log
+func (l *Limiter) ParseReport(output []byte) (*LimiterReport, error) {
+	matches := truePeakPattern.FindAllSubmatch(output, -1)
+	if len(matches) < 2 {
+		return nil, fmt.Errorf("limiter peak measurements not found in ffmpeg output")
+	}
+
+	report := &LimiterReport{
+		Ceiling:    l.Ceiling,
+		InputPeak:  parsePeakDB(string(matches[0][1])),
+		OutputPeak: parsePeakDB(string(matches[len(matches)-1][1])),
+	}
+	if report.InputPeak > l.Ceiling {
+		report.Limited = true
+		report.GainReduction = math.Round((report.InputPeak-l.Ceiling)*100) / 100
+	}
+	return report, nil
+}
+
+// parsePeakDB converts an ebur128 peak value, clamping silence to a finite floor
+func parsePeakDB(value string) float64 {
+	peak, err := strconv.ParseFloat(value, 64)
+	if err != nil || math.IsInf(peak, 0) || peak < peakFloorDB {
+		return peakFloorDB
+	}
+	return peak
+}
@@ -0,0 +1,162 @@
+package utils
+
+import (
+	"fmt"
+	"math"
+)
+
+const (
+	loopSampleRate     = 22050
+	loopWindowSize     = 2048
+	loopMaxCandidates  = 150
+	loopSearchFraction = 0.25
+	loopMaxSearch      = 15.0 // seconds
+)
+
+// LoopPoints are the in and out points of a seamless loop region
+type LoopPoints struct {
+	Start      float64 `json:"start"`
+	End        float64 `json:"end"`
+	Confidence float64 `json:"confidence"`
+	Used       bool    `json:"used"`
+}
+
+// FindLoopPoints searches the head and tail of a file for the pair of points where
+// jumping from End back to Start is least audible. Candidates are rising zero crossings,
+// so both points share the same phase, and pairs are scored by waveform cross-correlation
+// and spectral similarity of the audio around them.
+func FindLoopPoints(file string) (*LoopPoints, error) {
+	duration, err := GetAudioDuration(file)
+	if err != nil {
+		return nil, err
+	}
+
+	// Short files are searched over a quarter of their length at each end, so decode them whole
+	if duration*loopSearchFraction < loopMaxSearch {
+		pcm, err := DecodePCM(file, loopSampleRate, 1)
+		if err != nil {
+			return nil, err
+		}
+		return FindLoopPointsInPCM(pcm, loopSampleRate)
+	}
+
+	// Long files only need the search regions at each end plus a window of context
+	region := loopMaxSearch + float64(loopWindowSize)/loopSampleRate
+	head, err := DecodePCMRange(file, loopSampleRate, 1, 0, region)
+	if err != nil {
+		return nil, err
+	}
+	tailStart := duration - region
+	tail, err := DecodePCMRange(file, loopSampleRate, 1, tailStart, region)
+	if err != nil {
+		return nil, err
+	}
+	search := int(loopMaxSearch * loopSampleRate)
+	return findLoopPointsInRegions(head, tail, int(math.Round(tailStart*loopSampleRate)), search, loopSampleRate)
+}
+
+// FindLoopPointsInPCM runs the loop point search on mono samples
+func FindLoopPointsInPCM(pcm []float32, sampleRate int) (*LoopPoints, error) {
+	search := int(math.Min(float64(len(pcm))*loopSearchFraction, loopMaxSearch*float64(sampleRate)))
+	return findLoopPointsInRegions(pcm, pcm, 0, search, sampleRate)
+}
+
+// findLoopPointsInRegions looks for start points in the first search samples of head and end
+// points in the last search samples of tail. tailOffset is where tail begins in the file.
+func findLoopPointsInRegions(head, tail []float32, tailOffset, search, sampleRate int) (*LoopPoints, error) {
+	// Start points need a window after them, end points a window before them
+	search = min(search, min(len(head), len(tail))-loopWindowSize)
+	if search < loopWindowSize*2 {
+		return nil, fmt.Errorf("audio too short for loop point detection")
+	}
+
+	n := len(tail)
+	starts := zeroCrossingCandidates(head, loopWindowSize, search)
+	ends := zeroCrossingCandidates(tail, n-search, n-loopWindowSize)
+	if len(starts) == 0 || len(ends) == 0 {
+		return nil, fmt.Errorf("no zero crossings found")
+	}
+
+	window := hannWindow(loopWindowSize)
+	startSpectra := make([][]float64, len(starts))
+	for i, pos := range starts {
+		startSpectra[i] = magnitudeSpectrum(head[pos:pos+loopWindowSize], window)
+	}
+	endSpectra := make([][]float64, len(ends))
+	for i, pos := range ends {
+		endSpectra[i] = magnitudeSpectrum(tail[pos:pos+loopWindowSize], window)
+	}
+
+	best := &LoopPoints{Confidence: -1}
+	for i, a := range starts {
+		for j, b := range ends {
+			// What follows the out point should sound like what follows the in point,
+			// and what leads into them should match as well
+			after := normalizedCorrelation(head[a:a+loopWindowSize], tail[b:b+loopWindowSize])
+			before := normalizedCorrelation(head[a-loopWindowSize:a], tail[b-loopWindowSize:b])
+			spectral := cosineSimilarity(startSpectra[i], endSpectra[j])
+
+			score := 0.35*after + 0.35*before + 0.3*spectral
+			if score > best.Confidence {
+				best.Start = float64(a) / float64(sampleRate)
+				best.End = float64(tailOffset+b) / float64(sampleRate)
+				best.Confidence = score
+			}
+		}
+	}
+
+	best.Confidence = math.Round(math.Max(0, math.Min(1, best.Confidence))*1000) / 1000
+	return best, nil
+}
+
+// zeroCrossingCandidates returns up to loopMaxCandidates rising zero crossings spread evenly over [from, to)
+func zeroCrossingCandidates(pcm []float32, from, to int) []int {
+	var crossings []int
+	for i := from + 1; i < to; i++ {
+		if pcm[i-1] < 0 && pcm[i] >= 0 {
+			crossings = append(crossings, i)
+		}
+	}
+	if len(crossings) <= loopMaxCandidates {
+		return crossings
+	}
+
+	picked := make([]int, 0, loopMaxCandidates)
+	step := float64(len(crossings)) / loopMaxCandidates
+	for k := 0; k < loopMaxCandidates; k++ {
+		picked = append(picked, crossings[int(float64(k)*step)])
+	}
+	return picked
+}
+
+// normalizedCorrelation returns the zero-lag normalized cross-correlation of two windows
+func normalizedCorrelation(a, b []float32) float64 {
+	var dot, energyA, energyB float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		energyA += float64(a[i]) * float64(a[i])
+		energyB += float64(b[i]) * float64(b[i])
+	}
+	if energyA == 0 || energyB == 0 {
+		// Two silent windows join seamlessly
+		if energyA == energyB {
+			return 1
+		}
+		return 0
+	}
+	return dot / math.Sqrt(energyA*energyB)
+}
+
+// cosineSimilarity compares two magnitude spectra
+func cosineSimilarity(a, b []float64) float64 {
+	var dot, normA, normB float64
+	for i := range a {
+		dot += a[i] * b[i]
+		normA += a[i] * a[i]
+		normB += b[i] * b[i]
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return dot / math.Sqrt(normA*normB)
+}
@@ -0,0 +1,45 @@
+package utils
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func TestLoopPointsFromHeadAndTailMatchWholeFile(t *testing.T) {
+	const sampleRate = 8000
+	const seconds = 70 // long enough that only the last loopMaxSearch seconds are searched
+	rng := rand.New(rand.NewSource(1))
+	pcm := make([]float32, seconds*sampleRate)
+	for i := range pcm {
+		phase := 2 * math.Pi * float64(i) / sampleRate
+		pcm[i] = float32(0.5*math.Sin(220*phase) + 0.2*math.Sin(331*phase) + 0.05*rng.NormFloat64())
+	}
+
+	whole, err := FindLoopPointsInPCM(pcm, sampleRate)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// What FindLoopPoints decodes from a long file: the search regions plus a window of context
+	region := int(loopMaxSearch*sampleRate) + loopWindowSize
+	head := pcm[:region]
+	tail := pcm[len(pcm)-region:]
+	regions, err := findLoopPointsInRegions(head, tail, len(pcm)-region, int(loopMaxSearch*sampleRate), sampleRate)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if *regions != *whole {
+		t.Errorf("regions found %+v, the whole file %+v", regions, whole)
+	}
+	if whole.End < seconds-loopMaxSearch {
+		t.Errorf("end point %g s is outside the tail", whole.End)
+	}
+}
+
+func TestLoopPointsTooShort(t *testing.T) {
+	if _, err := FindLoopPointsInPCM(make([]float32, 1000), 8000); err == nil {
+		t.Error("search on 1000 samples succeeded")
+	}
+}
@@ -0,0 +1,263 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var (
+	isrcPattern        = regexp.MustCompile(`^[A-Z]{2}[A-Z0-9]{3}[0-9]{7}$`)
+	yearPattern        = regexp.MustCompile(`^[0-9]{4}(-[0-9]{2}(-[0-9]{2})?)?$`)
+	metadataKeyPattern = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_]{0,31}$`)
+)
+
+const maxMetadataValue = 1024
+
+// Metadata is the descriptive tagging written into the final output
+type Metadata struct {
+	Title   string            `json:"title,omitempty"`
+	Artist  string            `json:"artist,omitempty"`
+	Album   string            `json:"album,omitempty"`
+	Genre   string            `json:"genre,omitempty"`
+	Year    string            `json:"year,omitempty"` // YYYY or YYYY-MM-DD
+	Comment string            `json:"comment,omitempty"`
+	ISRC    string            `json:"isrc,omitempty"`
+	Custom  map[string]string `json:"custom,omitempty"`
+}
+
+// Validate checks the fields and normalises the ISRC to its compact upper-case form
+func (m *Metadata) Validate() error {
+	for name, value := range map[string]string{
+		"title": m.Title, "artist": m.Artist, "album": m.Album,
+		"genre": m.Genre, "comment": m.Comment,
+	} {
+		if err := validateMetadataValue(name, value); err != nil {
+			return err
+		}
+	}
+
+	if m.Year != "" && !yearPattern.MatchString(m.Year) {
+		return fmt.Errorf("year must be YYYY or YYYY-MM-DD")
+	}
+	if m.ISRC != "" {
+		m.ISRC = strings.ToUpper(strings.ReplaceAll(m.ISRC, "-", ""))
+		if !isrcPattern.MatchString(m.ISRC) {
+			return fmt.Errorf("isrc must look like CC-XXX-YY-NNNNN")
+		}
+	}
+	for key, value := range m.Custom {
+		if !metadataKeyPattern.MatchString(key) {
+			return fmt.Errorf("invalid custom metadata key %q", key)
+		}
+		if err := validateMetadataValue(key, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func validateMetadataValue(name, value string) error {
+	if len(value) > maxMetadataValue {
+		return fmt.Errorf("%s is longer than %d bytes", name, maxMetadataValue)
+	}
+	if strings.ContainsRune(value, 0) {
+		return fmt.Errorf("%s contains a NUL byte", name)
+	}
+	return nil
+}
+
+// WithDefaults fills empty fields from defaults; custom keys are merged with m winning
+func (m Metadata) WithDefaults(defaults Metadata) Metadata {
+	fill := func(value *string, fallback string) {
+		if *value == "" {
+			*value = fallback
+		}
+	}
+	fill(&m.Title, defaults.Title)
+	fill(&m.Artist, defaults.Artist)
+	fill(&m.Album, defaults.Album)
+	fill(&m.Genre, defaults.Genre)
+	fill(&m.Year, defaults.Year)
+	fill(&m.Comment, defaults.Comment)
+	fill(&m.ISRC, defaults.ISRC)
+
+	if len(defaults.Custom) > 0 {
+		custom := make(map[string]string, len(defaults.Custom)+len(m.Custom))
+		for key, value := range defaults.Custom {
+			custom[key] = value
+		}
+		for key, value := range m.Custom {
+			custom[key] = value
+		}
+		m.Custom = custom
+	}
+	return m
+}
+
+// IsEmpty reports whether there is nothing to write
+func (m Metadata) IsEmpty() bool {
+	return m.Title == "" && m.Artist == "" && m.Album == "" && m.Genre == "" &&
+		m.Year == "" && m.Comment == "" && m.ISRC == "" && len(m.Custom) == 0
+}
+
+// FFmpegArgs returns the -metadata arguments for a format. ffmpeg maps the generic keys to
+// ID3v2.4 frames for MP3, Vorbis comments for FLAC and Opus, MP4 atoms for M4A and
+// RIFF INFO for WAV. The ISRC and custom keys are written for every format but WAV,
+// whose INFO chunk has no field for them; WriteMetadataChunks adds those.
+func (m Metadata) FFmpegArgs(format string) []string {
+	var tags [][2]string
+	add := func(key, value string) {
+		if value != "" {
+			tags = append(tags, [2]string{key, value})
+		}
+	}
+
+	add("title", m.Title)
+	add("artist", m.Artist)
+	add("album", m.Album)
+	add("genre", m.Genre)
+	add("date", m.Year)
+	add("comment", m.Comment)
+
+	switch format {
+	case "mp3":
+		// Frame IDs are written as-is, other keys become TXXX frames
+		add("TSRC", m.ISRC)
+		for _, key := range m.customKeys() {
+			add(key, m.Custom[key])
+		}
+	case "flac", "opus":
+		add("ISRC", m.ISRC)
+		for _, key := range m.customKeys() {
+			add(strings.ToUpper(key), m.Custom[key])
+		}
+	case "m4a":
+		// Written as freeform atoms, which needs +use_metadata_tags in the movflags
+		add("ISRC", m.ISRC)
+		for _, key := range m.customKeys() {
+			add(key, m.Custom[key])
+		}
+	}
+
+	var args []string
+	for _, tag := range tags {
+		args = append(args, "-metadata", tag[0]+"="+tag[1])
+	}
+	return args
+}
+
+func (m Metadata) customKeys() []string {
+	keys := make([]string, 0, len(m.Custom))
+	for key := range m.Custom {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// WriteMetadataChunks adds the tags ffmpeg can't write for a format. For WAV that is an
+// iXML chunk with every field, since RIFF INFO has no place for the ISRC or custom keys.
+func (m Metadata) WriteMetadataChunks(file, format string) error {
+	if format != "wav" || m.IsEmpty() {
+		return nil
+	}
+	return AppendRIFFChunk(file, "iXML", m.IXML())
+}
+
+// IXML renders the metadata as an iXML document
+func (m Metadata) IXML() []byte {
+	type userField struct {
+		Key   string `xml:"KEY"`
+		Value string `xml:"VALUE"`
+	}
+	doc := struct {
+		XMLName xml.Name    `xml:"BWFXML"`
+		Version string      `xml:"IXML_VERSION"`
+		Project string      `xml:"PROJECT,omitempty"`
+		Note    string      `xml:"NOTE,omitempty"`
+		User    []userField `xml:"USER_FIELDS>USER_FIELD"`
+	}{
+		Version: "2.10",
+		Project: m.Album,
+		Note:    m.Comment,
+	}
+
+	for _, field := range [][2]string{
+		{"TITLE", m.Title}, {"ARTIST", m.Artist}, {"ALBUM", m.Album}, {"GENRE", m.Genre},
+		{"YEAR", m.Year}, {"COMMENT", m.Comment}, {"ISRC", m.ISRC},
+	} {
+		if field[1] != "" {
+			doc.User = append(doc.User, userField{Key: field[0], Value: field[1]})
+		}
+	}
+	for _, key := range m.customKeys() {
+		doc.User = append(doc.User, userField{Key: strings.ToUpper(key), Value: m.Custom[key]})
+	}
+
+	data, _ := xml.MarshalIndent(doc, "", "  ")
+	return append([]byte(xml.Header), data...)
+}
+
+// AppendRIFFChunk appends a chunk to a RIFF WAVE file and updates the RIFF size
+func AppendRIFFChunk(path, id string, data []byte) error {
+	if len(id) != 4 {
+		return fmt.Errorf("chunk id must be 4 bytes")
+	}
+
+	file, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	header := make([]byte, 12)
+	if _, err := io.ReadFull(file, header); err != nil {
+		return fmt.Errorf("failed to read RIFF header: %v", err)
+	}
+	if !bytes.Equal(header[8:12], []byte("WAVE")) {
+		return fmt.Errorf("not a WAVE file")
+	}
+	if bytes.Equal(header[:4], []byte("RF64")) {
+		return fmt.Errorf("RF64 files are too large for extra chunks")
+	}
+	if !bytes.Equal(header[:4], []byte("RIFF")) {
+		return fmt.Errorf("not a RIFF file")
+	}
+
+	end, err := file.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+
+	// Chunks start on even offsets and are padded to an even length
+	var chunk []byte
+	if end%2 == 1 {
+		chunk = append(chunk, 0)
+	}
+	chunk = append(chunk, id...)
+	chunk = binary.LittleEndian.AppendUint32(chunk, uint32(len(data)))
+	chunk = append(chunk, data...)
+	if len(data)%2 == 1 {
+		chunk = append(chunk, 0)
+	}
+
+	size := end + int64(len(chunk)) - 8
+	if size > 0xFFFFFFFF {
+		return fmt.Errorf("file too large for an extra chunk")
+	}
+	if _, err := file.Write(chunk); err != nil {
+		return err
+	}
+
+	sizeBytes := make([]byte, 4)
+	binary.LittleEndian.PutUint32(sizeBytes, uint32(size))
+	_, err = file.WriteAt(sizeBytes, 4)
+	return err
+}
@@ -0,0 +1,59 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMetadataFFmpegArgs(t *testing.T) {
+	metadata := Metadata{
+		Title:  "Night Loop",
+		Artist: "Mixloop",
+		Year:   "2024",
+		ISRC:   "USRC17607839",
+		Custom: map[string]string{"label": "Loops Ltd", "bpm": "124"},
+	}
+	common := []string{"title=Night Loop", "artist=Mixloop", "date=2024"}
+
+	tests := []struct {
+		format  string
+		want    []string
+		missing []string
+	}{
+		{"mp3", []string{"TSRC=USRC17607839", "bpm=124", "label=Loops Ltd"}, []string{"ISRC="}},
+		{"flac", []string{"ISRC=USRC17607839", "BPM=124", "LABEL=Loops Ltd"}, nil},
+		{"opus", []string{"ISRC=USRC17607839", "BPM=124", "LABEL=Loops Ltd"}, nil},
+		{"m4a", []string{"ISRC=USRC17607839", "bpm=124", "label=Loops Ltd"}, nil},
+		// RIFF INFO has no field for these; they go to the iXML chunk
+		{"wav", nil, []string{"ISRC=", "TSRC=", "bpm=", "label="}},
+	}
+	for _, test := range tests {
+		args := metadata.FFmpegArgs(test.format)
+		values := map[string]bool{}
+		for i := 0; i < len(args); i += 2 {
+			if args[i] != "-metadata" {
+				t.Fatalf("%s: argument %q isn't -metadata", test.format, args[i])
+			}
+			values[args[i+1]] = true
+		}
+		for _, want := range append(common, test.want...) {
+			if !values[want] {
+				t.Errorf("%s: missing %q in %v", test.format, want, args)
+			}
+		}
+		for _, prefix := range test.missing {
+			for value := range values {
+				if strings.HasPrefix(value, prefix) {
+					t.Errorf("%s: unexpected %q", test.format, value)
+				}
+			}
+		}
+	}
+}
+
+func TestM4AWritesFreeformTags(t *testing.T) {
+	args := strings.Join(OutputFormats["m4a"].ExtraArgs, " ")
+	if !strings.Contains(args, "+use_metadata_tags") || !strings.Contains(args, "+faststart") {
+		t.Errorf("m4a movflags %q don't keep custom tags", args)
+	}
+}
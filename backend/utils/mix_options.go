@@ -0,0 +1,198 @@
+package utils
+
+// Units for the crossfade length
+const (
+	CrossfadeUnitSeconds = "seconds"
+	CrossfadeUnitBeats   = "beats"
+	CrossfadeUnitBars    = "bars"
+)
+
+// TrackOptions are the processing settings of one track, addressed by its upload index
+type TrackOptions struct {
+	Index int `json:"index"`
+	CleanupOptions
+	EQ []EQBand `json:"eq,omitempty"`
+}
+
+// MixOptions holds every setting of a mix job
+type MixOptions struct {
+	Loops         int
+	Crossfade     float64 // length in CrossfadeUnit
+	CrossfadeUnit string  // "seconds", or "beats"/"bars" for transitions that start on downbeats
+	Enhance       bool
+	Format        string
+
+	// Cleanup is the noise and hum removal applied to the whole mix
+	Cleanup CleanupOptions
+
+	// EQ is the parametric EQ applied to the whole mix
+	EQ []EQBand
+
+	// Dynamics is the gate and (multiband) compression applied to the whole mix
+	Dynamics DynamicsOptions
+
+	// Tracks holds per-track settings; tracks without an entry are left alone
+	Tracks []TrackOptions
+
+	// Stereo processing of the finished sequence, see StereoProcessor
+	StereoMode           string
+	StereoWidth          float64 // side level for "widen", 1 leaves the image unchanged
+	HaasDelay            float64 // milliseconds for "haas"
+	StereoMinCorrelation float64 // phase correlation below which widening is rolled back
+
+	// InputNames are the original file names, in the same order as the input files
+	InputNames []string
+	// InputIndices are the upload indexes of the input files; nil means upload order
+	InputIndices []int
+	// InputMarkers are the timelines of inputs that are mixes themselves, such as batch chunks
+	InputMarkers [][]TrackMarker
+	// Tempos caches the beat grids detected during the job
+	Tempos *TempoCache
+	// KnownFormats are the formats of inputs that were probed before the job, so they
+	// aren't probed again
+	KnownFormats FormatCache
+
+	// Silence trimming of track heads and tails
+	TrimSilence        bool
+	SilenceThreshold   float64 // dBFS
+	SilenceMinDuration float64 // seconds
+
+	// Tempo matching: stretch every input to TargetBPM, or to the tempo of the first track in sequence order
+	TargetBPM         float64
+	MatchFirstTempo   bool
+	MaxStretchPercent float64
+
+	// Order is the sequencing strategy, see OrderTracks
+	Order        string
+	OrderSeed    int64 // makes shuffles reproducible
+	OrderIndices []int // upload indices for the explicit order
+	// ReshuffleLoops gives every loop iteration after the first its own seeded shuffle
+	ReshuffleLoops bool
+
+	// SeamlessLoop repeats a single input between detected loop points instead of crossfading
+	SeamlessLoop bool
+
+	// NormalizeFormat converts inputs to a common sample rate and channel layout before sequencing
+	NormalizeFormat bool
+
+	// Limiter is the brickwall true-peak limiter that runs last on the final output
+	Limiter        bool
+	LimiterCeiling float64 // dBTP
+
+	// Metadata is the tagging written into the final output
+	Metadata Metadata
+	// Cover is the artwork embedded into the final output; may be nil
+	Cover *CoverArt
+	// Chapters embeds a chapter per track and loop iteration into the final output
+	Chapters bool
+	// OutputName is the file name the output is delivered as, used in the CUE sheet
+	OutputName string
+
+	// ExportStems writes every prepared track, every crossfade and a manifest to StemsDir
+	ExportStems bool
+	StemsDir    string
+
+	// Gapless joins un-crossfaded tracks sample-accurately in a common PCM format
+	Gapless bool
+
+	// Prepared marks inputs that already went through the per-track stages
+	Prepared bool
+
+	// Report receives the per-job results; may be nil
+	Report *JobReport
+}
+
+// DefaultMixOptions returns the options used when a request doesn't override them
+func DefaultMixOptions() MixOptions {
+	return MixOptions{
+		Loops:                1,
+		Crossfade:            2.0,
+		CrossfadeUnit:        CrossfadeUnitSeconds,
+		Enhance:              true,
+		Format:               "mp3",
+		NormalizeFormat:      true,
+		StereoMode:           StereoModePassthrough,
+		StereoWidth:          1.5,
+		HaasDelay:            15,
+		StereoMinCorrelation: 0.3,
+		Limiter:              true,
+		Chapters:             true,
+		LimiterCeiling:       -1,
+		SilenceThreshold:     -50,
+		SilenceMinDuration:   0.1,
+		MaxStretchPercent:    8,
+		Order:                OrderUpload,
+	}
+}
+
+// StretchesTempo reports whether inputs are time-stretched to a common tempo
+func (mo MixOptions) StretchesTempo() bool {
+	return mo.TargetBPM > 0 || mo.MatchFirstTempo
+}
+
+// BeatAlignedCrossfades reports whether crossfades are measured in beats or bars
+func (mo MixOptions) BeatAlignedCrossfades() bool {
+	return mo.CrossfadeUnit == CrossfadeUnitBeats || mo.CrossfadeUnit == CrossfadeUnitBars
+}
+
+// NeedsTempo reports whether the inputs' beat grids are needed
+func (mo MixOptions) NeedsTempo() bool {
+	return mo.BeatAlignedCrossfades() || mo.StretchesTempo()
+}
+
+// HasTrackStages reports whether any per-track processing is requested
+func (mo MixOptions) HasTrackStages() bool {
+	if mo.Prepared {
+		return false
+	}
+	return mo.NormalizeFormat || len(mo.Tracks) > 0 || mo.TrimSilence || mo.NeedsTempo() || mo.ExportStems || (mo.Order != "" && mo.Order != OrderUpload)
+}
+
+// WithoutTrackStages returns a copy for inputs that were already prepared
+func (mo MixOptions) WithoutTrackStages() MixOptions {
+	mo.Prepared = true
+	return mo
+}
+
+// InputIndex returns the upload index of input file i
+func (mo MixOptions) InputIndex(i int) int {
+	if i < len(mo.InputIndices) {
+		return mo.InputIndices[i]
+	}
+	return i
+}
+
+// ForInputs returns a copy for the input files from..to-1, e.g. one batch chunk
+func (mo MixOptions) ForInputs(from, to int) MixOptions {
+	indices := make([]int, 0, to-from)
+	for i := from; i < to; i++ {
+		indices = append(indices, mo.InputIndex(i))
+	}
+	mo.InputIndices = indices
+
+	if to <= len(mo.InputNames) {
+		mo.InputNames = mo.InputNames[from:to]
+	} else {
+		mo.InputNames = nil
+	}
+	mo.InputMarkers = nil
+	return mo
+}
+
+// TrackSettings returns the per-track settings of upload index i
+func (mo MixOptions) TrackSettings(i int) (TrackOptions, bool) {
+	for _, track := range mo.Tracks {
+		if track.Index == i {
+			return track, true
+		}
+	}
+	return TrackOptions{}, false
+}
+
+// InputName returns the original name of input i, falling back to its position
+func (mo MixOptions) InputName(i int, fallback string) string {
+	if i >= 0 && i < len(mo.InputNames) && mo.InputNames[i] != "" {
+		return mo.InputNames[i]
+	}
+	return fallback
+}
@@ -0,0 +1,153 @@
+package utils
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+)
+
+// mp3DecoderDelay is the delay added by the MP3 synthesis filterbank on decode
+const mp3DecoderDelay = 529
+
+var (
+	mp3SampleRates = map[int][]int{
+		3: {44100, 48000, 32000}, // MPEG-1
+		2: {22050, 24000, 16000}, // MPEG-2
+		0: {11025, 12000, 8000},  // MPEG-2.5
+	}
+)
+
+// MP3GaplessInfo holds the encoder delay and padding read from a LAME/Xing header
+type MP3GaplessInfo struct {
+	SampleRate      int `json:"sample_rate"`
+	Frames          int `json:"frames"`
+	SamplesPerFrame int `json:"samples_per_frame"`
+	EncoderDelay    int `json:"encoder_delay"`
+	Padding         int `json:"padding"`
+}
+
+// Samples returns the number of real audio samples per channel in the file
+func (gi *MP3GaplessInfo) Samples() int64 {
+	return int64(gi.Frames)*int64(gi.SamplesPerFrame) - int64(gi.EncoderDelay) - int64(gi.Padding)
+}
+
+// ReadMP3GaplessInfo parses the Xing/Info frame and LAME extension of an MP3 file.
+// It returns an error when the file has no such header.
+func ReadMP3GaplessInfo(path string) (*MP3GaplessInfo, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	// Skip an ID3v2 tag if present
+	header := make([]byte, 10)
+	if _, err := io.ReadFull(file, header); err != nil {
+		return nil, fmt.Errorf("file too short")
+	}
+	offset := int64(0)
+	if bytes.Equal(header[:3], []byte("ID3")) {
+		size := int64(header[6]&0x7f)<<21 | int64(header[7]&0x7f)<<14 | int64(header[8]&0x7f)<<7 | int64(header[9]&0x7f)
+		offset = 10 + size
+		if header[5]&0x10 != 0 {
+			offset += 10 // footer
+		}
+	}
+
+	// The Xing frame is the first frame; 4KB covers any junk before it plus the whole frame
+	buf := make([]byte, 4096)
+	n, err := file.ReadAt(buf, offset)
+	if n == 0 {
+		return nil, fmt.Errorf("failed to read first frame: %v", err)
+	}
+	buf = buf[:n]
+
+	for i := 0; i+4 <= len(buf); i++ {
+		if buf[i] != 0xff || buf[i+1]&0xe0 != 0xe0 {
+			continue
+		}
+		info, ok := parseXingFrame(buf[i:])
+		if ok {
+			return info, nil
+		}
+	}
+
+	return nil, fmt.Errorf("no Xing/LAME header found")
+}
+
+// parseXingFrame reads gapless info from a frame that starts at frame[0]
+func parseXingFrame(frame []byte) (*MP3GaplessInfo, bool) {
+	version := int(frame[1]>>3) & 0x03
+	layer := int(frame[1]>>1) & 0x03
+	rateIndex := int(frame[2]>>2) & 0x03
+	channelMode := int(frame[3]>>6) & 0x03
+
+	rates, ok := mp3SampleRates[version]
+	if !ok || layer != 1 || rateIndex == 3 {
+		return nil, false // not a valid Layer III header
+	}
+
+	samplesPerFrame := 1152
+	sideInfo := 32
+	if version != 3 {
+		samplesPerFrame = 576
+		sideInfo = 17
+	}
+	if channelMode == 3 {
+		// Mono side info is shorter
+		if version == 3 {
+			sideInfo = 17
+		} else {
+			sideInfo = 9
+		}
+	}
+
+	pos := 4 + sideInfo
+	if len(frame) < pos+8 {
+		return nil, false
+	}
+	tag := string(frame[pos : pos+4])
+	if tag != "Xing" && tag != "Info" {
+		return nil, false
+	}
+
+	flags := uint32(frame[pos+4])<<24 | uint32(frame[pos+5])<<16 | uint32(frame[pos+6])<<8 | uint32(frame[pos+7])
+	pos += 8
+
+	info := &MP3GaplessInfo{
+		SampleRate:      rates[rateIndex],
+		SamplesPerFrame: samplesPerFrame,
+	}
+	if flags&0x1 != 0 {
+		if len(frame) < pos+4 {
+			return nil, false
+		}
+		info.Frames = int(uint32(frame[pos])<<24 | uint32(frame[pos+1])<<16 | uint32(frame[pos+2])<<8 | uint32(frame[pos+3]))
+		pos += 4
+	}
+	if flags&0x2 != 0 {
+		pos += 4 // byte count
+	}
+	if flags&0x4 != 0 {
+		pos += 100 // seek table
+	}
+	if flags&0x8 != 0 {
+		pos += 4 // quality
+	}
+
+	// LAME extension: 9 byte encoder string, then delay and padding 12 bits each at offset 21
+	if len(frame) >= pos+24 && info.Frames > 0 {
+		encoder := string(frame[pos : pos+4])
+		if encoder == "LAME" || encoder == "Lavf" || encoder == "Lavc" {
+			b := frame[pos+21 : pos+24]
+			info.EncoderDelay = int(b[0])<<4 | int(b[1])>>4
+			info.Padding = int(b[1]&0x0f)<<8 | int(b[2])
+		}
+	}
+
+	if info.Frames == 0 {
+		return nil, false
+	}
+	return info, true
+}
@@ -0,0 +1,134 @@
+package utils
+
+import (
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// OutputFormatSpec describes how a final output format is encoded and served
+type OutputFormatSpec struct {
+	Extension   string
+	ContentType string
+	Codec       string
+	Bitrate     string   // empty for lossless codecs
+	ExtraArgs   []string // encoder and container options, e.g. the ID3 version
+	SampleRates []int    // rates the codec can encode in ascending order, nil for any
+}
+
+// defaultOutputSampleRate is used when the rate of the source can't be probed
+const defaultOutputSampleRate = 48000
+
+// OutputFormats are the supported output formats by name
+var OutputFormats = map[string]OutputFormatSpec{
+	"mp3": {
+		Extension:   ".mp3",
+		ContentType: "audio/mpeg",
+		Codec:       "libmp3lame",
+		Bitrate:     "320k",
+		ExtraArgs:   []string{"-id3v2_version", "4", "-write_id3v1", "0"},
+		SampleRates: []int{8000, 11025, 12000, 16000, 22050, 24000, 32000, 44100, 48000},
+	},
+	"wav": {
+		Extension:   ".wav",
+		ContentType: "audio/wav",
+		Codec:       "pcm_s24le",
+		ExtraArgs:   []string{"-rf64", "auto"},
+	},
+	"flac": {
+		Extension:   ".flac",
+		ContentType: "audio/flac",
+		Codec:       "flac",
+		ExtraArgs:   []string{"-sample_fmt", "s32"},
+	},
+	"opus": {
+		Extension:   ".opus",
+		ContentType: "audio/ogg",
+		Codec:       "libopus",
+		Bitrate:     "192k",
+		SampleRates: []int{48000},
+	},
+	"m4a": {
+		Extension:   ".m4a",
+		ContentType: "audio/mp4",
+		Codec:       "aac",
+		Bitrate:     "256k",
+		ExtraArgs:   []string{"-movflags", "+faststart+use_metadata_tags"},
+		SampleRates: []int{8000, 11025, 12000, 16000, 22050, 24000, 32000, 44100, 48000, 64000, 88200, 96000},
+	},
+}
+
+// IsValidOutputFormat reports whether format is a supported output format
+func IsValidOutputFormat(format string) bool {
+	_, ok := OutputFormats[format]
+	return ok
+}
+
+// OutputFormatSpecFor returns the spec of a format, falling back to MP3
+func OutputFormatSpecFor(format string) OutputFormatSpec {
+	if spec, ok := OutputFormats[format]; ok {
+		return spec
+	}
+	return OutputFormats["mp3"]
+}
+
+// OutputFormatForFile returns the format name matching a file's extension, falling back to MP3
+func OutputFormatForFile(file string) string {
+	ext := strings.ToLower(filepath.Ext(file))
+	for name, spec := range OutputFormats {
+		if spec.Extension == ext {
+			return name
+		}
+	}
+	return "mp3"
+}
+
+// OutputSampleRate is the rate a format is encoded at for a source of sourceRate: the source
+// rate when the codec supports it, else the closest supported rate below it
+func OutputSampleRate(format string, sourceRate int) int {
+	if sourceRate <= 0 {
+		sourceRate = defaultOutputSampleRate
+	}
+	rates := OutputFormatSpecFor(format).SampleRates
+	if len(rates) == 0 {
+		return sourceRate
+	}
+	rate := rates[0]
+	for _, supported := range rates {
+		if supported <= sourceRate {
+			rate = supported
+		}
+	}
+	return rate
+}
+
+// SourceSampleRate probes the sample rate of a file, or returns 0 when it can't be read
+func SourceSampleRate(file string) int {
+	format, err := ProbeAudioFormat(file)
+	if err != nil {
+		return 0
+	}
+	return format.SampleRate
+}
+
+// OutputCodecArgs returns the ffmpeg encoder arguments for a format at sampleRate, see
+// OutputSampleRate. A non-empty quality overrides the bitrate, or the PCM codec for WAV,
+// like the legacy quality settings.
+func OutputCodecArgs(format, quality string, sampleRate int) []string {
+	spec := OutputFormatSpecFor(format)
+	codec, bitrate := spec.Codec, spec.Bitrate
+	if quality != "" {
+		if format == "wav" {
+			codec = quality
+		} else if bitrate != "" {
+			bitrate = quality
+		}
+	}
+
+	args := []string{"-c:a", codec}
+	if bitrate != "" {
+		args = append(args, "-b:a", bitrate)
+	}
+	args = append(args, "-ar", strconv.Itoa(sampleRate))
+	return append(args, spec.ExtraArgs...)
+}
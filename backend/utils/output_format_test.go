@@ -0,0 +1,52 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestOutputSampleRate(t *testing.T) {
+	tests := []struct {
+		format     string
+		sourceRate int
+		want       int
+	}{
+		{"wav", 44100, 44100},
+		{"wav", 96000, 96000},
+		{"flac", 192000, 192000},
+		{"mp3", 44100, 44100},
+		{"mp3", 96000, 48000},
+		{"mp3", 88200, 48000},
+		{"m4a", 96000, 96000},
+		{"m4a", 192000, 96000},
+		{"opus", 44100, 48000},
+		{"opus", 96000, 48000},
+		{"mp3", 0, 48000},
+		{"wav", 0, 48000},
+		{"mp3", 4000, 8000},
+	}
+	for _, test := range tests {
+		if got := OutputSampleRate(test.format, test.sourceRate); got != test.want {
+			t.Errorf("OutputSampleRate(%s, %d) = %d, want %d", test.format, test.sourceRate, got, test.want)
+		}
+	}
+}
+
+func TestLimiterResamplesToOutputRate(t *testing.T) {
+	filter := NewLimiter(-1).Filter(44100)
+	stages := strings.Split(filter, ",")
+	if len(stages) != 5 {
+		t.Fatalf("limiter has %d stages, want 5: %s", len(stages), filter)
+	}
+	if stages[1] != "aresample=192000" || !strings.HasPrefix(stages[2], "alimiter=") {
+		t.Errorf("limiter doesn't run oversampled: %s", filter)
+	}
+	if stages[3] != "aresample=44100" {
+		t.Errorf("limiter resamples with %s, want aresample=44100", stages[3])
+	}
+
+	args := strings.Join(OutputCodecArgs("wav", "", 44100), " ")
+	if !strings.Contains(args, "-ar 44100") {
+		t.Errorf("codec args %q don't encode at the limiter rate", args)
+	}
+}
@@ -0,0 +1,102 @@
+package utils
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// OutputTags is everything written into the final output besides the audio
+type OutputTags struct {
+	Metadata Metadata
+	Cover    *CoverArt     // may be nil
+	Chapters []TrackMarker // finished timeline, see FinishTimeline
+}
+
+// FFmpegArgs returns the extra inputs and the output options that write the tags; the audio
+// is input 0. Metadata becomes ID3v2.4 frames for MP3, Vorbis comments for FLAC and Opus,
+// MP4 atoms for M4A and RIFF INFO for WAV.
+//
+// MP3, FLAC and M4A take the cover as an attached picture stream, which ffmpeg writes as an
+// ID3 APIC frame, a FLAC PICTURE block and a covr atom. Ogg has no picture streams, so for
+// Opus the cover goes in as a METADATA_BLOCK_PICTURE comment through an ffmetadata file,
+// which also carries the chapters (ID3 CHAP/CTOC, MP4 chapters, Vorbis CHAPTERxxx).
+// WAV has no cover or chapter field.
+func (ot OutputTags) FFmpegArgs(format, dir string) (inputs, outputs []string, err error) {
+	next := 1
+	if ot.Cover != nil && (format == "mp3" || format == "flac" || format == "m4a") {
+		inputs = append(inputs, "-i", ot.Cover.Path)
+		outputs = append(outputs,
+			"-map", strconv.Itoa(next)+":v",
+			"-c:v", "copy",
+			"-disposition:v:0", "attached_pic",
+			"-metadata:s:v", "title=Album cover",
+			"-metadata:s:v", "comment=Cover (front)")
+		next++
+	}
+
+	picture := ot.Cover != nil && format == "opus"
+	chapters := len(ot.Chapters) > 0 && format != "wav"
+	if picture || chapters {
+		metadataFile, err := ot.writeFFMetadata(filepath.Join(dir, "tags.ffmeta"), picture, chapters)
+		if err != nil {
+			return nil, nil, err
+		}
+		inputs = append(inputs, "-f", "ffmetadata", "-i", metadataFile)
+		if picture {
+			outputs = append(outputs, "-map_metadata", strconv.Itoa(next))
+		}
+		if chapters {
+			outputs = append(outputs, "-map_chapters", strconv.Itoa(next))
+		}
+	}
+
+	if len(inputs) > 0 {
+		outputs = append([]string{"-map", "0:a"}, outputs...)
+	}
+	return inputs, append(outputs, ot.Metadata.FFmpegArgs(format)...), nil
+}
+
+// writeFFMetadata writes the ffmetadata file. The base64 picture is far longer than a
+// single command-line argument may be, so it can't go through -metadata.
+func (ot OutputTags) writeFFMetadata(path string, picture, chapters bool) (string, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	w := bufio.NewWriter(file)
+	fmt.Fprintln(w, ";FFMETADATA1")
+	if picture {
+		block, err := ot.Cover.PictureBlock()
+		if err != nil {
+			return "", fmt.Errorf("failed to read cover art: %v", err)
+		}
+		fmt.Fprintf(w, "METADATA_BLOCK_PICTURE=%s\n", escapeFFMetadata(base64.StdEncoding.EncodeToString(block)))
+	}
+	if chapters {
+		w.WriteString(FFMetadataChapters(ot.Chapters))
+	}
+	if err := w.Flush(); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// WriteChunks adds the tags ffmpeg can't write for a format: the iXML chunk for WAV and
+// the CUESHEET block for FLAC
+func (ot OutputTags) WriteChunks(file, format string) error {
+	switch format {
+	case "wav":
+		return ot.Metadata.WriteMetadataChunks(file, format)
+	case "flac":
+		if len(ot.Chapters) > 0 {
+			return WriteFLACCueSheet(file, ot.Chapters)
+		}
+	}
+	return nil
+}
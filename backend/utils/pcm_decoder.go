@@ -0,0 +1,105 @@
+package utils
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os/exec"
+	"strconv"
+)
+
+// pcmBlockFrames is the number of frames passed to a StreamPCM callback at once
+const pcmBlockFrames = 8192
+
+// StreamPCM decodes an audio file with ffmpeg and passes interleaved float samples to fn block by block
+func StreamPCM(file string, sampleRate, channels int, fn func(samples []float32) error) error {
+	return streamPCM([]string{"-i", file}, sampleRate, channels, fn)
+}
+
+// streamPCM runs the decoder on the given input arguments, e.g. with a seek before -i
+func streamPCM(input []string, sampleRate, channels int, fn func(samples []float32) error) error {
+	args := append([]string{"-v", "error"}, input...)
+	args = append(args,
+		"-f", "f32le",
+		"-acodec", "pcm_f32le",
+		"-ac", strconv.Itoa(channels),
+		"-ar", strconv.Itoa(sampleRate),
+		"pipe:1")
+	cmd := exec.Command("ffmpeg", args...)
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return fmt.Errorf("failed to open decoder pipe: %v", err)
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start ffmpeg decoder: %v", err)
+	}
+
+	reader := bufio.NewReaderSize(stdout, 1<<16)
+	buf := make([]byte, pcmBlockFrames*channels*4)
+	samples := make([]float32, pcmBlockFrames*channels)
+
+	for {
+		n, readErr := io.ReadFull(reader, buf)
+		count := n / 4
+		for i := 0; i < count; i++ {
+			samples[i] = math.Float32frombits(binary.LittleEndian.Uint32(buf[i*4:]))
+		}
+		if count > 0 {
+			if err := fn(samples[:count]); err != nil {
+				cmd.Process.Kill()
+				cmd.Wait()
+				return err
+			}
+		}
+		if readErr == io.EOF || readErr == io.ErrUnexpectedEOF {
+			break
+		}
+		if readErr != nil {
+			cmd.Process.Kill()
+			cmd.Wait()
+			return fmt.Errorf("failed to read decoded audio: %v", readErr)
+		}
+	}
+
+	if err := cmd.Wait(); err != nil {
+		return fmt.Errorf("ffmpeg decode error: %v\nOutput: %s", err, stderr.String())
+	}
+	return nil
+}
+
+// DecodePCM decodes a whole audio file into interleaved float samples
+func DecodePCM(file string, sampleRate, channels int) ([]float32, error) {
+	var pcm []float32
+	err := StreamPCM(file, sampleRate, channels, func(samples []float32) error {
+		pcm = append(pcm, samples...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return pcm, nil
+}
+
+// DecodePCMRange decodes duration seconds of an audio file from start into interleaved float samples
+func DecodePCMRange(file string, sampleRate, channels int, start, duration float64) ([]float32, error) {
+	input := []string{
+		"-ss", fmt.Sprintf("%.6f", start),
+		"-t", fmt.Sprintf("%.6f", duration),
+		"-i", file,
+	}
+	var pcm []float32
+	err := streamPCM(input, sampleRate, channels, func(samples []float32) error {
+		pcm = append(pcm, samples...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return pcm, nil
+}
@@ -0,0 +1,305 @@
+package utils
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// SessionIDPattern limits session IDs to names that are safe as directory and key names
+var SessionIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// ResultStore keeps the finished output of every job, with its sidecar files, under
+// <Prefix>/<session>/ in the storage until the retention period has passed. Jobs write
+// into a local session directory: the storage itself when it is on this machine, or a
+// staging directory under WorkDir that Publish uploads.
+type ResultStore struct {
+	Storage   Storage
+	Prefix    string
+	WorkDir   string
+	Retention time.Duration
+}
+
+// NewResultStore creates a new result store
+func NewResultStore(storage Storage, prefix, workDir string, retention time.Duration) *ResultStore {
+	return &ResultStore{
+		Storage:   storage,
+		Prefix:    prefix,
+		WorkDir:   workDir,
+		Retention: retention,
+	}
+}
+
+// GlobalResultStore is the shared result store under the output prefix
+var GlobalResultStore = NewResultStore(GlobalStorage, "output", filepath.Join(GlobalConfig.WorkDir, "results"), GlobalConfig.ResultRetention)
+
+// key is the storage key of a file in a session's results
+func (rs *ResultStore) key(sessionID, name string) string {
+	return path.Join(rs.Prefix, sessionID, name)
+}
+
+// localDir is the directory jobs write a session's results to
+func (rs *ResultStore) localDir(sessionID string) string {
+	if dir, ok := LocalPath(rs.Storage, rs.key(sessionID, "")); ok {
+		return dir
+	}
+	return filepath.Join(rs.WorkDir, sessionID)
+}
+
+// SessionDir creates and returns the local result directory of a session
+func (rs *ResultStore) SessionDir(sessionID string) (string, error) {
+	dir := rs.localDir(sessionID)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create result directory: %v", err)
+	}
+	return dir, nil
+}
+
+// Publish uploads the session directory to the storage and removes the staging copy.
+// Results written straight into local storage are already in place.
+func (rs *ResultStore) Publish(sessionID string) error {
+	if _, ok := LocalPath(rs.Storage, rs.key(sessionID, "")); ok {
+		return nil
+	}
+
+	dir := rs.localDir(sessionID)
+	err := filepath.WalkDir(dir, func(file string, entry fs.DirEntry, err error) error {
+		if err != nil || !entry.Type().IsRegular() {
+			return err
+		}
+		name, err := filepath.Rel(dir, file)
+		if err != nil {
+			return err
+		}
+		return PutFile(rs.Storage, rs.key(sessionID, filepath.ToSlash(name)), file)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to publish results: %v", err)
+	}
+	return os.RemoveAll(dir)
+}
+
+// ResultFile is the finished output of a job in the result store
+type ResultFile struct {
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// DownloadName is the file name offered to clients, derived from the content hash so
+// different mixes never share a name
+func (rf ResultFile) DownloadName() string {
+	return "mixloop_" + rf.SHA256[:16] + filepath.Ext(rf.Name)
+}
+
+// DescribeOutput hashes the output in the session directory for its ETag and download
+// name; it runs before Publish
+func (rs *ResultStore) DescribeOutput(sessionID, outputName string) (*ResultFile, error) {
+	file := filepath.Join(rs.localDir(sessionID), outputName)
+	info, err := os.Stat(file)
+	if err != nil {
+		return nil, fmt.Errorf("output not found: %v", err)
+	}
+	hash, err := FileSHA256(file)
+	if err != nil {
+		return nil, err
+	}
+	return &ResultFile{Name: outputName, Size: info.Size(), SHA256: hash}, nil
+}
+
+// ServeOutput streams the output of a session from disk with http.ServeContent, which
+// sets Content-Length and answers Range, If-Range and If-None-Match against the ETag.
+// Remote storages redirect to a presigned URL, which answers Range requests itself.
+func (rs *ResultStore) ServeOutput(w http.ResponseWriter, r *http.Request, sessionID string, result ResultFile) {
+	key := rs.key(sessionID, result.Name)
+	if _, ok := LocalPath(rs.Storage, key); ok {
+		w.Header().Set("Content-Type", OutputFormatSpecFor(OutputFormatForFile(result.Name)).ContentType)
+		w.Header().Set("Content-Disposition", "attachment; filename="+result.DownloadName())
+		w.Header().Set("ETag", `"`+result.SHA256+`"`)
+	}
+	ServeObject(w, r, rs.Storage, key, result.DownloadName())
+}
+
+// ServeFile serves any other file of a session, such as a stream segment
+func (rs *ResultStore) ServeFile(w http.ResponseWriter, r *http.Request, sessionID, name string) {
+	ServeObject(w, r, rs.Storage, rs.key(sessionID, name), "")
+}
+
+// WriteSidecars writes the CUE sheet and the extended M3U playlist next to the output
+func (rs *ResultStore) WriteSidecars(sessionID, outputName string, report *JobReport, metadata Metadata) error {
+	dir, err := rs.SessionDir(sessionID)
+	if err != nil {
+		return err
+	}
+
+	report.mu.Lock()
+	markers, cueSheet := report.Tracklist, report.CueSheet
+	report.mu.Unlock()
+	if len(markers) == 0 {
+		return nil
+	}
+
+	base := strings.TrimSuffix(outputName, filepath.Ext(outputName))
+	if err := os.WriteFile(filepath.Join(dir, base+".cue"), []byte(cueSheet), 0644); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, base+".m3u8"), []byte(M3U8Playlist(markers, metadata)), 0644)
+}
+
+// SaveReport stores the job report with the results, so every instance can answer for it
+func (rs *ResultStore) SaveReport(report *JobReport) error {
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	return rs.Storage.Put(rs.key(report.SessionID, "report.json"), bytes.NewReader(data), int64(len(data)))
+}
+
+// LoadReport reads a job report saved by SaveReport
+func (rs *ResultStore) LoadReport(sessionID string) (*JobReport, error) {
+	src, _, err := rs.Storage.Get(rs.key(sessionID, "report.json"))
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+
+	var report JobReport
+	if err := json.NewDecoder(src).Decode(&report); err != nil {
+		return nil, fmt.Errorf("corrupt job report: %v", err)
+	}
+	return &report, nil
+}
+
+// WriteZip streams the session's files and the job report as a zip archive. Audio is
+// stored as is, since it doesn't compress any further.
+func (rs *ResultStore) WriteZip(w io.Writer, report *JobReport) error {
+	prefix := rs.key(report.SessionID, "") + "/"
+	objects, err := rs.Storage.List(prefix)
+	if err != nil {
+		return fmt.Errorf("result files not found: %v", err)
+	}
+	if len(objects) == 0 {
+		return fmt.Errorf("result files not found")
+	}
+
+	archive := zip.NewWriter(w)
+	for _, object := range objects {
+		name := strings.TrimPrefix(object.Key, prefix)
+		if strings.Contains(name, "/") || name == "report.json" {
+			continue
+		}
+		if err := rs.addZipObject(archive, object, name); err != nil {
+			return err
+		}
+	}
+
+	reportFile, err := archive.Create("report.json")
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(reportFile)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(report); err != nil {
+		return err
+	}
+	return archive.Close()
+}
+
+// HasStems reports whether stems were exported for a session
+func (rs *ResultStore) HasStems(sessionID string) bool {
+	_, err := rs.Storage.Stat(rs.key(sessionID, "stems/manifest.json"))
+	return err == nil
+}
+
+// WriteStemsZip streams the session's stems directory, manifest included, as a zip archive
+func (rs *ResultStore) WriteStemsZip(w io.Writer, sessionID string) error {
+	prefix := rs.key(sessionID, "stems") + "/"
+	objects, err := rs.Storage.List(prefix)
+	if err != nil {
+		return err
+	}
+
+	archive := zip.NewWriter(w)
+	for _, object := range objects {
+		if err := rs.addZipObject(archive, object, strings.TrimPrefix(object.Key, prefix)); err != nil {
+			return err
+		}
+	}
+	return archive.Close()
+}
+
+func (rs *ResultStore) addZipObject(archive *zip.Writer, object ObjectInfo, name string) error {
+	header := &zip.FileHeader{
+		Name:     name,
+		Method:   zip.Deflate,
+		Modified: object.ModTime,
+	}
+	ext := strings.ToLower(path.Ext(name))
+	for _, spec := range OutputFormats {
+		if spec.Extension == ext {
+			header.Method = zip.Store
+		}
+	}
+
+	dst, err := archive.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+	src, _, err := rs.Storage.Get(object.Key)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	_, err = io.Copy(dst, src)
+	return err
+}
+
+// Cleanup removes the results and job reports of sessions whose newest file is older
+// than the retention period
+func (rs *ResultStore) Cleanup() {
+	objects, err := rs.Storage.List(rs.Prefix + "/")
+	if err != nil {
+		log.Printf("Failed to list results: %v", err)
+		return
+	}
+
+	newest := make(map[string]time.Time)
+	for _, object := range objects {
+		sessionID, _, _ := strings.Cut(strings.TrimPrefix(object.Key, rs.Prefix+"/"), "/")
+		if object.ModTime.After(newest[sessionID]) {
+			newest[sessionID] = object.ModTime
+		}
+	}
+
+	cutoff := time.Now().Add(-rs.Retention)
+	for sessionID, modTime := range newest {
+		if modTime.After(cutoff) {
+			continue
+		}
+		if err := DeletePrefix(rs.Storage, rs.key(sessionID, "")+"/"); err != nil {
+			log.Printf("Failed to remove expired result %s: %v", sessionID, err)
+			continue
+		}
+		GlobalJobStore.Delete(sessionID)
+	}
+}
+
+// StartCleanup runs Cleanup every interval in the background
+func (rs *ResultStore) StartCleanup(interval time.Duration) {
+	go func() {
+		for range time.Tick(interval) {
+			rs.Cleanup()
+		}
+	}()
+}
@@ -0,0 +1,155 @@
+package utils
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// discardWriter is a ResponseWriter that counts the body and keeps only its first bytes, so
+// the test itself doesn't hold the download in memory
+type discardWriter struct {
+	header  http.Header
+	status  int
+	written int64
+	head    []byte
+}
+
+func newDiscardWriter() *discardWriter {
+	return &discardWriter{header: make(http.Header)}
+}
+
+func (w *discardWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *discardWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+func (w *discardWriter) Write(p []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	if room := 64 - len(w.head); room > 0 {
+		w.head = append(w.head, p[:min(room, len(p))]...)
+	}
+	w.written += int64(len(p))
+	return len(p), nil
+}
+
+// useResultStore points the global result store at a temporary local storage for one test
+func useResultStore(t *testing.T) *ResultStore {
+	t.Helper()
+	previous := GlobalResultStore
+	GlobalResultStore = NewResultStore(NewLocalStorage(t.TempDir()), "output", t.TempDir(), time.Hour)
+	t.Cleanup(func() { GlobalResultStore = previous })
+	return GlobalResultStore
+}
+
+func TestResultHandlerStreamsLargeOutput(t *testing.T) {
+	if testing.Short() {
+		t.Skip("reads a 5 GB sparse file")
+	}
+	store := useResultStore(t)
+	const sessionID = "0123456789abcdef0123456789abcdef"
+	const size = 5 << 30 // past 4 GB, like an RF64 WAV
+	marker := []byte("end of mix")
+
+	dir, err := store.SessionDir(sessionID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.Create(filepath.Join(dir, "mixloop_output.wav"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Sparse, so the fixture takes no disk space
+	if err := file.Truncate(size); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := file.WriteAt(marker, size-int64(len(marker))); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	report := NewJobReport(sessionID)
+	hash := strings.Repeat("ab", 32)
+	report.SetOutput(ResultFile{Name: "mixloop_output.wav", Size: size, SHA256: hash})
+	GlobalJobStore.Save(report)
+	t.Cleanup(func() { GlobalJobStore.Delete(sessionID) })
+	etag := `"` + hash + `"`
+
+	get := func(headers map[string]string) *discardWriter {
+		r := httptest.NewRequest(http.MethodGet, "/api/result?format=audio&session_id="+sessionID, nil)
+		for name, value := range headers {
+			r.Header.Set(name, value)
+		}
+		w := newDiscardWriter()
+		ResultHandler(w, r)
+		return w
+	}
+
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	full := get(nil)
+	runtime.ReadMemStats(&after)
+
+	if full.status != http.StatusOK || full.written != size {
+		t.Fatalf("full download: status %d with %d bytes, want 200 with %d", full.status, full.written, int64(size))
+	}
+	if got := full.header.Get("Content-Length"); got != strconv.Itoa(size) {
+		t.Errorf("Content-Length = %s, want %d", got, size)
+	}
+	if got := full.header.Get("ETag"); got != etag {
+		t.Errorf("ETag = %s, want %s", got, etag)
+	}
+	if got := full.header.Get("Content-Disposition"); got != "attachment; filename=mixloop_"+hash[:16]+".wav" {
+		t.Errorf("Content-Disposition = %s", got)
+	}
+	// Streaming copies through a small buffer; reading the file into memory would allocate gigabytes
+	if allocated := after.TotalAlloc - before.TotalAlloc; allocated > 64<<20 {
+		t.Errorf("download allocated %d MB", allocated>>20)
+	}
+
+	// A resumed download gets the tail of the file
+	tail := get(map[string]string{"Range": "bytes=" + strconv.Itoa(size-len(marker)) + "-"})
+	if tail.status != http.StatusPartialContent || string(tail.head) != string(marker) {
+		t.Errorf("range request: status %d with %q, want 206 with %q", tail.status, tail.head, marker)
+	}
+	if got, want := tail.header.Get("Content-Range"), "bytes "+strconv.Itoa(size-len(marker))+"-"+strconv.Itoa(size-1)+"/"+strconv.Itoa(size); got != want {
+		t.Errorf("Content-Range = %s, want %s", got, want)
+	}
+
+	// If-Range with the current ETag resumes, a stale one restarts the download
+	resumed := get(map[string]string{"Range": "bytes=0-99", "If-Range": etag})
+	if resumed.status != http.StatusPartialContent || resumed.written != 100 {
+		t.Errorf("If-Range with the current ETag: status %d with %d bytes, want 206 with 100", resumed.status, resumed.written)
+	}
+	restarted := get(map[string]string{"Range": "bytes=0-99", "If-Range": `"stale"`})
+	if restarted.status != http.StatusOK || restarted.written != size {
+		t.Errorf("If-Range with a stale ETag: status %d with %d bytes, want 200 with the whole file", restarted.status, restarted.written)
+	}
+
+	if cached := get(map[string]string{"If-None-Match": etag}); cached.status != http.StatusNotModified || cached.written != 0 {
+		t.Errorf("If-None-Match: status %d with %d bytes, want 304 without a body", cached.status, cached.written)
+	}
+}
+
+func TestResultHandlerRejectsUnsafeSessionIDs(t *testing.T) {
+	useResultStore(t)
+	for _, sessionID := range []string{"../etc", "a/b", strings.Repeat("a", 65)} {
+		w := httptest.NewRecorder()
+		ResultHandler(w, httptest.NewRequest(http.MethodGet, "/api/result?session_id="+sessionID, nil))
+		if w.Code != http.StatusNotFound {
+			t.Errorf("session %q: status %d, want 404", sessionID, w.Code)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package utils
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// minLoopConfidence is the score below which a seam would likely be audible,
+	// so the crossfade loop is used instead
+	minLoopConfidence  = 0.3
+	seamlessSampleRate = 48000
+)
+
+// createSeamlessLoop repeats the detected loop region of a single input without crossfades:
+// the head up to the out point, the loop body LoopCount-2 times, then the tail from the in point
+func (as *AudioSequencer) createSeamlessLoop(sequenceFile string) error {
+	points, err := FindLoopPoints(as.InputFiles[0])
+	if err != nil {
+		return fmt.Errorf("loop point detection failed: %v", err)
+	}
+
+	points.Used = points.Confidence >= minLoopConfidence
+	if as.Options.Report != nil {
+		as.Options.Report.SetLoopPoints(*points)
+	}
+	if !points.Used {
+		return as.createLoopedSequence(sequenceFile)
+	}
+
+	start := int64(math.Round(points.Start * seamlessSampleRate))
+	end := int64(math.Round(points.End * seamlessSampleRate))
+
+	// The second iteration starts at the out point, every later one a loop body further;
+	// they all play the track from the in point
+	timeline := ShiftMarkers(as.Timeline, 0, 1)
+	for loop := 2; loop <= as.LoopCount; loop++ {
+		offset := float64(end+int64(loop-2)*(end-start)) / seamlessSampleRate
+		iteration := ShiftMarkers(as.Timeline, offset, loop)
+		for i := range iteration {
+			iteration[i].SourceStart = points.Start
+		}
+		timeline = append(timeline, iteration...)
+	}
+	as.Timeline = timeline
+
+	segments := []struct {
+		name   string
+		filter string
+	}{
+		{"loop_head.wav", fmt.Sprintf("atrim=end_sample=%d", end)},
+		{"loop_body.wav", fmt.Sprintf("atrim=start_sample=%d:end_sample=%d", start, end)},
+		{"loop_tail.wav", fmt.Sprintf("atrim=start_sample=%d", start)},
+	}
+
+	paths := make(map[string]string)
+	for _, segment := range segments {
+		path := filepath.Join(as.TempDir, segment.name)
+		cmd := exec.Command("ffmpeg",
+			"-i", as.InputFiles[0],
+			"-af", fmt.Sprintf("aresample=%d,%s,asetpts=PTS-STARTPTS", seamlessSampleRate, segment.filter),
+			"-c:a", "pcm_s24le",
+			"-y", path)
+
+		output, err := cmd.CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("ffmpeg loop segment error: %v\nOutput: %s", err, output)
+		}
+		paths[segment.name] = path
+		defer os.Remove(path)
+	}
+
+	// PCM segments in the concat demuxer join sample-accurately
+	var concatContent strings.Builder
+	concatContent.WriteString(fmt.Sprintf("file '%s'\n", paths["loop_head.wav"]))
+	for i := 0; i < as.LoopCount-2; i++ {
+		concatContent.WriteString(fmt.Sprintf("file '%s'\n", paths["loop_body.wav"]))
+	}
+	concatContent.WriteString(fmt.Sprintf("file '%s'\n", paths["loop_tail.wav"]))
+
+	concatFile := filepath.Join(as.TempDir, "loop_list.txt")
+	if err := os.WriteFile(concatFile, []byte(concatContent.String()), 0644); err != nil {
+		return fmt.Errorf("failed to create concat file: %v", err)
+	}
+	defer os.Remove(concatFile)
+
+	args := []string{"-f", "concat", "-safe", "0", "-i", concatFile}
+	args = append(args, as.intermediateCodecArgs()...)
+	cmd := exec.Command("ffmpeg", append(args, "-y", filepath.Join(as.TempDir, "looped"+as.intermediateExt()))...)
+
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("ffmpeg seamless loop error: %v\nOutput: %s", err, output)
+	}
+
+	return nil
+}
@@ -0,0 +1,142 @@
+package utils
+
+import (
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strconv"
+)
+
+// silenceEdgeTolerance is how close (in seconds) a silence must be to a file edge to count as leading or trailing
+const silenceEdgeTolerance = 0.01
+
+var (
+	silenceStartPattern = regexp.MustCompile(`silence_start: (-?[0-9.]+)`)
+	silenceEndPattern   = regexp.MustCompile(`silence_end: (-?[0-9.]+)`)
+)
+
+// SilenceSpan is a silent region of a file in seconds
+type SilenceSpan struct {
+	Start float64
+	End   float64
+}
+
+// TrimResult describes how much silence was removed from one input
+type TrimResult struct {
+	Index    int     `json:"index"`
+	Name     string  `json:"name"`
+	Duration float64 `json:"duration"`
+	Leading  float64 `json:"leading"`
+	Trailing float64 `json:"trailing"`
+}
+
+// SilenceTrimmer removes digital silence from the head and tail of a file
+type SilenceTrimmer struct {
+	ThresholdDB float64
+	MinDuration float64
+}
+
+// NewSilenceTrimmer creates a new silence trimmer
+func NewSilenceTrimmer(thresholdDB, minDuration float64) *SilenceTrimmer {
+	if thresholdDB >= 0 {
+		thresholdDB = -50
+	}
+	if minDuration <= 0 {
+		minDuration = 0.1
+	}
+	return &SilenceTrimmer{
+		ThresholdDB: thresholdDB,
+		MinDuration: minDuration,
+	}
+}
+
+// DetectSilences returns the silent regions of a file using ffmpeg's silencedetect
+func DetectSilences(file string, thresholdDB, minDuration float64) ([]SilenceSpan, error) {
+	cmd := exec.Command("ffmpeg",
+		"-i", file,
+		"-af", fmt.Sprintf("silencedetect=noise=%.1fdB:d=%.3f", thresholdDB, minDuration),
+		"-f", "null", "-")
+
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("ffmpeg silencedetect error: %v\nOutput: %s", err, output)
+	}
+
+	var spans []SilenceSpan
+	starts := silenceStartPattern.FindAllSubmatch(output, -1)
+	ends := silenceEndPattern.FindAllSubmatch(output, -1)
+	for i, match := range starts {
+		start, _ := strconv.ParseFloat(string(match[1]), 64)
+		// A silence that runs into the end of the file may have no silence_end line
+		end := -1.0
+		if i < len(ends) {
+			end, _ = strconv.ParseFloat(string(ends[i][1]), 64)
+		}
+		spans = append(spans, SilenceSpan{Start: start, End: end})
+	}
+
+	return spans, nil
+}
+
+// Detect measures the leading and trailing silence of a file
+func (st *SilenceTrimmer) Detect(file string) (*TrimResult, error) {
+	duration, err := GetAudioDuration(file)
+	if err != nil {
+		return nil, err
+	}
+
+	spans, err := DetectSilences(file, st.ThresholdDB, st.MinDuration)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &TrimResult{Duration: duration}
+	if len(spans) == 0 {
+		return result, nil
+	}
+
+	first := spans[0]
+	if first.Start <= silenceEdgeTolerance {
+		if first.End < 0 {
+			// The whole file is silent, leave it alone
+			return result, nil
+		}
+		result.Leading = first.End
+	}
+
+	last := spans[len(spans)-1]
+	if last.End < 0 || last.End >= duration-silenceEdgeTolerance {
+		if last.Start > result.Leading {
+			result.Trailing = duration - last.Start
+		}
+	}
+
+	return result, nil
+}
+
+// Trim writes a copy of inputFile without its leading and trailing silence.
+// It returns the file to use for sequencing, which is inputFile itself when nothing was trimmed.
+func (st *SilenceTrimmer) Trim(inputFile, outputFile string) (string, *TrimResult, error) {
+	result, err := st.Detect(inputFile)
+	if err != nil {
+		return "", nil, err
+	}
+
+	if result.Leading == 0 && result.Trailing == 0 {
+		return inputFile, result, nil
+	}
+
+	end := result.Duration - result.Trailing
+	cmd := exec.Command("ffmpeg",
+		"-i", inputFile,
+		"-af", fmt.Sprintf("atrim=start=%.6f:end=%.6f,asetpts=PTS-STARTPTS", result.Leading, end),
+		"-c:a", "pcm_s24le",
+		"-y", outputFile)
+
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return "", nil, fmt.Errorf("ffmpeg trim error: %v\nOutput: %s", err, output)
+	}
+
+	return outputFile, result, nil
+}
@@ -0,0 +1,165 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+)
+
+// StemManifest describes the exported stems and where they sit in the mix
+type StemManifest struct {
+	Output     string          `json:"output"`
+	Duration   float64         `json:"duration"`
+	Tracks     []StemTrack     `json:"tracks"`
+	Crossfades []StemCrossfade `json:"crossfades"`
+}
+
+// StemTrack is one prepared input and every place it plays in the mix
+type StemTrack struct {
+	File       string          `json:"file"`
+	Index      int             `json:"index"` // upload index
+	Name       string          `json:"name"`
+	Duration   float64         `json:"duration"`
+	Placements []StemPlacement `json:"placements"`
+}
+
+// StemPlacement is one appearance of a track: Length seconds of the stem from SourceStart
+// play in the mix from Start, including the crossfades in and out
+type StemPlacement struct {
+	Loop        int     `json:"loop"`
+	Start       float64 `json:"start"`
+	SourceStart float64 `json:"source_start"`
+	Length      float64 `json:"length"`
+}
+
+// StemCrossfade is a transition cut from the mix into its own file
+type StemCrossfade struct {
+	File     string  `json:"file"`
+	Loop     int     `json:"loop"`
+	From     int     `json:"from"` // upload index of the outgoing track
+	To       int     `json:"to"`   // upload index of the incoming track
+	Start    float64 `json:"start"`
+	Duration float64 `json:"duration"`
+}
+
+// StemTrackFile is the path of a track stem relative to the stems directory
+func StemTrackFile(index int) string {
+	return filepath.Join("tracks", fmt.Sprintf("track_%03d.wav", index+1))
+}
+
+// exportStems writes every prepared input to the stems directory as 24-bit WAV
+func (tp *TrackPreparer) exportStems(files []string) error {
+	if err := os.MkdirAll(filepath.Join(tp.Options.StemsDir, "tracks"), 0755); err != nil {
+		return fmt.Errorf("failed to create stems directory: %v", err)
+	}
+
+	for i, file := range files {
+		stem := filepath.Join(tp.Options.StemsDir, StemTrackFile(tp.Options.InputIndex(i)))
+		cmd := exec.Command("ffmpeg",
+			"-i", file,
+			"-map", "0:a",
+			"-c:a", "pcm_s24le",
+			"-rf64", "auto",
+			"-y", stem)
+
+		output, err := cmd.CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("ffmpeg stem export error for file %d: %v\nOutput: %s", i+1, err, output)
+		}
+	}
+	return nil
+}
+
+// exportStemManifest cuts every crossfade out of the final output and writes the manifest
+// that places the track stems written during preparation
+func (as *AudioSequencer) exportStemManifest() error {
+	dir := as.Options.StemsDir
+	if err := os.MkdirAll(filepath.Join(dir, "crossfades"), 0755); err != nil {
+		return fmt.Errorf("failed to create stems directory: %v", err)
+	}
+
+	duration, err := GetAudioDuration(as.OutputFile)
+	if err != nil {
+		return fmt.Errorf("failed to get mix duration: %v", err)
+	}
+	manifest := StemManifest{
+		Output:     as.Options.OutputName,
+		Duration:   duration,
+		Tracks:     []StemTrack{},
+		Crossfades: []StemCrossfade{},
+	}
+	if manifest.Output == "" {
+		manifest.Output = filepath.Base(as.OutputFile)
+	}
+
+	tracks := make(map[int]*StemTrack)
+	for i, marker := range as.Timeline {
+		// A track keeps playing through the crossfade into the next one
+		end := duration
+		if i+1 < len(as.Timeline) {
+			end = as.Timeline[i+1].Start + as.Timeline[i+1].Fade
+		}
+
+		track, ok := tracks[marker.Index]
+		if !ok {
+			track = &StemTrack{File: StemTrackFile(marker.Index), Index: marker.Index, Name: marker.Name}
+			if track.Duration, err = GetAudioDuration(filepath.Join(dir, track.File)); err != nil {
+				return fmt.Errorf("missing stem for track %d: %v", marker.Index+1, err)
+			}
+			tracks[marker.Index] = track
+		}
+		track.Placements = append(track.Placements, StemPlacement{
+			Loop:        marker.Loop,
+			Start:       marker.Start,
+			SourceStart: marker.SourceStart,
+			Length:      roundMillis(end - marker.Start),
+		})
+
+		if i == 0 || marker.Fade <= 0 {
+			continue
+		}
+		crossfade := StemCrossfade{
+			File:     filepath.Join("crossfades", fmt.Sprintf("crossfade_%03d.wav", len(manifest.Crossfades)+1)),
+			Loop:     marker.Loop,
+			From:     as.Timeline[i-1].Index,
+			To:       marker.Index,
+			Start:    marker.Start,
+			Duration: marker.Fade,
+		}
+		if err := as.cutRegion(crossfade.Start, crossfade.Duration, filepath.Join(dir, crossfade.File)); err != nil {
+			return err
+		}
+		manifest.Crossfades = append(manifest.Crossfades, crossfade)
+	}
+
+	for _, track := range tracks {
+		manifest.Tracks = append(manifest.Tracks, *track)
+	}
+	sort.Slice(manifest.Tracks, func(i, j int) bool { return manifest.Tracks[i].Index < manifest.Tracks[j].Index })
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, "manifest.json"), data, 0644)
+}
+
+// cutRegion writes duration seconds of the output from start to a 24-bit WAV file
+func (as *AudioSequencer) cutRegion(start, duration float64, outputFile string) error {
+	cmd := exec.Command("ffmpeg",
+		"-ss", fmt.Sprintf("%.3f", start),
+		"-t", fmt.Sprintf("%.3f", duration),
+		"-i", as.OutputFile,
+		"-map", "0:a",
+		"-c:a", "pcm_s24le",
+		"-y", outputFile)
+
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("ffmpeg region cut error: %v\nOutput: %s", err, output)
+	}
+	return nil
+}
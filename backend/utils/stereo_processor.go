@@ -0,0 +1,231 @@
+package utils
+
+import (
+	"fmt"
+	"math"
+	"os/exec"
+	"path/filepath"
+)
+
+// Stereo modes applied to the finished sequence
+const (
+	StereoModePassthrough     = "passthrough"
+	StereoModeMono            = "mono"
+	StereoModeWiden           = "widen"
+	StereoModeMidSideEQ       = "mid_side_eq"
+	StereoModeHaas            = "haas"
+	StereoModeMonoCompatCheck = "mono_compatible_check"
+)
+
+const (
+	correlationSampleRate    = 22050
+	correlationWindowSeconds = 0.4
+)
+
+var stereoModes = []string{
+	StereoModePassthrough,
+	StereoModeMono,
+	StereoModeWiden,
+	StereoModeMidSideEQ,
+	StereoModeHaas,
+	StereoModeMonoCompatCheck,
+}
+
+// IsValidStereoMode reports whether mode is a supported stereo mode
+func IsValidStereoMode(mode string) bool {
+	for _, valid := range stereoModes {
+		if mode == valid {
+			return true
+		}
+	}
+	return false
+}
+
+// PhaseCorrelation measures how a stereo signal folds down to mono. Overall is the
+// correlation of the whole file (1 = mono, 0 = unrelated, -1 = cancels out in mono);
+// Minimum is the lowest correlation of any window with audible content.
+type PhaseCorrelation struct {
+	Overall float64 `json:"overall"`
+	Minimum float64 `json:"minimum"`
+}
+
+// StereoReport describes the stereo processing applied to a mix
+type StereoReport struct {
+	Mode       string            `json:"mode"`
+	Width      float64           `json:"width,omitempty"`
+	HaasDelay  float64           `json:"haas_delay_ms,omitempty"`
+	Threshold  float64           `json:"threshold"`
+	Before     *PhaseCorrelation `json:"before,omitempty"`
+	After      *PhaseCorrelation `json:"after,omitempty"`
+	RolledBack bool              `json:"rolled_back"`
+	Warning    string            `json:"warning,omitempty"`
+}
+
+// StereoProcessor applies the stereo mode of a job and guards its mono compatibility
+type StereoProcessor struct {
+	TempDir        string
+	Mode           string
+	Width          float64
+	HaasDelay      float64 // milliseconds
+	MinCorrelation float64
+}
+
+// NewStereoProcessor creates a stereo processor from the mix options
+func NewStereoProcessor(tempDir string, options MixOptions) *StereoProcessor {
+	return &StereoProcessor{
+		TempDir:        tempDir,
+		Mode:           options.StereoMode,
+		Width:          options.StereoWidth,
+		HaasDelay:      options.HaasDelay,
+		MinCorrelation: options.StereoMinCorrelation,
+	}
+}
+
+// Process applies the stereo mode to inputFile. It returns the file to continue with,
+// which is inputFile itself when nothing was changed or the change was rolled back.
+func (sp *StereoProcessor) Process(inputFile string) (string, *StereoReport, error) {
+	report := &StereoReport{
+		Mode:      sp.Mode,
+		Threshold: sp.MinCorrelation,
+	}
+
+	switch sp.Mode {
+	case "", StereoModePassthrough:
+		report.Mode = StereoModePassthrough
+		return inputFile, report, nil
+	case StereoModeMono:
+		outputFile, err := sp.render(inputFile, "aformat=channel_layouts=mono", "stereo_mono.wav")
+		return outputFile, report, err
+	}
+
+	before, err := MeasurePhaseCorrelation(inputFile)
+	if err != nil {
+		return "", nil, err
+	}
+	report.Before = before
+
+	if sp.Mode == StereoModeMonoCompatCheck {
+		if before.Overall < sp.MinCorrelation {
+			report.Warning = fmt.Sprintf("correlation %.2f is below %.2f; the mix may lose content in mono", before.Overall, sp.MinCorrelation)
+		}
+		return inputFile, report, nil
+	}
+
+	var filter string
+	switch sp.Mode {
+	case StereoModeWiden:
+		report.Width = sp.Width
+		filter = fmt.Sprintf("stereotools=slev=%.3f", sp.Width)
+	case StereoModeMidSideEQ:
+		// Tighten the low end of the side channel and open up its top end
+		filter = "stereotools=mode=lr>ms,highpass=f=150:c=c1,treble=g=3:f=6000:c=c1,stereotools=mode=ms>lr"
+	case StereoModeHaas:
+		report.HaasDelay = sp.HaasDelay
+		filter = fmt.Sprintf("adelay=delays=0|%.2f", sp.HaasDelay)
+	default:
+		return "", nil, fmt.Errorf("unknown stereo mode: %s", sp.Mode)
+	}
+
+	outputFile, err := sp.render(inputFile, filter, "stereo_"+sp.Mode+".wav")
+	if err != nil {
+		return "", nil, err
+	}
+
+	after, err := MeasurePhaseCorrelation(outputFile)
+	if err != nil {
+		return "", nil, err
+	}
+	report.After = after
+
+	// Only roll back when the processing itself pushed the mix below the threshold
+	if after.Overall < sp.MinCorrelation && after.Overall < before.Overall {
+		report.RolledBack = true
+		report.Warning = fmt.Sprintf("correlation dropped from %.2f to %.2f, below %.2f; kept the original image", before.Overall, after.Overall, sp.MinCorrelation)
+		return inputFile, report, nil
+	}
+	return outputFile, report, nil
+}
+
+// render runs a stereo filter into a lossless intermediate
+func (sp *StereoProcessor) render(inputFile, filter, name string) (string, error) {
+	outputFile := filepath.Join(sp.TempDir, name)
+
+	args := []string{"-i", inputFile}
+	if sp.Mode != StereoModeMono {
+		args = append(args, "-ac", "2")
+	}
+	args = append(args, "-af", filter, "-c:a", "pcm_s24le", "-y", outputFile)
+
+	cmd := exec.Command("ffmpeg", args...)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("ffmpeg stereo %s error: %v\nOutput: %s", sp.Mode, err, output)
+	}
+	return outputFile, nil
+}
+
+// MeasurePhaseCorrelation computes the left/right correlation of a file decoded as stereo
+func MeasurePhaseCorrelation(file string) (*PhaseCorrelation, error) {
+	windowFrames := int(correlationWindowSeconds * correlationSampleRate)
+
+	var sumLR, sumLL, sumRR float64
+	var winLR, winLL, winRR float64
+	frames := 0
+	minimum := 1.0
+	windows := 0
+
+	err := StreamPCM(file, correlationSampleRate, 2, func(samples []float32) error {
+		for i := 0; i+1 < len(samples); i += 2 {
+			l, r := float64(samples[i]), float64(samples[i+1])
+			winLR += l * r
+			winLL += l * l
+			winRR += r * r
+			frames++
+
+			if frames%windowFrames == 0 {
+				// Skip near-silent windows, their correlation is meaningless
+				if rms := math.Sqrt((winLL + winRR) / float64(2*windowFrames)); rms > 1e-3 {
+					if c := correlation(winLR, winLL, winRR); c < minimum {
+						minimum = c
+					}
+					windows++
+				}
+				sumLR += winLR
+				sumLL += winLL
+				sumRR += winRR
+				winLR, winLL, winRR = 0, 0, 0
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sumLR += winLR
+	sumLL += winLL
+	sumRR += winRR
+	if frames == 0 {
+		return nil, fmt.Errorf("no audio to measure phase correlation")
+	}
+
+	result := &PhaseCorrelation{
+		Overall: math.Round(correlation(sumLR, sumLL, sumRR)*1000) / 1000,
+		Minimum: 1,
+	}
+	if windows > 0 {
+		result.Minimum = math.Round(minimum*1000) / 1000
+	}
+	return result, nil
+}
+
+// correlation normalises a cross product by the channel energies; silence counts as mono
+func correlation(lr, ll, rr float64) float64 {
+	if ll == 0 && rr == 0 {
+		return 1
+	}
+	if ll == 0 || rr == 0 {
+		return 0
+	}
+	return lr / math.Sqrt(ll*rr)
+}
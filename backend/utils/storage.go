@@ -0,0 +1,206 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ErrObjectNotFound is returned for keys that aren't in the storage
+var ErrObjectNotFound = errors.New("object not found")
+
+// ErrObjectExists is returned by Create when the key is already taken
+var ErrObjectExists = errors.New("object already exists")
+
+// ErrPresignNotSupported is returned by storages that can't hand out direct download URLs
+var ErrPresignNotSupported = errors.New("presigned URLs are not supported by this storage")
+
+// ObjectInfo describes a stored object
+type ObjectInfo struct {
+	Key     string
+	Size    int64
+	ModTime time.Time
+	ETag    string
+}
+
+// Storage keeps results, assets and resumable uploads where every backend instance can
+// reach them. Keys are slash separated paths such as "output/<session>/mixloop_output.mp3".
+type Storage interface {
+	// Put stores size bytes from r under key, replacing any existing object
+	Put(key string, r io.Reader, size int64) error
+	// Create stores size bytes from r under key unless an object exists there already, in
+	// which case it returns ErrObjectExists. Instances racing for a key get one winner.
+	Create(key string, r io.Reader, size int64) error
+	// Get opens an object for reading
+	Get(key string) (io.ReadCloser, *ObjectInfo, error)
+	// Stat describes an object without reading it
+	Stat(key string) (*ObjectInfo, error)
+	// Delete removes an object; deleting a missing key is not an error
+	Delete(key string) error
+	// List returns every object whose key starts with prefix, sorted by key
+	List(prefix string) ([]ObjectInfo, error)
+	// PresignedURL returns a URL that downloads key without credentials until expiry,
+	// saved as downloadName when it isn't empty
+	PresignedURL(key string, expiry time.Duration, downloadName string) (string, error)
+}
+
+// NewStorage creates the storage selected by the configuration
+func NewStorage(config *Config) (Storage, error) {
+	switch config.Storage {
+	case "local":
+		return NewLocalStorage(config.StorageDir), nil
+	case "s3":
+		return NewS3Storage(config.S3)
+	}
+	return nil, fmt.Errorf("unknown storage %q", config.Storage)
+}
+
+// GlobalStorage is the storage shared by the result, asset and upload stores
+var GlobalStorage = newGlobalStorage()
+
+func newGlobalStorage() Storage {
+	storage, err := NewStorage(GlobalConfig)
+	if err != nil {
+		log.Fatalf("Invalid storage configuration: %v", err)
+	}
+	return storage
+}
+
+// LocalPath returns the file behind key when the storage keeps its objects on this machine,
+// so ffmpeg can read and write them in place
+func LocalPath(storage Storage, key string) (string, bool) {
+	if local, ok := storage.(*LocalStorage); ok {
+		return local.Path(key), true
+	}
+	return "", false
+}
+
+// PutFile stores a local file under key
+func PutFile(storage Storage, key, file string) error {
+	return storeFile(storage.Put, key, file)
+}
+
+// CreateFile stores a local file under key unless an object exists there already
+func CreateFile(storage Storage, key, file string) error {
+	return storeFile(storage.Create, key, file)
+}
+
+func storeFile(store func(key string, r io.Reader, size int64) error, key, file string) error {
+	src, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	info, err := src.Stat()
+	if err != nil {
+		return err
+	}
+	return store(key, src, info.Size())
+}
+
+// FetchFile copies an object to a local file
+func FetchFile(storage Storage, key, file string) error {
+	src, _, err := storage.Get(key)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return err
+	}
+	// Write next to the destination first so readers never see a partial file
+	tmp := file + ".part"
+	dst, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, file)
+}
+
+// DeletePrefix removes every object whose key starts with prefix
+func DeletePrefix(storage Storage, prefix string) error {
+	objects, err := storage.List(prefix)
+	if err != nil {
+		return err
+	}
+	for _, object := range objects {
+		if err := storage.Delete(object.Key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ServeObject answers a download of key: storages on this machine serve the file with
+// http.ServeContent, remote ones redirect to a short-lived presigned URL
+func ServeObject(w http.ResponseWriter, r *http.Request, storage Storage, key, downloadName string) {
+	if file, ok := LocalPath(storage, key); ok {
+		src, err := os.Open(file)
+		if err != nil {
+			http.Error(w, "File not found", http.StatusNotFound)
+			return
+		}
+		defer src.Close()
+		info, err := src.Stat()
+		if err != nil || info.IsDir() {
+			http.Error(w, "File not found", http.StatusNotFound)
+			return
+		}
+		http.ServeContent(w, r, path.Base(key), info.ModTime(), src)
+		return
+	}
+
+	if _, err := storage.Stat(key); err != nil {
+		http.Error(w, "File not found", http.StatusNotFound)
+		return
+	}
+	url, err := storage.PresignedURL(key, 15*time.Minute, downloadName)
+	if err != nil {
+		http.Error(w, "Failed to create download URL", http.StatusInternalServerError)
+		return
+	}
+
+	// The URL expires, so the redirect itself must not be cached
+	w.Header().Set("Cache-Control", "no-store")
+	status := http.StatusTemporaryRedirect
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		status = http.StatusSeeOther
+	}
+	http.Redirect(w, r, url, status)
+}
+
+// storageContentType guesses the Content-Type stored with an object from its key
+func storageContentType(key string) string {
+	ext := strings.ToLower(path.Ext(key))
+	for _, spec := range OutputFormats {
+		if spec.Extension == ext {
+			return spec.ContentType
+		}
+	}
+	if contentType := StreamContentType(key); contentType != "" {
+		return contentType
+	}
+	if contentType := mime.TypeByExtension(ext); contentType != "" {
+		return contentType
+	}
+	return "application/octet-stream"
+}
@@ -0,0 +1,171 @@
+package utils
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// LocalStorage keeps objects as files under Root, one per key
+type LocalStorage struct {
+	Root string
+}
+
+// NewLocalStorage creates a new local filesystem storage
+func NewLocalStorage(root string) *LocalStorage {
+	return &LocalStorage{
+		Root: root,
+	}
+}
+
+// Path is the file of a key. Keys are cleaned so they can't leave Root.
+func (ls *LocalStorage) Path(key string) string {
+	return filepath.Join(ls.Root, filepath.FromSlash(path.Clean("/"+key)))
+}
+
+// Put writes the object through a temporary file, so readers never see a partial object
+func (ls *LocalStorage) Put(key string, r io.Reader, size int64) error {
+	tmp, err := ls.writeTemp(key, r, size)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, ls.Path(key))
+}
+
+// Create writes the object through a temporary file and links it in place, which fails
+// when the file exists
+func (ls *LocalStorage) Create(key string, r io.Reader, size int64) error {
+	tmp, err := ls.writeTemp(key, r, size)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+	if err := os.Link(tmp, ls.Path(key)); err != nil {
+		if errors.Is(err, fs.ErrExist) {
+			return ErrObjectExists
+		}
+		return err
+	}
+	return nil
+}
+
+// writeTemp copies size bytes from r to a temporary file next to the file of key
+func (ls *LocalStorage) writeTemp(key string, r io.Reader, size int64) (string, error) {
+	file := ls.Path(key)
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return "", err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(file), ".put-*")
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(tmp, io.LimitReader(r, size)); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	return tmp.Name(), nil
+}
+
+// Get opens the file of a key
+func (ls *LocalStorage) Get(key string) (io.ReadCloser, *ObjectInfo, error) {
+	file, err := os.Open(ls.Path(key))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil, ErrObjectNotFound
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	info, err := file.Stat()
+	if err != nil || info.IsDir() {
+		file.Close()
+		return nil, nil, ErrObjectNotFound
+	}
+	return file, ls.objectInfo(key, info), nil
+}
+
+// Stat describes the file of a key
+func (ls *LocalStorage) Stat(key string) (*ObjectInfo, error) {
+	info, err := os.Stat(ls.Path(key))
+	if errors.Is(err, fs.ErrNotExist) || (err == nil && info.IsDir()) {
+		return nil, ErrObjectNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return ls.objectInfo(key, info), nil
+}
+
+// Delete removes the file of a key and the directories it leaves empty
+func (ls *LocalStorage) Delete(key string) error {
+	file := ls.Path(key)
+	if err := os.Remove(file); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	for dir := filepath.Dir(file); ; dir = filepath.Dir(dir) {
+		rel, err := filepath.Rel(ls.Root, dir)
+		if err != nil || rel == "." || strings.HasPrefix(rel, "..") || os.Remove(dir) != nil {
+			return nil
+		}
+	}
+}
+
+// List walks the directory that holds prefix and returns the files whose key matches it
+func (ls *LocalStorage) List(prefix string) ([]ObjectInfo, error) {
+	dir := ls.Root
+	if i := strings.LastIndex(prefix, "/"); i >= 0 {
+		dir = ls.Path(prefix[:i])
+	}
+
+	var objects []ObjectInfo
+	err := filepath.WalkDir(dir, func(file string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if !entry.Type().IsRegular() || strings.HasPrefix(entry.Name(), ".put-") {
+			return nil
+		}
+		rel, err := filepath.Rel(ls.Root, file)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil
+		}
+		objects = append(objects, *ls.objectInfo(key, info))
+		return nil
+	})
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Key < objects[j].Key })
+	return objects, err
+}
+
+// PresignedURL isn't available for files on this machine; they are served by the API instead
+func (ls *LocalStorage) PresignedURL(key string, expiry time.Duration, downloadName string) (string, error) {
+	return "", ErrPresignNotSupported
+}
+
+func (ls *LocalStorage) objectInfo(key string, info fs.FileInfo) *ObjectInfo {
+	return &ObjectInfo{
+		Key:     key,
+		Size:    info.Size(),
+		ModTime: info.ModTime(),
+	}
+}
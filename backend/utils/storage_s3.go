@@ -0,0 +1,523 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// S3Config is the connection to an S3-compatible object store such as AWS S3 or MinIO
+type S3Config struct {
+	Endpoint  string // e.g. https://s3.eu-central-1.amazonaws.com or http://localhost:9000
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	// PathStyle addresses the bucket as endpoint/bucket/key instead of bucket.endpoint/key,
+	// which MinIO and most self-hosted stores need
+	PathStyle bool
+}
+
+const (
+	// s3DefaultPartSize is the size above which objects are uploaded in parts
+	s3DefaultPartSize = 64 << 20
+	// s3MaxParts is the most parts a multipart upload can have
+	s3MaxParts = 10000
+)
+
+// S3Storage keeps objects in a bucket, signing every request with AWS Signature Version 4
+type S3Storage struct {
+	Config S3Config
+	Client *http.Client
+	// PartSize is the size above which Put uploads in parts of this size, so objects can
+	// exceed the 5 GB limit of a single PUT
+	PartSize int64
+	base     *url.URL
+}
+
+// NewS3Storage creates a new S3-compatible storage
+func NewS3Storage(config S3Config) (*S3Storage, error) {
+	if config.Bucket == "" {
+		return nil, fmt.Errorf("an S3 bucket is required")
+	}
+	if config.AccessKey == "" || config.SecretKey == "" {
+		return nil, fmt.Errorf("S3 credentials are required")
+	}
+	if config.Region == "" {
+		config.Region = "us-east-1"
+	}
+	if config.Endpoint == "" {
+		config.Endpoint = "https://s3." + config.Region + ".amazonaws.com"
+	}
+
+	base, err := url.Parse(strings.TrimSuffix(config.Endpoint, "/"))
+	if err != nil || base.Host == "" || (base.Scheme != "http" && base.Scheme != "https") {
+		return nil, fmt.Errorf("invalid S3 endpoint: %s", config.Endpoint)
+	}
+	if config.PathStyle {
+		base.Path += "/" + config.Bucket
+	} else {
+		base.Host = config.Bucket + "." + base.Host
+	}
+
+	// Transfers of large objects take as long as they take, so only the connection and the
+	// wait for response headers are bounded, not the whole request
+	transport := &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           (&net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}).DialContext,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ResponseHeaderTimeout: 2 * time.Minute,
+		ExpectContinueTimeout: time.Second,
+		IdleConnTimeout:       90 * time.Second,
+		MaxIdleConnsPerHost:   16,
+	}
+	return &S3Storage{
+		Config:   config,
+		Client:   &http.Client{Transport: transport},
+		PartSize: s3DefaultPartSize,
+		base:     base,
+	}, nil
+}
+
+// objectURL is the URL of a key, without a query
+func (ss *S3Storage) objectURL(key string) *url.URL {
+	u := *ss.base
+	u.Path += "/" + key
+	u.RawPath = ss.base.Path + "/" + s3Escape(key, false)
+	return &u
+}
+
+// queryURL is the URL of a key with a subresource query
+func (ss *S3Storage) queryURL(key string, query url.Values) string {
+	u := ss.objectURL(key)
+	u.RawQuery = s3Query(query)
+	return u.String()
+}
+
+// Put uploads an object, in parts when it is larger than PartSize. The payload isn't hashed
+// so it can be streamed.
+func (ss *S3Storage) Put(key string, r io.Reader, size int64) error {
+	return ss.put(key, r, size, false)
+}
+
+// Create uploads an object with If-None-Match: *, so S3 refuses it when the key exists
+func (ss *S3Storage) Create(key string, r io.Reader, size int64) error {
+	return ss.put(key, r, size, true)
+}
+
+func (ss *S3Storage) put(key string, r io.Reader, size int64, ifAbsent bool) error {
+	if ss.PartSize > 0 && size > ss.PartSize {
+		return ss.putMultipart(key, r, size, ifAbsent)
+	}
+
+	req, err := http.NewRequest(http.MethodPut, ss.objectURL(key).String(), io.LimitReader(r, size))
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	if size == 0 {
+		req.Body = http.NoBody
+	}
+	req.Header.Set("Content-Type", storageContentType(key))
+	if ifAbsent {
+		req.Header.Set("If-None-Match", "*")
+	}
+	resp, err := ss.do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// s3CompletedPart is one uploaded part in a CompleteMultipartUpload request
+type s3CompletedPart struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+// putMultipart uploads an object in parts, aborting the upload when a part fails so the
+// bucket isn't left holding orphaned parts. With ifAbsent the completion is conditional.
+func (ss *S3Storage) putMultipart(key string, r io.Reader, size int64, ifAbsent bool) error {
+	partSize := ss.PartSize
+	if minimum := (size + s3MaxParts - 1) / s3MaxParts; partSize < minimum {
+		partSize = minimum
+	}
+
+	var created struct {
+		UploadID string `xml:"UploadId"`
+	}
+	req, err := http.NewRequest(http.MethodPost, ss.queryURL(key, url.Values{"uploads": {""}}), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", storageContentType(key))
+	if err := ss.doXML(req, &created); err != nil {
+		return err
+	}
+	if created.UploadID == "" {
+		return fmt.Errorf("S3 multipart upload of %s returned no upload ID", key)
+	}
+
+	var parts []s3CompletedPart
+	for offset := int64(0); offset < size; offset += partSize {
+		length := size - offset
+		if length > partSize {
+			length = partSize
+		}
+		etag, err := ss.putPart(key, created.UploadID, len(parts)+1, io.LimitReader(r, length), length)
+		if err != nil {
+			ss.abortMultipart(key, created.UploadID)
+			return err
+		}
+		parts = append(parts, s3CompletedPart{PartNumber: len(parts) + 1, ETag: etag})
+	}
+
+	body, err := xml.Marshal(struct {
+		XMLName xml.Name          `xml:"CompleteMultipartUpload"`
+		Parts   []s3CompletedPart `xml:"Part"`
+	}{Parts: parts})
+	if err != nil {
+		ss.abortMultipart(key, created.UploadID)
+		return err
+	}
+	req, err = http.NewRequest(http.MethodPost, ss.queryURL(key, url.Values{"uploadId": {created.UploadID}}), bytes.NewReader(body))
+	if err != nil {
+		ss.abortMultipart(key, created.UploadID)
+		return err
+	}
+	req.Header.Set("Content-Type", "application/xml")
+	if ifAbsent {
+		req.Header.Set("If-None-Match", "*")
+	}
+	// The request can fail with a 200 response, so the body is checked for an error too
+	var completed struct {
+		XMLName xml.Name
+		Code    string `xml:"Code"`
+		Message string `xml:"Message"`
+	}
+	if err := ss.doXML(req, &completed); err != nil {
+		ss.abortMultipart(key, created.UploadID)
+		return err
+	}
+	if completed.XMLName.Local == "Error" {
+		ss.abortMultipart(key, created.UploadID)
+		if completed.Code == "PreconditionFailed" {
+			return ErrObjectExists
+		}
+		return fmt.Errorf("S3 multipart upload of %s failed: %s %s", key, completed.Code, completed.Message)
+	}
+	return nil
+}
+
+// putPart uploads one part of a multipart upload and returns its ETag
+func (ss *S3Storage) putPart(key, uploadID string, number int, r io.Reader, size int64) (string, error) {
+	query := url.Values{"partNumber": {strconv.Itoa(number)}, "uploadId": {uploadID}}
+	req, err := http.NewRequest(http.MethodPut, ss.queryURL(key, query), r)
+	if err != nil {
+		return "", err
+	}
+	req.ContentLength = size
+	resp, err := ss.do(req)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	etag := resp.Header.Get("ETag")
+	if etag == "" {
+		return "", fmt.Errorf("S3 part %d of %s returned no ETag", number, key)
+	}
+	return etag, nil
+}
+
+// abortMultipart discards the parts of a failed multipart upload
+func (ss *S3Storage) abortMultipart(key, uploadID string) {
+	req, err := http.NewRequest(http.MethodDelete, ss.queryURL(key, url.Values{"uploadId": {uploadID}}), nil)
+	if err != nil {
+		return
+	}
+	if resp, err := ss.do(req); err == nil {
+		resp.Body.Close()
+	}
+}
+
+// Get downloads an object
+func (ss *S3Storage) Get(key string) (io.ReadCloser, *ObjectInfo, error) {
+	req, err := http.NewRequest(http.MethodGet, ss.objectURL(key).String(), nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	resp, err := ss.do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	return resp.Body, s3ObjectInfo(key, resp), nil
+}
+
+// Stat reads the headers of an object
+func (ss *S3Storage) Stat(key string) (*ObjectInfo, error) {
+	req, err := http.NewRequest(http.MethodHead, ss.objectURL(key).String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := ss.do(req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	return s3ObjectInfo(key, resp), nil
+}
+
+// Delete removes an object
+func (ss *S3Storage) Delete(key string) error {
+	req, err := http.NewRequest(http.MethodDelete, ss.objectURL(key).String(), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := ss.do(req)
+	if err == ErrObjectNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// List pages through ListObjectsV2
+func (ss *S3Storage) List(prefix string) ([]ObjectInfo, error) {
+	var objects []ObjectInfo
+	token := ""
+	for {
+		u := *ss.base
+		u.Path += "/"
+		u.RawPath = ss.base.Path + "/"
+		query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		u.RawQuery = s3Query(query)
+
+		req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := ss.do(req)
+		if err != nil {
+			return nil, err
+		}
+		var result struct {
+			Contents []struct {
+				Key          string    `xml:"Key"`
+				Size         int64     `xml:"Size"`
+				LastModified time.Time `xml:"LastModified"`
+				ETag         string    `xml:"ETag"`
+			} `xml:"Contents"`
+			IsTruncated           bool   `xml:"IsTruncated"`
+			NextContinuationToken string `xml:"NextContinuationToken"`
+		}
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("invalid S3 list response: %v", err)
+		}
+
+		for _, content := range result.Contents {
+			objects = append(objects, ObjectInfo{
+				Key:     content.Key,
+				Size:    content.Size,
+				ModTime: content.LastModified,
+				ETag:    strings.Trim(content.ETag, `"`),
+			})
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			break
+		}
+		token = result.NextContinuationToken
+	}
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Key < objects[j].Key })
+	return objects, nil
+}
+
+// PresignedURL signs a GET of key into its query string
+func (ss *S3Storage) PresignedURL(key string, expiry time.Duration, downloadName string) (string, error) {
+	return ss.presign(key, expiry, downloadName, time.Now().UTC()), nil
+}
+
+func (ss *S3Storage) presign(key string, expiry time.Duration, downloadName string, now time.Time) string {
+	u := ss.objectURL(key)
+	query := url.Values{
+		"X-Amz-Algorithm":     {"AWS4-HMAC-SHA256"},
+		"X-Amz-Credential":    {ss.Config.AccessKey + "/" + ss.scope(now)},
+		"X-Amz-Date":          {now.Format("20060102T150405Z")},
+		"X-Amz-Expires":       {strconv.Itoa(int(expiry.Seconds()))},
+		"X-Amz-SignedHeaders": {"host"},
+	}
+	if downloadName != "" {
+		query.Set("response-content-disposition", "attachment; filename="+downloadName)
+	}
+	u.RawQuery = s3Query(query)
+
+	canonical := strings.Join([]string{
+		http.MethodGet,
+		u.EscapedPath(),
+		u.RawQuery,
+		"host:" + u.Host + "\n",
+		"host",
+		"UNSIGNED-PAYLOAD",
+	}, "\n")
+	u.RawQuery += "&X-Amz-Signature=" + ss.signature(now, canonical)
+	return u.String()
+}
+
+// do signs and sends a request, turning 404 into ErrObjectNotFound, a failed If-None-Match
+// into ErrObjectExists and other failures into errors with the S3 error message
+func (ss *S3Storage) do(req *http.Request) (*http.Response, error) {
+	ss.sign(req, time.Now().UTC())
+	resp, err := ss.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("S3 %s %s failed: %v", req.Method, req.URL.Path, err)
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return nil, ErrObjectNotFound
+	}
+	if resp.StatusCode == http.StatusPreconditionFailed && req.Header.Get("If-None-Match") != "" {
+		resp.Body.Close()
+		return nil, ErrObjectExists
+	}
+	if resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		resp.Body.Close()
+		return nil, fmt.Errorf("S3 %s %s failed: %s\nOutput: %s", req.Method, req.URL.Path, resp.Status, body)
+	}
+	return resp, nil
+}
+
+// doXML sends a request and decodes its XML response into v
+func (ss *S3Storage) doXML(req *http.Request, v interface{}) error {
+	resp, err := ss.do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := xml.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("invalid S3 response to %s %s: %v", req.Method, req.URL.Path, err)
+	}
+	return nil
+}
+
+// sign adds the SigV4 Authorization header; the payload is sent unsigned
+func (ss *S3Storage) sign(req *http.Request, now time.Time) {
+	req.Header.Set("X-Amz-Date", now.Format("20060102T150405Z"))
+	req.Header.Set("X-Amz-Content-Sha256", "UNSIGNED-PAYLOAD")
+
+	headers := map[string]string{
+		"host":                 req.URL.Host,
+		"x-amz-content-sha256": "UNSIGNED-PAYLOAD",
+		"x-amz-date":           req.Header.Get("X-Amz-Date"),
+	}
+	if contentType := req.Header.Get("Content-Type"); contentType != "" {
+		headers["content-type"] = contentType
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + strings.TrimSpace(headers[name]) + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonical := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		"UNSIGNED-PAYLOAD",
+	}, "\n")
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		ss.Config.AccessKey, ss.scope(now), signedHeaders, ss.signature(now, canonical)))
+}
+
+// scope is the credential scope of a signature made at now
+func (ss *S3Storage) scope(now time.Time) string {
+	return now.Format("20060102") + "/" + ss.Config.Region + "/s3/aws4_request"
+}
+
+// signature signs a canonical request with the key derived for the day and region
+func (ss *S3Storage) signature(now time.Time, canonical string) string {
+	hash := sha256.Sum256([]byte(canonical))
+	stringToSign := "AWS4-HMAC-SHA256\n" + now.Format("20060102T150405Z") + "\n" + ss.scope(now) + "\n" + hex.EncodeToString(hash[:])
+
+	key := hmacSHA256([]byte("AWS4"+ss.Config.SecretKey), now.Format("20060102"))
+	key = hmacSHA256(key, ss.Config.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	return hex.EncodeToString(hmacSHA256(key, stringToSign))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// s3Escape percent-encodes everything but unreserved characters, and slashes unless
+// encodeSlash is set, as SigV4 canonical requests require
+func s3Escape(value string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' ||
+			c == '-' || c == '_' || c == '.' || c == '~' || (c == '/' && !encodeSlash) {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
+
+// s3Query encodes a query sorted by key, in the canonical form SigV4 signs
+func s3Query(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var pairs []string
+	for _, key := range keys {
+		for _, value := range query[key] {
+			pairs = append(pairs, s3Escape(key, true)+"="+s3Escape(value, true))
+		}
+	}
+	return strings.Join(pairs, "&")
+}
+
+// s3ObjectInfo reads the object headers of a response
+func s3ObjectInfo(key string, resp *http.Response) *ObjectInfo {
+	modTime, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+	return &ObjectInfo{
+		Key:     key,
+		Size:    resp.ContentLength,
+		ModTime: modTime,
+		ETag:    strings.Trim(resp.Header.Get("ETag"), `"`),
+	}
+}
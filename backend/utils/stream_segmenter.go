@@ -0,0 +1,152 @@
+package utils
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Stream codecs
+const (
+	StreamCodecAAC  = "aac"
+	StreamCodecOpus = "opus"
+)
+
+// StreamOptions controls segmented delivery of a finished mix
+type StreamOptions struct {
+	Codec           string  `json:"codec"`
+	SegmentDuration float64 `json:"segment_duration"` // seconds
+	DASH            bool    `json:"dash"`
+}
+
+// DefaultStreamOptions returns 6 second AAC segments without DASH
+func DefaultStreamOptions() StreamOptions {
+	return StreamOptions{Codec: StreamCodecAAC, SegmentDuration: 6}
+}
+
+// Validate checks the codec and segment duration
+func (so StreamOptions) Validate() error {
+	if so.Codec != StreamCodecAAC && so.Codec != StreamCodecOpus {
+		return fmt.Errorf("codec must be %s or %s", StreamCodecAAC, StreamCodecOpus)
+	}
+	if so.SegmentDuration < 2 || so.SegmentDuration > 30 {
+		return fmt.Errorf("segment duration must be between 2 and 30 seconds")
+	}
+	return nil
+}
+
+// StreamReport lists the playlists of a segmented mix
+type StreamReport struct {
+	StreamOptions
+	Playlist string `json:"playlist"`
+	Manifest string `json:"manifest,omitempty"`
+	Segments int    `json:"segments"`
+}
+
+// StreamSegmenter cuts a finished mix into fragmented MP4 segments for HLS and DASH
+type StreamSegmenter struct {
+	Options StreamOptions
+}
+
+// NewStreamSegmenter creates a new stream segmenter
+func NewStreamSegmenter(options StreamOptions) *StreamSegmenter {
+	return &StreamSegmenter{
+		Options: options,
+	}
+}
+
+// Segment writes an HLS playlist with its segments to dir/hls and, when DASH is requested,
+// an MPD with its own segments to dir/dash
+func (ss *StreamSegmenter) Segment(inputFile, dir string) (*StreamReport, error) {
+	// A reused session ID must not leave segments of an earlier mix behind
+	os.RemoveAll(filepath.Join(dir, "dash"))
+	hlsDir := filepath.Join(dir, "hls")
+	os.RemoveAll(hlsDir)
+	if err := os.MkdirAll(hlsDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create stream directory: %v", err)
+	}
+
+	args := []string{"-i", inputFile, "-map", "0:a"}
+	args = append(args, ss.codecArgs()...)
+	args = append(args,
+		"-f", "hls",
+		"-hls_time", fmt.Sprintf("%g", ss.Options.SegmentDuration),
+		"-hls_playlist_type", "vod",
+		"-hls_segment_type", "fmp4",
+		"-hls_fmp4_init_filename", "init.mp4",
+		"-hls_segment_filename", filepath.Join(hlsDir, "segment_%05d.m4s"),
+		"-y", filepath.Join(hlsDir, "playlist.m3u8"))
+
+	cmd := exec.Command("ffmpeg", args...)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("ffmpeg hls error: %v\nOutput: %s", err, output)
+	}
+
+	segments, err := filepath.Glob(filepath.Join(hlsDir, "segment_*.m4s"))
+	if err != nil {
+		return nil, err
+	}
+	report := &StreamReport{
+		StreamOptions: ss.Options,
+		Playlist:      "hls/playlist.m3u8",
+		Segments:      len(segments),
+	}
+
+	if ss.Options.DASH {
+		if err := ss.segmentDASH(inputFile, filepath.Join(dir, "dash")); err != nil {
+			return nil, err
+		}
+		report.Manifest = "dash/manifest.mpd"
+	}
+	return report, nil
+}
+
+// segmentDASH writes a static MPD with templated segment names
+func (ss *StreamSegmenter) segmentDASH(inputFile, dashDir string) error {
+	if err := os.MkdirAll(dashDir, 0755); err != nil {
+		return fmt.Errorf("failed to create stream directory: %v", err)
+	}
+
+	args := []string{"-i", inputFile, "-map", "0:a"}
+	args = append(args, ss.codecArgs()...)
+	args = append(args,
+		"-f", "dash",
+		"-seg_duration", fmt.Sprintf("%g", ss.Options.SegmentDuration),
+		"-use_template", "1",
+		"-use_timeline", "1",
+		"-init_seg_name", "init.m4s",
+		"-media_seg_name", "segment_$Number%05d$.m4s",
+		"-y", filepath.Join(dashDir, "manifest.mpd"))
+
+	cmd := exec.Command("ffmpeg", args...)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("ffmpeg dash error: %v\nOutput: %s", err, output)
+	}
+	return nil
+}
+
+func (ss *StreamSegmenter) codecArgs() []string {
+	if ss.Options.Codec == StreamCodecOpus {
+		return []string{"-c:a", "libopus", "-b:a", "160k", "-ar", "48000"}
+	}
+	return []string{"-c:a", "aac", "-b:a", "192k", "-ar", "48000"}
+}
+
+// StreamContentType returns the MIME type of a stream file, or "" when it isn't one
+func StreamContentType(name string) string {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".m3u8":
+		return "application/vnd.apple.mpegurl"
+	case ".mpd":
+		return "application/dash+xml"
+	case ".m4s":
+		return "audio/iso.segment"
+	case ".mp4":
+		return "audio/mp4"
+	}
+	return ""
+}
@@ -0,0 +1,264 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"sync"
+)
+
+const (
+	tempoSampleRate = 11025
+	tempoHopSize    = 128 // ~86 envelope frames per second
+	tempoMinBPM     = 60.0
+	tempoMaxBPM     = 200.0
+	tempoPriorBPM   = 120.0
+	beatsPerBar     = 4
+)
+
+// ErrNoTempo is returned for audio without a beat to follow, such as pads, drones and
+// very short files
+var ErrNoTempo = errors.New("no tempo found")
+
+// TempoInfo describes the detected tempo and beat grid of a track
+type TempoInfo struct {
+	BPM           float64 `json:"bpm"`
+	Confidence    float64 `json:"confidence"`
+	FirstBeat     float64 `json:"first_beat"`
+	FirstDownbeat float64 `json:"first_downbeat"`
+	BeatsPerBar   int     `json:"beats_per_bar"`
+}
+
+// BeatDuration returns the length of one beat in seconds
+func (ti *TempoInfo) BeatDuration() float64 {
+	return 60.0 / ti.BPM
+}
+
+// BarDuration returns the length of one bar in seconds
+func (ti *TempoInfo) BarDuration() float64 {
+	return ti.BeatDuration() * float64(ti.BeatsPerBar)
+}
+
+// TempoCache keeps the beat grids found during one job, so the sequencer doesn't decode a
+// file the track preparer already analysed. It lives as long as the job's MixOptions.
+type TempoCache struct {
+	mutex   sync.Mutex
+	entries map[string]*TempoInfo
+	misses  map[string]error // files without a beat grid, so they aren't decoded twice
+}
+
+// NewTempoCache creates an empty tempo cache
+func NewTempoCache() *TempoCache {
+	return &TempoCache{
+		entries: make(map[string]*TempoInfo),
+		misses:  make(map[string]error),
+	}
+}
+
+// tempoCacheKey identifies a file version in the tempo cache
+func tempoCacheKey(file string) string {
+	if stat, err := os.Stat(file); err == nil {
+		return fmt.Sprintf("%s|%d|%d", file, stat.Size(), stat.ModTime().UnixNano())
+	}
+	return file
+}
+
+// Remember stores a known beat grid for a file, e.g. after time stretching
+func (tc *TempoCache) Remember(file string, info *TempoInfo) {
+	if tc == nil {
+		return
+	}
+	copied := *info
+	tc.mutex.Lock()
+	tc.entries[tempoCacheKey(file)] = &copied
+	tc.mutex.Unlock()
+}
+
+// Detect returns the cached beat grid of a file, detecting it the first time. A nil cache
+// detects every time.
+func (tc *TempoCache) Detect(file string) (*TempoInfo, error) {
+	if tc == nil {
+		return DetectTempo(file)
+	}
+	key := tempoCacheKey(file)
+
+	tc.mutex.Lock()
+	cached, exists := tc.entries[key]
+	missed := tc.misses[key]
+	tc.mutex.Unlock()
+	if exists {
+		copied := *cached
+		return &copied, nil
+	}
+	if missed != nil {
+		return nil, missed
+	}
+
+	info, err := DetectTempo(file)
+	if errors.Is(err, ErrNoTempo) {
+		tc.mutex.Lock()
+		tc.misses[key] = err
+		tc.mutex.Unlock()
+	}
+	if err != nil {
+		return nil, err
+	}
+	tc.Remember(file, info)
+	return info, nil
+}
+
+// DetectTempo estimates the BPM and downbeat positions of an audio file
+func DetectTempo(file string) (*TempoInfo, error) {
+	envelope, err := onsetEnvelope(file)
+	if err != nil {
+		return nil, err
+	}
+	return DetectTempoFromEnvelope(envelope, float64(tempoSampleRate)/tempoHopSize)
+}
+
+// onsetEnvelope decodes a file and returns its half-wave rectified log-energy flux
+func onsetEnvelope(file string) ([]float64, error) {
+	var energies []float64
+	var sum float64
+	count := 0
+
+	err := StreamPCM(file, tempoSampleRate, 1, func(samples []float32) error {
+		for _, s := range samples {
+			sum += float64(s) * float64(s)
+			count++
+			if count == tempoHopSize {
+				energies = append(energies, math.Log1p(1000*sum/tempoHopSize))
+				sum = 0
+				count = 0
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(energies) < 2 {
+		return nil, fmt.Errorf("%w: audio too short", ErrNoTempo)
+	}
+
+	envelope := make([]float64, len(energies))
+	for i := 1; i < len(energies); i++ {
+		if diff := energies[i] - energies[i-1]; diff > 0 {
+			envelope[i] = diff
+		}
+	}
+
+	// Remove the local mean so sustained loud passages don't dominate the autocorrelation
+	const window = 16
+	smoothed := make([]float64, len(envelope))
+	var running float64
+	for i := range envelope {
+		running += envelope[i]
+		if i >= window {
+			running -= envelope[i-window]
+		}
+		n := math.Min(float64(i+1), window)
+		if v := envelope[i] - running/n; v > 0 {
+			smoothed[i] = v
+		}
+	}
+
+	return smoothed, nil
+}
+
+// DetectTempoFromEnvelope finds the beat period and phase of an onset envelope sampled at fps frames per second
+func DetectTempoFromEnvelope(envelope []float64, fps float64) (*TempoInfo, error) {
+	minLag := int(math.Floor(fps * 60 / tempoMaxBPM))
+	maxLag := int(math.Ceil(fps * 60 / tempoMinBPM))
+	if len(envelope) < maxLag*2 {
+		return nil, fmt.Errorf("%w: audio too short", ErrNoTempo)
+	}
+
+	autocorr := make([]float64, maxLag+2)
+	for lag := 0; lag <= maxLag+1; lag++ {
+		var sum float64
+		for i := 0; i+lag < len(envelope); i++ {
+			sum += envelope[i] * envelope[i+lag]
+		}
+		autocorr[lag] = sum / float64(len(envelope)-lag)
+	}
+	if autocorr[0] == 0 {
+		return nil, fmt.Errorf("%w: no rhythmic content", ErrNoTempo)
+	}
+
+	// Weight lags with a log-normal prior around a typical tempo to avoid octave errors
+	bestLag := minLag
+	bestScore := -1.0
+	for lag := minLag; lag <= maxLag; lag++ {
+		bpm := 60 * fps / float64(lag)
+		octaves := math.Log2(bpm / tempoPriorBPM)
+		score := autocorr[lag] * math.Exp(-0.5*octaves*octaves)
+		if score > bestScore {
+			bestScore = score
+			bestLag = lag
+		}
+	}
+
+	// Parabolic interpolation for sub-frame lag precision
+	period := float64(bestLag)
+	if bestLag > 0 && bestLag <= maxLag {
+		a, b, c := autocorr[bestLag-1], autocorr[bestLag], autocorr[bestLag+1]
+		if denom := a - 2*b + c; denom != 0 {
+			offset := 0.5 * (a - c) / denom
+			if math.Abs(offset) < 1 {
+				period += offset
+			}
+		}
+	}
+
+	// Beat phase: the offset whose pulse train collects the most onset energy
+	bestPhase := 0
+	bestPhaseScore := -1.0
+	for phase := 0; phase < int(math.Ceil(period)); phase++ {
+		score := pulseScore(envelope, float64(phase), period)
+		if score > bestPhaseScore {
+			bestPhaseScore = score
+			bestPhase = phase
+		}
+	}
+
+	// Downbeat: the beat within the bar with the strongest accents
+	bestBeat := 0
+	bestBeatScore := -1.0
+	for beat := 0; beat < beatsPerBar; beat++ {
+		score := pulseScore(envelope, float64(bestPhase)+float64(beat)*period, period*beatsPerBar)
+		if score > bestBeatScore {
+			bestBeatScore = score
+			bestBeat = beat
+		}
+	}
+
+	confidence := autocorr[bestLag] / autocorr[0]
+	if confidence > 1 {
+		confidence = 1
+	}
+
+	return &TempoInfo{
+		BPM:           math.Round(60*fps/period*100) / 100,
+		Confidence:    math.Round(confidence*1000) / 1000,
+		FirstBeat:     float64(bestPhase) / fps,
+		FirstDownbeat: (float64(bestPhase) + float64(bestBeat)*period) / fps,
+		BeatsPerBar:   beatsPerBar,
+	}, nil
+}
+
+// pulseScore sums the envelope at evenly spaced positions
+func pulseScore(envelope []float64, start, spacing float64) float64 {
+	var score float64
+	count := 0
+	for pos := start; int(math.Round(pos)) < len(envelope); pos += spacing {
+		score += envelope[int(math.Round(pos))]
+		count++
+	}
+	if count == 0 {
+		return 0
+	}
+	return score / float64(count)
+}
@@ -0,0 +1,64 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestTempoCacheIsScopedToTheJob(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "stretched_0.wav")
+	if err := os.WriteFile(file, []byte("RIFF"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	grid := &TempoInfo{BPM: 128, Confidence: 0.9, FirstBeat: 0.1, FirstDownbeat: 0.1, BeatsPerBar: 4}
+
+	job := NewTempoCache()
+	job.Remember(file, grid)
+	grid.BPM = 100 // the cache keeps its own copy
+	got, err := job.Detect(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.BPM != 128 {
+		t.Errorf("cached BPM = %g, want 128", got.BPM)
+	}
+
+	// Another job starts empty and has to analyse the file itself, which fails on this stub
+	if _, err := NewTempoCache().Detect(file); err == nil {
+		t.Error("a new job's cache returned a grid it never detected")
+	}
+
+	// A rewritten file is a different version
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(file, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := job.Detect(file); err == nil {
+		t.Error("cache returned the grid of an older version of the file")
+	}
+}
+
+func TestTempoCacheRemembersMisses(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "drone.wav")
+	if err := os.WriteFile(file, []byte("RIFF"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cache := NewTempoCache()
+	cache.misses[tempoCacheKey(file)] = fmt.Errorf("%w: no rhythmic content", ErrNoTempo)
+
+	if _, err := cache.Detect(file); !errors.Is(err, ErrNoTempo) {
+		t.Errorf("Detect = %v, want ErrNoTempo", err)
+	}
+
+	// An envelope without onsets has no tempo rather than failing outright
+	if _, err := DetectTempoFromEnvelope(make([]float64, 2000), 86); !errors.Is(err, ErrNoTempo) {
+		t.Errorf("flat envelope: %v, want ErrNoTempo", err)
+	}
+	if _, err := DetectTempoFromEnvelope(make([]float64, 10), 86); !errors.Is(err, ErrNoTempo) {
+		t.Errorf("short envelope: %v, want ErrNoTempo", err)
+	}
+}
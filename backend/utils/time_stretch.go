@@ -0,0 +1,92 @@
+package utils
+
+import (
+	"fmt"
+	"math"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+// Time stretching methods
+const (
+	StretchMethodRubberband = "rubberband"
+	StretchMethodAtempo     = "atempo"
+)
+
+// StretchResult describes the tempo change applied to one input
+type StretchResult struct {
+	Index       int     `json:"index"`
+	Name        string  `json:"name"`
+	OriginalBPM float64 `json:"original_bpm"`
+	TargetBPM   float64 `json:"target_bpm"`
+	Ratio       float64 `json:"ratio"`
+	Method      string  `json:"method,omitempty"`
+	Skipped     bool    `json:"skipped,omitempty"`
+	Reason      string  `json:"reason,omitempty"`
+}
+
+var (
+	rubberbandOnce      sync.Once
+	rubberbandAvailable bool
+)
+
+// HasRubberband reports whether the installed ffmpeg was built with librubberband
+func HasRubberband() bool {
+	rubberbandOnce.Do(func() {
+		output, err := exec.Command("ffmpeg", "-hide_banner", "-filters").Output()
+		rubberbandAvailable = err == nil && strings.Contains(string(output), " rubberband ")
+	})
+	return rubberbandAvailable
+}
+
+// StretchRatio returns the tempo ratio that brings bpm to target, treating half
+// and double time as the same tempo so a 63 BPM track follows a 126 BPM target
+func StretchRatio(bpm, target float64) float64 {
+	best := target / bpm
+	for _, candidate := range []float64{bpm * 2, bpm / 2} {
+		ratio := target / candidate
+		if math.Abs(math.Log(ratio)) < math.Abs(math.Log(best)) {
+			best = ratio
+		}
+	}
+	return best
+}
+
+// atempoChain splits a ratio into atempo stages, each within the filter's 0.5-2.0 range
+func atempoChain(ratio float64) string {
+	var stages []string
+	for ratio > 2.0 {
+		stages = append(stages, "atempo=2.0")
+		ratio /= 2.0
+	}
+	for ratio < 0.5 {
+		stages = append(stages, "atempo=0.5")
+		ratio /= 0.5
+	}
+	stages = append(stages, fmt.Sprintf("atempo=%.6f", ratio))
+	return strings.Join(stages, ",")
+}
+
+// TimeStretch changes the tempo of inputFile by ratio without changing its pitch
+func TimeStretch(inputFile, outputFile string, ratio float64) (string, error) {
+	method := StretchMethodAtempo
+	filter := atempoChain(ratio)
+	if HasRubberband() {
+		method = StretchMethodRubberband
+		filter = fmt.Sprintf("rubberband=tempo=%.6f:pitchq=quality", ratio)
+	}
+
+	cmd := exec.Command("ffmpeg",
+		"-i", inputFile,
+		"-af", filter,
+		"-c:a", "pcm_s24le",
+		"-y", outputFile)
+
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("ffmpeg time stretch error: %v\nOutput: %s", err, output)
+	}
+
+	return method, nil
+}
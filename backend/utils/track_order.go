@@ -0,0 +1,295 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"path/filepath"
+	"sort"
+)
+
+// Track ordering strategies
+const (
+	OrderUpload     = "upload"
+	OrderShuffle    = "shuffle"
+	OrderByDuration = "by_duration"
+	OrderByLoudness = "by_loudness"
+	OrderEnergyArc  = "energy_arc"
+	OrderHarmonic   = "harmonic"
+	OrderExplicit   = "explicit"
+)
+
+// IsValidOrder reports whether name is a known ordering strategy
+func IsValidOrder(name string) bool {
+	switch name {
+	case OrderUpload, OrderShuffle, OrderByDuration, OrderByLoudness, OrderEnergyArc, OrderHarmonic, OrderExplicit:
+		return true
+	}
+	return false
+}
+
+// OrderTracks returns the sequence order of the inputs as indices into files
+func OrderTracks(files []string, options MixOptions) ([]int, error) {
+	order := make([]int, len(files))
+	for i := range order {
+		order[i] = i
+	}
+
+	switch options.Order {
+	case "", OrderUpload:
+		return order, nil
+
+	case OrderShuffle:
+		if options.Report != nil {
+			options.Report.SetOrderSeed(options.OrderSeed)
+		}
+		return rand.New(rand.NewSource(options.OrderSeed)).Perm(len(files)), nil
+
+	case OrderExplicit:
+		if err := ValidateOrderIndices(options.OrderIndices, len(files)); err != nil {
+			return nil, err
+		}
+		return append([]int(nil), options.OrderIndices...), nil
+
+	case OrderByDuration:
+		durations := make([]float64, len(files))
+		for i, file := range files {
+			duration, err := GetAudioDuration(file)
+			if err != nil {
+				return nil, fmt.Errorf("failed to get duration of file %d: %v", i+1, err)
+			}
+			durations[i] = duration
+		}
+		sortByScore(order, durations)
+		return order, nil
+
+	case OrderByLoudness, OrderEnergyArc:
+		stats, err := measureTrackEnergy(files, options)
+		if err != nil {
+			return nil, err
+		}
+
+		scores := make([]float64, len(files))
+		for i, s := range stats {
+			scores[i] = s.LoudnessDB
+		}
+		if options.Order == OrderByLoudness {
+			sortByScore(order, scores)
+			return order, nil
+		}
+
+		// Energy combines loudness and brightness on a common scale
+		centroids := make([]float64, len(files))
+		for i, s := range stats {
+			centroids[i] = s.SpectralCentroid
+		}
+		loudness := standardize(scores)
+		brightness := standardize(centroids)
+		for i := range scores {
+			scores[i] = loudness[i] + brightness[i]
+		}
+		sortByScore(order, scores)
+		return EnergyArc(order), nil
+
+	case OrderHarmonic:
+		keys := make([]string, len(files))
+		for i, file := range files {
+			name := options.InputName(i, filepath.Base(file))
+			key, err := DetectKey(file)
+			if errors.Is(err, ErrNoKey) {
+				if options.Report != nil {
+					options.Report.AddUnkeyed(UnkeyedTrack{Index: i, Name: name, Reason: err.Error()})
+				}
+				continue
+			}
+			if err != nil {
+				return nil, fmt.Errorf("key detection failed for file %d: %v", i+1, err)
+			}
+			keys[i] = key.Camelot
+			if options.Report != nil {
+				options.Report.AddKey(TrackKey{
+					Index:   i,
+					Name:    name,
+					KeyInfo: *key,
+				})
+			}
+		}
+		return HarmonicOrderWithUnkeyed(keys), nil
+
+	default:
+		return nil, fmt.Errorf("unknown order: %s", options.Order)
+	}
+}
+
+// ValidateOrderIndices checks that indices name every one of count inputs exactly once
+func ValidateOrderIndices(indices []int, count int) error {
+	if len(indices) == 0 {
+		return fmt.Errorf("explicit order requires order_indices")
+	}
+	seen := make([]bool, count)
+	for _, index := range indices {
+		if index < 0 || index >= count {
+			return fmt.Errorf("order index %d out of range (0-%d)", index, count-1)
+		}
+		if seen[index] {
+			return fmt.Errorf("order index %d listed more than once", index)
+		}
+		seen[index] = true
+	}
+	if len(indices) != count {
+		return fmt.Errorf("order lists %d of %d inputs", len(indices), count)
+	}
+	return nil
+}
+
+// EnergyArc rearranges tracks sorted from low to high energy into a low-high-low arc
+func EnergyArc(sorted []int) []int {
+	var rising, falling []int
+	for i, index := range sorted {
+		if i%2 == 0 {
+			rising = append(rising, index)
+		} else {
+			falling = append(falling, index)
+		}
+	}
+	for i := len(falling) - 1; i >= 0; i-- {
+		rising = append(rising, falling[i])
+	}
+	return rising
+}
+
+// ShuffledLoopOrders returns a seeded permutation of n tracks for every loop after the first.
+// The first track of a loop never repeats the last track of the previous one when that can be avoided.
+func ShuffledLoopOrders(n, loops int, seed int64) [][]int {
+	orders := make([][]int, 0, loops)
+	previous := make([]int, n)
+	for i := range previous {
+		previous[i] = i
+	}
+	orders = append(orders, previous)
+
+	for loop := 1; loop < loops; loop++ {
+		order := rand.New(rand.NewSource(seed + int64(loop))).Perm(n)
+		if n > 1 && order[0] == previous[n-1] {
+			order[0], order[1] = order[1], order[0]
+		}
+		orders = append(orders, order)
+		previous = order
+	}
+	return orders
+}
+
+// measureTrackEnergy analyses the loudness and brightness of every input
+func measureTrackEnergy(files []string, options MixOptions) ([]*EnergyStats, error) {
+	stats := make([]*EnergyStats, len(files))
+	for i, file := range files {
+		energy, err := MeasureEnergy(file)
+		if err != nil {
+			return nil, fmt.Errorf("energy analysis failed for file %d: %v", i+1, err)
+		}
+		stats[i] = energy
+		if options.Report != nil {
+			options.Report.AddEnergy(TrackEnergy{
+				Index:       i,
+				Name:        options.InputName(i, filepath.Base(file)),
+				EnergyStats: *energy,
+			})
+		}
+	}
+	return stats, nil
+}
+
+// sortByScore sorts indices by ascending score, keeping upload order for ties
+func sortByScore(order []int, scores []float64) {
+	sort.SliceStable(order, func(a, b int) bool {
+		return scores[order[a]] < scores[order[b]]
+	})
+}
+
+// standardize rescales values to zero mean and unit variance
+func standardize(values []float64) []float64 {
+	var mean float64
+	for _, v := range values {
+		mean += v
+	}
+	mean /= float64(len(values))
+
+	var variance float64
+	for _, v := range values {
+		variance += (v - mean) * (v - mean)
+	}
+	stddev := math.Sqrt(variance / float64(len(values)))
+
+	scaled := make([]float64, len(values))
+	for i, v := range values {
+		if stddev > 0 {
+			scaled[i] = (v - mean) / stddev
+		}
+	}
+	return scaled
+}
+
+// HarmonicOrder walks the Camelot wheel from the first track, always moving to the
+// closest remaining key; ties keep upload order
+func HarmonicOrder(camelot []string) []int {
+	if len(camelot) == 0 {
+		return nil
+	}
+
+	used := make([]bool, len(camelot))
+	order := []int{0}
+	used[0] = true
+
+	for len(order) < len(camelot) {
+		current := camelot[order[len(order)-1]]
+		next := -1
+		for i, code := range camelot {
+			if used[i] {
+				continue
+			}
+			if next == -1 || CamelotDistance(current, code) < CamelotDistance(current, camelot[next]) {
+				next = i
+			}
+		}
+		used[next] = true
+		order = append(order, next)
+	}
+
+	return order
+}
+
+// HarmonicOrderWithUnkeyed orders the tracks with a Camelot code by HarmonicOrder and puts
+// the ones without a key (empty codes) after them in upload order
+func HarmonicOrderWithUnkeyed(camelot []string) []int {
+	var keyed, unkeyed []int
+	var codes []string
+	for i, code := range camelot {
+		if code == "" {
+			unkeyed = append(unkeyed, i)
+			continue
+		}
+		keyed = append(keyed, i)
+		codes = append(codes, code)
+	}
+
+	order := make([]int, 0, len(camelot))
+	for _, position := range HarmonicOrder(codes) {
+		order = append(order, keyed[position])
+	}
+	return append(order, unkeyed...)
+}
+
+// reorderStrings returns values arranged by order; missing entries stay empty
+func reorderStrings(values []string, order []int) []string {
+	if len(values) == 0 {
+		return values
+	}
+	reordered := make([]string, len(order))
+	for i, index := range order {
+		if index < len(values) {
+			reordered[i] = values[index]
+		}
+	}
+	return reordered
+}
@@ -0,0 +1,70 @@
+package utils
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestValidateOrderIndices(t *testing.T) {
+	valid := [][]int{{0}, {2, 0, 1}, {0, 1, 2}}
+	for _, indices := range valid {
+		if err := ValidateOrderIndices(indices, len(indices)); err != nil {
+			t.Errorf("%v: %v", indices, err)
+		}
+	}
+
+	invalid := map[string][]int{
+		"empty":        nil,
+		"out of range": {0, 1, 3},
+		"negative":     {-1, 0, 1},
+		"duplicate":    {0, 0, 1},
+		"missing":      {2, 0},
+		"extra":        {0, 1, 2, 1},
+	}
+	for name, indices := range invalid {
+		if err := ValidateOrderIndices(indices, 3); err == nil {
+			t.Errorf("%s %v accepted", name, indices)
+		}
+	}
+}
+
+func TestOrderTracksExplicit(t *testing.T) {
+	files := []string{"a.wav", "b.wav", "c.wav"}
+	options := MixOptions{Order: OrderExplicit, OrderIndices: []int{2, 0, 1}}
+	order, err := OrderTracks(files, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	order[0] = 1
+	if options.OrderIndices[0] != 2 {
+		t.Error("OrderTracks returned the requested indices themselves")
+	}
+
+	options.OrderIndices = []int{2, 2, 1}
+	if _, err := OrderTracks(files, options); err == nil {
+		t.Error("OrderTracks accepted a duplicate index")
+	}
+}
+
+func TestHarmonicOrderPutsUnkeyedTracksLast(t *testing.T) {
+	// Tracks 1 and 3 have no key
+	order := HarmonicOrderWithUnkeyed([]string{"8A", "", "3B", "", "9A"})
+	want := []int{0, 4, 2, 1, 3}
+	if len(order) != len(want) {
+		t.Fatalf("order = %v, want %v", order, want)
+	}
+	for i := range want {
+		if order[i] != want[i] {
+			t.Fatalf("order = %v, want %v", order, want)
+		}
+	}
+
+	if order := HarmonicOrderWithUnkeyed([]string{"", ""}); len(order) != 2 || order[0] != 0 || order[1] != 1 {
+		t.Errorf("without keys: order = %v, want upload order", order)
+	}
+
+	// A flat chroma has no key rather than failing outright
+	if _, err := KeyFromChroma(make([]float64, 12)); !errors.Is(err, ErrNoKey) {
+		t.Errorf("flat chroma: %v, want ErrNoKey", err)
+	}
+}
@@ -0,0 +1,280 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+)
+
+// TrackPreparer runs the per-track processing stages on every input before sequencing
+type TrackPreparer struct {
+	TempDir string
+	Options MixOptions
+}
+
+// NewTrackPreparer creates a new track preparer
+func NewTrackPreparer(tempDir string, options MixOptions) *TrackPreparer {
+	return &TrackPreparer{
+		TempDir: tempDir,
+		Options: options,
+	}
+}
+
+// PrepareTracks runs the per-track stages and ordering on the inputs. It returns the
+// files to sequence, in sequence order, and the options to sequence them with.
+func PrepareTracks(tempDir string, inputFiles []string, options MixOptions) ([]string, MixOptions, error) {
+	preparer := NewTrackPreparer(tempDir, options)
+	prepared, order, err := preparer.Prepare(inputFiles)
+	if err != nil {
+		return nil, options, err
+	}
+	if options.Report != nil {
+		options.Report.SetOrder(order)
+	}
+
+	options.InputNames = reorderStrings(options.InputNames, order)
+	indices := make([]int, len(order))
+	for i, index := range order {
+		indices[i] = options.InputIndex(index)
+	}
+	options.InputIndices = indices
+	return reorderStrings(prepared, order), options.WithoutTrackStages(), nil
+}
+
+// Prepare returns the processed file for each input, in the same order as inputFiles, and
+// the sequence order of the inputs
+func (tp *TrackPreparer) Prepare(inputFiles []string) ([]string, []int, error) {
+	prepared := make([]string, len(inputFiles))
+	copy(prepared, inputFiles)
+
+	if !tp.Options.HasTrackStages() {
+		order, err := OrderTracks(prepared, tp.Options)
+		return prepared, order, err
+	}
+
+	if err := os.MkdirAll(tp.TempDir, 0755); err != nil {
+		return nil, nil, fmt.Errorf("failed to create preparation directory: %v", err)
+	}
+
+	if tp.Options.NormalizeFormat {
+		if err := tp.normalizeFormats(prepared); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	tempos := make([]*TempoInfo, len(prepared))
+	misses := make([]error, len(prepared))
+	for i := range prepared {
+		if track, ok := tp.Options.TrackSettings(i); ok {
+			if track.CleanupOptions.Enabled() {
+				if err := tp.cleanTrack(prepared, i, track.CleanupOptions); err != nil {
+					return nil, nil, fmt.Errorf("file %d (%s): %v", i+1, tp.Options.InputName(i, filepath.Base(inputFiles[i])), err)
+				}
+			}
+			if len(track.EQ) > 0 {
+				if err := tp.equalizeTrack(prepared, i, track.EQ); err != nil {
+					return nil, nil, fmt.Errorf("file %d (%s): %v", i+1, tp.Options.InputName(i, filepath.Base(inputFiles[i])), err)
+				}
+			}
+		}
+		if tp.Options.TrimSilence {
+			if err := tp.trimSilence(prepared, i); err != nil {
+				return nil, nil, fmt.Errorf("file %d (%s): %v", i+1, tp.Options.InputName(i, filepath.Base(inputFiles[i])), err)
+			}
+		}
+		if tp.Options.NeedsTempo() {
+			tempo, err := tp.detectTempo(prepared, i)
+			if errors.Is(err, ErrNoTempo) {
+				// Pads and drones stay unstretched; the sequencer crossfades them in seconds
+				misses[i] = err
+				continue
+			}
+			if err != nil {
+				return nil, nil, fmt.Errorf("file %d (%s): %v", i+1, tp.Options.InputName(i, filepath.Base(inputFiles[i])), err)
+			}
+			tempos[i] = tempo
+		}
+	}
+
+	// Ordering looks at the tracks before stretching, which keeps their length and key
+	order, err := OrderTracks(prepared, tp.Options)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Time stretching needs every tempo and the order first when matching to the first track
+	if tp.Options.StretchesTempo() {
+		target := tp.Options.TargetBPM
+		if tp.Options.MatchFirstTempo {
+			// The first track in sequence order that has a tempo sets it
+			target = 0
+			for _, index := range order {
+				if tempos[index] != nil {
+					target = tempos[index].BPM
+					break
+				}
+			}
+		}
+		for i := range prepared {
+			if tempos[i] == nil {
+				tp.skipStretch(prepared, i, misses[i])
+				continue
+			}
+			if err := tp.stretchTempo(prepared, i, tempos[i], target); err != nil {
+				return nil, nil, fmt.Errorf("file %d (%s): %v", i+1, tp.Options.InputName(i, filepath.Base(inputFiles[i])), err)
+			}
+		}
+	}
+
+	if tp.Options.ExportStems {
+		if err := tp.exportStems(prepared); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return prepared, order, nil
+}
+
+// normalizeFormats converts every input to a common sample rate and channel layout
+func (tp *TrackPreparer) normalizeFormats(files []string) error {
+	normalizer := NewFormatNormalizer(tp.TempDir)
+	normalizer.Formats = tp.Options.KnownFormats
+	report, err := normalizer.Normalize(files, tp.Options.InputNames)
+	if err != nil {
+		return fmt.Errorf("format normalization failed: %v", err)
+	}
+
+	if tp.Options.Report != nil {
+		tp.Options.Report.SetNormalization(*report)
+	}
+	return nil
+}
+
+// cleanTrack removes hum and noise from track i
+func (tp *TrackPreparer) cleanTrack(files []string, i int, options CleanupOptions) error {
+	enhancer := NewAudioEnhancer(tp.TempDir)
+	outputFile := filepath.Join(tp.TempDir, fmt.Sprintf("cleaned_%d.wav", i))
+
+	cleaned, report, err := enhancer.ApplyCleanup(files[i], outputFile, options)
+	if err != nil {
+		return err
+	}
+
+	report.Target = "track"
+	report.Index = i
+	report.Name = tp.Options.InputName(i, filepath.Base(files[i]))
+	if tp.Options.Report != nil {
+		tp.Options.Report.AddCleanup(*report)
+	}
+
+	files[i] = cleaned
+	return nil
+}
+
+// equalizeTrack applies the parametric EQ of track i
+func (tp *TrackPreparer) equalizeTrack(files []string, i int, bands []EQBand) error {
+	enhancer := NewAudioEnhancer(tp.TempDir)
+	outputFile := filepath.Join(tp.TempDir, fmt.Sprintf("eq_%d.wav", i))
+	if err := enhancer.ApplyEQ(files[i], outputFile, bands); err != nil {
+		return err
+	}
+	files[i] = outputFile
+	return nil
+}
+
+// trimSilence removes leading and trailing silence from track i
+func (tp *TrackPreparer) trimSilence(files []string, i int) error {
+	trimmer := NewSilenceTrimmer(tp.Options.SilenceThreshold, tp.Options.SilenceMinDuration)
+	outputFile := filepath.Join(tp.TempDir, fmt.Sprintf("trimmed_%d.wav", i))
+
+	trimmed, result, err := trimmer.Trim(files[i], outputFile)
+	if err != nil {
+		return err
+	}
+
+	result.Index = i
+	result.Name = tp.Options.InputName(i, filepath.Base(files[i]))
+	if tp.Options.Report != nil {
+		tp.Options.Report.AddTrim(*result)
+	}
+
+	files[i] = trimmed
+	return nil
+}
+
+// detectTempo analyses the beat grid of track i; results are cached for the sequencer
+func (tp *TrackPreparer) detectTempo(files []string, i int) (*TempoInfo, error) {
+	tempo, err := tp.Options.Tempos.Detect(files[i])
+	if err != nil {
+		return nil, fmt.Errorf("tempo detection failed: %w", err)
+	}
+
+	if tp.Options.Report != nil {
+		tp.Options.Report.AddTempo(TrackTempo{
+			Index:     i,
+			Name:      tp.Options.InputName(i, filepath.Base(files[i])),
+			TempoInfo: *tempo,
+		})
+	}
+	return tempo, nil
+}
+
+// skipStretch reports that track i stays unstretched because it has no tempo
+func (tp *TrackPreparer) skipStretch(files []string, i int, reason error) {
+	if tp.Options.Report == nil {
+		return
+	}
+	tp.Options.Report.AddStretch(StretchResult{
+		Index:   i,
+		Name:    tp.Options.InputName(i, filepath.Base(files[i])),
+		Ratio:   1,
+		Skipped: true,
+		Reason:  reason.Error(),
+	})
+}
+
+// stretchTempo time-stretches track i to the target tempo when the change is within the allowed range
+func (tp *TrackPreparer) stretchTempo(files []string, i int, tempo *TempoInfo, target float64) error {
+	ratio := StretchRatio(tempo.BPM, target)
+	result := StretchResult{
+		Index:       i,
+		Name:        tp.Options.InputName(i, filepath.Base(files[i])),
+		OriginalBPM: tempo.BPM,
+		TargetBPM:   target,
+		Ratio:       math.Round(ratio*10000) / 10000,
+	}
+
+	stretch := math.Abs(ratio-1) * 100
+	switch {
+	case stretch < 0.1:
+		result.Skipped = true
+		result.Reason = "already at target tempo"
+	case stretch > tp.Options.MaxStretchPercent:
+		result.Skipped = true
+		result.Reason = fmt.Sprintf("needs %.1f%% stretch, limit is %.1f%%", stretch, tp.Options.MaxStretchPercent)
+	default:
+		outputFile := filepath.Join(tp.TempDir, fmt.Sprintf("stretched_%d.wav", i))
+		method, err := TimeStretch(files[i], outputFile, ratio)
+		if err != nil {
+			return err
+		}
+		result.Method = method
+
+		// The beat grid scales with the stretch, so the sequencer doesn't need to detect it again
+		tp.Options.Tempos.Remember(outputFile, &TempoInfo{
+			BPM:           tempo.BPM * ratio,
+			Confidence:    tempo.Confidence,
+			FirstBeat:     tempo.FirstBeat / ratio,
+			FirstDownbeat: tempo.FirstDownbeat / ratio,
+			BeatsPerBar:   tempo.BeatsPerBar,
+		})
+		files[i] = outputFile
+	}
+
+	if tp.Options.Report != nil {
+		tp.Options.Report.AddStretch(result)
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package utils
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPrepareMatchesFirstTempoInSequenceOrder(t *testing.T) {
+	dir := t.TempDir()
+	tempos := NewTempoCache()
+	var files []string
+	for i, bpm := range []float64{120, 128} {
+		file := filepath.Join(dir, fmt.Sprintf("track_%d.wav", i))
+		if err := os.WriteFile(file, []byte("RIFF"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		tempos.Remember(file, &TempoInfo{BPM: bpm, Confidence: 0.9, BeatsPerBar: 4})
+		files = append(files, file)
+	}
+
+	report := NewJobReport("prepare")
+	options := MixOptions{
+		MatchFirstTempo: true,
+		// Small enough that nothing is stretched, so the test needs no ffmpeg
+		MaxStretchPercent: 1,
+		Order:             OrderExplicit,
+		OrderIndices:      []int{1, 0},
+		Tempos:            tempos,
+		Report:            report,
+	}
+	prepared, order, err := NewTrackPreparer(filepath.Join(dir, "prepared"), options).Prepare(files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(order) != 2 || order[0] != 1 || order[1] != 0 {
+		t.Errorf("order = %v, want [1 0]", order)
+	}
+	if len(prepared) != 2 || prepared[0] != files[0] || prepared[1] != files[1] {
+		t.Errorf("prepared = %v, want the inputs in upload order", prepared)
+	}
+
+	// The track that opens the sequence sets the tempo, not the first upload
+	if len(report.Stretched) != 2 {
+		t.Fatalf("%d stretch results, want 2", len(report.Stretched))
+	}
+	for _, result := range report.Stretched {
+		if result.TargetBPM != 128 {
+			t.Errorf("track %d stretched to %g BPM, want 128", result.Index, result.TargetBPM)
+		}
+	}
+}
+
+func TestPrepareLeavesTracksWithoutTempoUnstretched(t *testing.T) {
+	dir := t.TempDir()
+	tempos := NewTempoCache()
+	var files []string
+	for i, bpm := range []float64{0, 124, 126} {
+		file := filepath.Join(dir, fmt.Sprintf("track_%d.wav", i))
+		if err := os.WriteFile(file, []byte("RIFF"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if bpm == 0 {
+			// A drone: the cache already knows it has no beat grid
+			tempos.misses[tempoCacheKey(file)] = fmt.Errorf("%w: no rhythmic content", ErrNoTempo)
+		} else {
+			tempos.Remember(file, &TempoInfo{BPM: bpm, Confidence: 0.9, BeatsPerBar: 4})
+		}
+		files = append(files, file)
+	}
+
+	report := NewJobReport("prepare")
+	options := MixOptions{
+		MatchFirstTempo:   true,
+		MaxStretchPercent: 1,
+		Order:             OrderExplicit,
+		OrderIndices:      []int{0, 2, 1},
+		Tempos:            tempos,
+		Report:            report,
+	}
+	if _, _, err := NewTrackPreparer(filepath.Join(dir, "prepared"), options).Prepare(files); err != nil {
+		t.Fatalf("a track without a tempo failed the preparation: %v", err)
+	}
+
+	if len(report.Stretched) != 3 {
+		t.Fatalf("%d stretch results, want 3", len(report.Stretched))
+	}
+	for _, result := range report.Stretched {
+		if result.Index == 0 {
+			if !result.Skipped || result.Reason == "" {
+				t.Errorf("drone: %+v, want skipped with a reason", result)
+			}
+			continue
+		}
+		// The drone opens the sequence, so the next track with a tempo sets it
+		if result.TargetBPM != 126 {
+			t.Errorf("track %d stretched to %g BPM, want 126", result.Index, result.TargetBPM)
+		}
+	}
+}
@@ -0,0 +1,197 @@
+package utils
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// TrackMarker is where one track, in one loop iteration, plays in the output
+type TrackMarker struct {
+	Index int     `json:"index"` // upload index
+	Name  string  `json:"name"`
+	Loop  int     `json:"loop"`  // loop iteration, from 1
+	Start float64 `json:"start"` // seconds; with crossfades, where the track starts fading in
+	End   float64 `json:"end"`
+	Fade  float64 `json:"fade,omitempty"` // length of the crossfade into the track
+
+	// SourceStart is where in the prepared track playback starts, e.g. its first downbeat
+	SourceStart float64 `json:"source_start,omitempty"`
+}
+
+// Title is the chapter title of the marker, with the loop iteration when there are several
+func (tm TrackMarker) Title(loops int) string {
+	name := tm.Name
+	if name == "" {
+		name = fmt.Sprintf("Track %d", tm.Index+1)
+	}
+	if loops > 1 {
+		return fmt.Sprintf("%s (loop %d)", name, tm.Loop)
+	}
+	return name
+}
+
+// ShiftMarkers returns a copy of markers moved by offset seconds and set to loop iteration loop
+func ShiftMarkers(markers []TrackMarker, offset float64, loop int) []TrackMarker {
+	shifted := make([]TrackMarker, len(markers))
+	for i, marker := range markers {
+		marker.Start += offset
+		marker.End += offset
+		marker.Loop = loop
+		shifted[i] = marker
+	}
+	return shifted
+}
+
+// FinishTimeline sorts the markers, drops those past the end and sets every end to the next
+// start, or to duration for the last one. Times are rounded to milliseconds.
+func FinishTimeline(markers []TrackMarker, duration float64) []TrackMarker {
+	sort.SliceStable(markers, func(i, j int) bool { return markers[i].Start < markers[j].Start })
+
+	finished := make([]TrackMarker, 0, len(markers))
+	for _, marker := range markers {
+		marker.Start = math.Max(0, roundMillis(marker.Start))
+		if marker.Start >= duration {
+			break
+		}
+		finished = append(finished, marker)
+	}
+	for i := range finished {
+		if i+1 < len(finished) {
+			finished[i].End = finished[i+1].Start
+		} else {
+			finished[i].End = roundMillis(duration)
+		}
+	}
+	return finished
+}
+
+func roundMillis(seconds float64) float64 {
+	return math.Round(seconds*1000) / 1000
+}
+
+// LoopCount returns the number of loop iterations in markers
+func LoopCount(markers []TrackMarker) int {
+	loops := 1
+	for _, marker := range markers {
+		if marker.Loop > loops {
+			loops = marker.Loop
+		}
+	}
+	return loops
+}
+
+// CueSheet renders the markers as a CUE sheet for fileName. INDEX times are in
+// minutes:seconds:frames at 75 frames per second.
+func CueSheet(markers []TrackMarker, metadata Metadata, fileName, format string) string {
+	var b strings.Builder
+	if metadata.Genre != "" {
+		fmt.Fprintf(&b, "REM GENRE %s\n", cueQuote(metadata.Genre))
+	}
+	if metadata.Year != "" {
+		fmt.Fprintf(&b, "REM DATE %s\n", metadata.Year)
+	}
+	if metadata.Comment != "" {
+		fmt.Fprintf(&b, "REM COMMENT %s\n", cueQuote(metadata.Comment))
+	}
+	if metadata.Artist != "" {
+		fmt.Fprintf(&b, "PERFORMER %s\n", cueQuote(metadata.Artist))
+	}
+	if title := metadata.Title; title != "" || metadata.Album != "" {
+		if title == "" {
+			title = metadata.Album
+		}
+		fmt.Fprintf(&b, "TITLE %s\n", cueQuote(title))
+	}
+
+	fileType := "WAVE"
+	switch format {
+	case "mp3":
+		fileType = "MP3"
+	case "m4a":
+		fileType = "MP4"
+	}
+	fmt.Fprintf(&b, "FILE %s %s\n", cueQuote(fileName), fileType)
+
+	loops := LoopCount(markers)
+	for i, marker := range markers {
+		fmt.Fprintf(&b, "  TRACK %02d AUDIO\n", i+1)
+		fmt.Fprintf(&b, "    TITLE %s\n", cueQuote(marker.Title(loops)))
+		fmt.Fprintf(&b, "    INDEX 01 %s\n", CueTime(marker.Start))
+	}
+	return b.String()
+}
+
+// CueTime formats seconds as a CUE mm:ss:ff time
+func CueTime(seconds float64) string {
+	frames := int64(math.Round(seconds * 75))
+	return fmt.Sprintf("%02d:%02d:%02d", frames/(75*60), frames/75%60, frames%75)
+}
+
+func cueQuote(value string) string {
+	return `"` + strings.ReplaceAll(value, `"`, "'") + `"`
+}
+
+// M3U8Playlist renders the markers as an extended M3U playlist of the original source
+// files, one entry per track and loop iteration with its length in the mix
+func M3U8Playlist(markers []TrackMarker, metadata Metadata) string {
+	var b strings.Builder
+	b.WriteString("#EXTM3U\n")
+	if metadata.Title != "" {
+		fmt.Fprintf(&b, "#PLAYLIST:%s\n", metadata.Title)
+	}
+	if metadata.Artist != "" {
+		fmt.Fprintf(&b, "#EXTART:%s\n", metadata.Artist)
+	}
+	if metadata.Album != "" {
+		fmt.Fprintf(&b, "#EXTALB:%s\n", metadata.Album)
+	}
+	if metadata.Genre != "" {
+		fmt.Fprintf(&b, "#EXTGENRE:%s\n", metadata.Genre)
+	}
+
+	loops := LoopCount(markers)
+	for _, marker := range markers {
+		name := marker.Name
+		if name == "" {
+			name = fmt.Sprintf("track_%d", marker.Index+1)
+		}
+		fmt.Fprintf(&b, "#EXTINF:%d,%s\n", int64(math.Round(marker.End-marker.Start)), marker.Title(loops))
+		fmt.Fprintf(&b, "%s\n", name)
+	}
+	return b.String()
+}
+
+// YouTubeTimestamps renders the markers as "m:ss Title" lines for a video description.
+// Hours are only shown when the mix is an hour or longer.
+func YouTubeTimestamps(markers []TrackMarker) string {
+	hours := len(markers) > 0 && markers[len(markers)-1].Start >= 3600
+	loops := LoopCount(markers)
+
+	var b strings.Builder
+	for _, marker := range markers {
+		seconds := int64(marker.Start)
+		if hours {
+			fmt.Fprintf(&b, "%d:%02d:%02d", seconds/3600, seconds/60%60, seconds%60)
+		} else {
+			fmt.Fprintf(&b, "%d:%02d", seconds/60, seconds%60)
+		}
+		fmt.Fprintf(&b, " %s\n", marker.Title(loops))
+	}
+	return b.String()
+}
+
+// FFMetadataChapters renders the markers as ffmetadata chapter sections
+func FFMetadataChapters(markers []TrackMarker) string {
+	loops := LoopCount(markers)
+
+	var b strings.Builder
+	for _, marker := range markers {
+		b.WriteString("[CHAPTER]\nTIMEBASE=1/1000\n")
+		fmt.Fprintf(&b, "START=%d\n", int64(math.Round(marker.Start*1000)))
+		fmt.Fprintf(&b, "END=%d\n", int64(math.Round(marker.End*1000)))
+		fmt.Fprintf(&b, "title=%s\n", escapeFFMetadata(marker.Title(loops)))
+	}
+	return b.String()
+}
@@ -0,0 +1,362 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// uploadIDPattern matches the IDs the upload store hands out
+var uploadIDPattern = regexp.MustCompile(`^[0-9a-f]{32}$`)
+
+// uploadExtPattern limits the kept file extension to plain ones
+var uploadExtPattern = regexp.MustCompile(`^\.[a-z0-9]{1,8}$`)
+
+// ErrUploadOffset is returned when a chunk doesn't continue where the upload stands
+var ErrUploadOffset = errors.New("upload offset mismatch")
+
+// Upload is a resumable upload; its data is stored in parts until Offset reaches Length.
+// AssetID is set once the complete upload was imported into the asset library.
+type Upload struct {
+	ID       string            `json:"id"`
+	Length   int64             `json:"length"`
+	Offset   int64             `json:"offset"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+	Created  int64             `json:"created"`
+	AssetID  string            `json:"asset_id,omitempty"`
+}
+
+// Complete reports whether all data of the upload has arrived
+func (u *Upload) Complete() bool {
+	return u.Offset == u.Length
+}
+
+// FileName is the client file name from the upload metadata, if any
+func (u *Upload) FileName() string {
+	if u.Metadata["filename"] == "" {
+		return ""
+	}
+	return filepath.Base(u.Metadata["filename"])
+}
+
+// UploadStore keeps resumable uploads in the storage as <Prefix>/<id>.json with the upload
+// info and one <Prefix>/<id>/<offset> object per received chunk, so offsets survive restarts
+// and a chunk can land on any instance. Objects can't be appended to, hence the parts. The
+// per-upload locks only order chunks within one instance; across instances, parts are
+// created conditionally, so of two chunks for the same offset only the first is kept.
+type UploadStore struct {
+	Storage   Storage
+	Prefix    string
+	WorkDir   string
+	Retention time.Duration
+	locks     map[string]*sync.Mutex
+	mutex     sync.Mutex
+}
+
+// NewUploadStore creates a new upload store
+func NewUploadStore(storage Storage, prefix, workDir string, retention time.Duration) *UploadStore {
+	return &UploadStore{
+		Storage:   storage,
+		Prefix:    prefix,
+		WorkDir:   workDir,
+		Retention: retention,
+		locks:     make(map[string]*sync.Mutex),
+	}
+}
+
+// GlobalUploadStore is the shared store for resumable uploads
+var GlobalUploadStore = NewUploadStore(GlobalStorage, "resumable", filepath.Join(GlobalConfig.WorkDir, "resumable"), GlobalConfig.ResultRetention)
+
+// Create starts an upload of length bytes
+func (us *UploadStore) Create(length int64, metadata map[string]string) (*Upload, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	upload := &Upload{
+		ID:       hex.EncodeToString(id),
+		Length:   length,
+		Metadata: metadata,
+		Created:  time.Now().UnixMilli(),
+	}
+	if err := us.writeInfo(upload); err != nil {
+		return nil, fmt.Errorf("failed to create upload: %v", err)
+	}
+	return upload, nil
+}
+
+// Get returns an upload with the offset read from its stored parts
+func (us *UploadStore) Get(id string) (*Upload, error) {
+	upload, err := us.readInfo(id)
+	if err != nil {
+		return nil, err
+	}
+	if upload.AssetID != "" {
+		// Finished uploads keep their offset in the info, their parts are gone
+		return upload, nil
+	}
+
+	parts, err := us.parts(id)
+	if err != nil {
+		return nil, err
+	}
+	for _, part := range parts {
+		upload.Offset += part.Size
+	}
+	return upload, nil
+}
+
+// Append stores data at offset, which must be the current offset of the upload, and returns
+// the upload with its new offset. Whatever arrived before a read error is kept, so the
+// client can resume from there.
+func (us *UploadStore) Append(id string, offset int64, data io.Reader) (*Upload, error) {
+	lock := us.lock(id)
+	lock.Lock()
+	defer lock.Unlock()
+
+	upload, err := us.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	if offset != upload.Offset {
+		return upload, ErrUploadOffset
+	}
+
+	// The chunk is spooled to disk first, since its size must be known to store it
+	if err := os.MkdirAll(us.WorkDir, 0755); err != nil {
+		return nil, err
+	}
+	spool, err := os.CreateTemp(us.WorkDir, upload.ID+"_*.part")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(spool.Name())
+	written, copyErr := io.Copy(spool, io.LimitReader(data, upload.Length-upload.Offset))
+	if err := spool.Close(); err != nil && copyErr == nil {
+		copyErr = err
+	}
+	if written == 0 {
+		return upload, copyErr
+	}
+
+	err = CreateFile(us.Storage, us.partKey(upload.ID, upload.Offset), spool.Name())
+	if errors.Is(err, ErrObjectExists) {
+		// Another instance stored a chunk at this offset first
+		current, err := us.Get(id)
+		if err != nil {
+			return nil, err
+		}
+		return current, ErrUploadOffset
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to store upload data: %v", err)
+	}
+	upload.Offset += written
+	return upload, copyErr
+}
+
+// Assemble joins the parts of a complete upload into a local file and returns its path. The
+// file keeps the extension of the client file name, since inputs are validated by it.
+func (us *UploadStore) Assemble(upload *Upload) (string, error) {
+	parts, err := us.parts(upload.ID)
+	if err != nil {
+		return "", err
+	}
+
+	ext := strings.ToLower(filepath.Ext(upload.FileName()))
+	if !uploadExtPattern.MatchString(ext) {
+		ext = ".bin"
+	}
+	if err := os.MkdirAll(us.WorkDir, 0755); err != nil {
+		return "", err
+	}
+	file := filepath.Join(us.WorkDir, upload.ID+ext)
+	dst, err := os.Create(file)
+	if err != nil {
+		return "", err
+	}
+	for _, part := range parts {
+		if err = us.copyPart(dst, part.Key); err != nil {
+			break
+		}
+	}
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(file)
+		return "", fmt.Errorf("failed to assemble upload: %v", err)
+	}
+	return file, nil
+}
+
+// Finish records the asset a complete upload was imported as and removes its parts. The
+// upload info stays until it expires, so a client that lost the last response can still
+// learn the asset ID with HEAD.
+func (us *UploadStore) Finish(upload *Upload, assetID string) error {
+	lock := us.lock(upload.ID)
+	lock.Lock()
+	defer lock.Unlock()
+
+	upload.AssetID = assetID
+	if err := us.writeInfo(upload); err != nil {
+		return fmt.Errorf("failed to finish upload: %v", err)
+	}
+	return DeletePrefix(us.Storage, us.key(upload.ID)+"/")
+}
+
+// Delete removes an upload and whatever is left of its data
+func (us *UploadStore) Delete(id string) error {
+	if _, err := us.readInfo(id); err != nil {
+		return err
+	}
+	lock := us.lock(id)
+	lock.Lock()
+	defer lock.Unlock()
+
+	if err := DeletePrefix(us.Storage, us.key(id)+"/"); err != nil {
+		return err
+	}
+	err := us.Storage.Delete(us.key(id) + ".json")
+	us.mutex.Lock()
+	delete(us.locks, id)
+	us.mutex.Unlock()
+	return err
+}
+
+// Expires is when an upload is removed unless it is resumed before
+func (us *UploadStore) Expires(id string) time.Time {
+	objects, err := us.Storage.List(us.key(id))
+	if err != nil {
+		return time.Now()
+	}
+	var modTime time.Time
+	for _, object := range objects {
+		if object.ModTime.After(modTime) {
+			modTime = object.ModTime
+		}
+	}
+	if modTime.IsZero() {
+		// Nothing is left of the upload
+		return time.Now()
+	}
+	return modTime.Add(us.Retention)
+}
+
+// Cleanup removes uploads that weren't resumed during the retention period
+func (us *UploadStore) Cleanup() {
+	objects, err := us.Storage.List(us.Prefix + "/")
+	if err != nil {
+		log.Printf("Failed to list uploads: %v", err)
+		return
+	}
+
+	for _, object := range objects {
+		id, ok := strings.CutSuffix(strings.TrimPrefix(object.Key, us.Prefix+"/"), ".json")
+		if !ok || !uploadIDPattern.MatchString(id) || us.Expires(id).After(time.Now()) {
+			continue
+		}
+		if err := us.Delete(id); err != nil {
+			log.Printf("Failed to remove expired upload %s: %v", id, err)
+		}
+	}
+}
+
+// StartCleanup runs Cleanup every interval in the background
+func (us *UploadStore) StartCleanup(interval time.Duration) {
+	go func() {
+		for range time.Tick(interval) {
+			us.Cleanup()
+		}
+	}()
+}
+
+// key is the storage key of an upload, without the info or part suffix
+func (us *UploadStore) key(id string) string {
+	return path.Join(us.Prefix, id)
+}
+
+// partKey is the key of the part starting at offset; the offset is zero padded so parts
+// list in order
+func (us *UploadStore) partKey(id string, offset int64) string {
+	return fmt.Sprintf("%s/%020d", us.key(id), offset)
+}
+
+// parts returns the stored parts of an upload in order, up to the first gap
+func (us *UploadStore) parts(id string) ([]ObjectInfo, error) {
+	objects, err := us.Storage.List(us.key(id) + "/")
+	if err != nil {
+		return nil, err
+	}
+
+	var parts []ObjectInfo
+	var offset int64
+	for _, object := range objects {
+		start, err := strconv.ParseInt(path.Base(object.Key), 10, 64)
+		if err != nil || start != offset {
+			break
+		}
+		parts = append(parts, object)
+		offset += object.Size
+	}
+	return parts, nil
+}
+
+func (us *UploadStore) copyPart(dst io.Writer, key string) error {
+	src, _, err := us.Storage.Get(key)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	_, err = io.Copy(dst, src)
+	return err
+}
+
+func (us *UploadStore) readInfo(id string) (*Upload, error) {
+	if !uploadIDPattern.MatchString(id) {
+		return nil, os.ErrNotExist
+	}
+	src, _, err := us.Storage.Get(us.key(id) + ".json")
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+
+	var upload Upload
+	if err := json.NewDecoder(src).Decode(&upload); err != nil {
+		return nil, fmt.Errorf("corrupt upload info: %v", err)
+	}
+	return &upload, nil
+}
+
+func (us *UploadStore) writeInfo(upload *Upload) error {
+	data, err := json.Marshal(upload)
+	if err != nil {
+		return err
+	}
+	return us.Storage.Put(us.key(upload.ID)+".json", bytes.NewReader(data), int64(len(data)))
+}
+
+func (us *UploadStore) lock(id string) *sync.Mutex {
+	us.mutex.Lock()
+	defer us.mutex.Unlock()
+	lock, ok := us.locks[id]
+	if !ok {
+		lock = &sync.Mutex{}
+		us.locks[id] = lock
+	}
+	return lock
+}
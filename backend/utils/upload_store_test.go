@@ -0,0 +1,91 @@
+package utils
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUploadStoreKeepsFinishedUploads(t *testing.T) {
+	dir := t.TempDir()
+	store := NewUploadStore(NewLocalStorage(dir), "resumable", t.TempDir(), time.Hour)
+
+	upload, err := store.Create(10, map[string]string{"filename": "loop.wav"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if upload, err = store.Append(upload.ID, 0, strings.NewReader("01234")); err != nil {
+		t.Fatal(err)
+	}
+	if upload, err = store.Append(upload.ID, 5, strings.NewReader("56789")); err != nil {
+		t.Fatal(err)
+	}
+	if !upload.Complete() {
+		t.Fatalf("upload at %d of %d bytes, want complete", upload.Offset, upload.Length)
+	}
+
+	if err := store.Finish(upload, "asset123"); err != nil {
+		t.Fatal(err)
+	}
+	parts, err := store.parts(upload.ID)
+	if err != nil || len(parts) != 0 {
+		t.Errorf("finished upload kept %d parts (%v)", len(parts), err)
+	}
+
+	// A client that lost the last response learns the asset ID from HEAD
+	finished, err := store.Get(upload.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if finished.AssetID != "asset123" || !finished.Complete() {
+		t.Errorf("Get = %+v, want the complete upload of asset123", finished)
+	}
+	if expires := store.Expires(upload.ID); expires.Before(time.Now().Add(59 * time.Minute)) {
+		t.Errorf("finished upload expires at %v, want about an hour from now", expires)
+	}
+
+	if err := store.Delete(upload.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Get(upload.ID); err == nil {
+		t.Error("Get of a deleted upload succeeded")
+	}
+}
+
+// racingStorage stores a chunk of another instance right before each part is created
+type racingStorage struct {
+	Storage
+}
+
+func (rs *racingStorage) Create(key string, r io.Reader, size int64) error {
+	rs.Storage.Put(key, strings.NewReader("other"), 5)
+	return rs.Storage.Create(key, r, size)
+}
+
+func TestUploadStoreKeepsTheFirstChunkForAnOffset(t *testing.T) {
+	storage := NewLocalStorage(t.TempDir())
+	store := NewUploadStore(&racingStorage{Storage: storage}, "resumable", t.TempDir(), time.Hour)
+
+	upload, err := store.Create(10, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	current, err := store.Append(upload.ID, 0, strings.NewReader("01234"))
+	if !errors.Is(err, ErrUploadOffset) {
+		t.Fatalf("Append racing another instance = %v, want ErrUploadOffset", err)
+	}
+	if current.Offset != 5 {
+		t.Errorf("offset after the race = %d, want the other instance's 5", current.Offset)
+	}
+
+	src, _, err := storage.Get(store.partKey(upload.ID, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+	if data, _ := io.ReadAll(src); string(data) != "other" {
+		t.Errorf("part = %q, want the first chunk to stay", data)
+	}
+}